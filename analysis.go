@@ -0,0 +1,383 @@
+package chip8
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+// ByteKind is what a byte of a ROM was found to be.
+type ByteKind uint8
+
+const (
+	// ByteUnknown was never reached or referenced, and is shown as data
+	ByteUnknown ByteKind = iota
+	// ByteCode is part of an instruction reached from the entry point
+	ByteCode
+	// ByteSprite is drawn by a DRW after an LD I pointing at it
+	ByteSprite
+	// ByteData is pointed at by LD I but not drawn, like a table
+	ByteData
+)
+
+func (k ByteKind) String() string {
+	switch k {
+	case ByteCode:
+		return "code"
+	case ByteSprite:
+		return "sprite"
+	case ByteData:
+		return "data"
+	}
+	return "unknown"
+}
+
+// maxAnalysisStates bounds how many paths Analyze follows.
+const maxAnalysisStates = 1 << 18
+
+// Analysis is a disassembly of a whole ROM that follows the flow of control
+// from the entry point, separating code from data.
+type Analysis struct {
+	// Base is where the ROM is loaded
+	Base uint16
+	ROM  []byte
+	// Entry is where execution starts
+	Entry uint16
+	// Linear is set if the ROM was decoded in order rather than by
+	// following the code
+	Linear bool
+
+	kinds  []ByteKind
+	ins    map[uint16]Instruction
+	labels map[uint16]string
+	// refs are the addresses instructions refer to, and how
+	refs map[uint16]refKind
+	dis  *Disassembler
+}
+
+// refKind is how an address is referred to, which names its label. Later
+// kinds take precedence.
+type refKind int
+
+const (
+	refData refKind = iota + 1
+	refSprite
+	refJump
+	refCall
+)
+
+var refPrefixes = map[refKind]string{
+	refData:   "data",
+	refSprite: "spr",
+	refJump:   "loc",
+	refCall:   "sub",
+}
+
+// flowState is what's known at an address on one path through the code.
+type flowState struct {
+	addr uint16
+	// i is the value of I if known
+	i      uint16
+	iKnown bool
+	// planes is how many bitplanes DRW draws
+	planes int
+}
+
+// Analyze disassembles rom, loaded at 0x200, by following jumps, calls and
+// both sides of skips from 0x200. Bytes pointed at by LD I and then drawn by
+// DRW are marked as sprites. Everything that isn't reached is data.
+// Addresses are labelled with the Disassembler's symbols where it has them,
+// and otherwise named after how they are used, like sub_2A4 for a
+// subroutine.
+func (d *Disassembler) Analyze(rom []byte) *Analysis {
+	a := d.newAnalysis(rom)
+	a.walk()
+	a.name()
+	return a
+}
+
+// AnalyzeLinear disassembles rom, loaded at 0x200, an instruction after
+// another from 0x200 like a simple disassembler, so data is shown as code.
+// Words that aren't instructions are data, and the next is decoded two bytes
+// on.
+func (d *Disassembler) AnalyzeLinear(rom []byte) *Analysis {
+	a := d.newAnalysis(rom)
+	a.Linear = true
+	for addr := a.Base; a.inROM(addr, 1); {
+		ins, ok := a.decode(addr)
+		if !ok {
+			addr += 2
+			continue
+		}
+		a.ins[addr] = ins
+		for n := 0; n < ins.Length; n++ {
+			a.kinds[int(addr-a.Base)+n] = ByteCode
+		}
+		switch {
+		case ins.Op&0xF000 == 0xA000 || ins.Length == 4:
+			a.ref(ins.Operands[1].Value, refData)
+		case ins.IsCall():
+			a.ref(ins.Target, refCall)
+		case ins.IsBranch():
+			a.ref(ins.Target, refJump)
+		}
+		addr += uint16(ins.Length)
+	}
+	a.name()
+	return a
+}
+
+func (d *Disassembler) newAnalysis(rom []byte) *Analysis {
+	return &Analysis{
+		Base:   0x200,
+		ROM:    rom,
+		Entry:  0x200,
+		kinds:  make([]ByteKind, len(rom)),
+		ins:    make(map[uint16]Instruction),
+		labels: make(map[uint16]string),
+		refs:   make(map[uint16]refKind),
+		dis:    d,
+	}
+}
+
+// inROM reports whether n bytes from addr are in the ROM.
+func (a *Analysis) inROM(addr uint16, n int) bool {
+	return addr >= a.Base && int(addr-a.Base)+n <= len(a.ROM)
+}
+
+func (a *Analysis) ref(addr uint16, kind refKind) {
+	if a.refs[addr] < kind {
+		a.refs[addr] = kind
+	}
+}
+
+// decode decodes the instruction at addr, reporting false if it's outside
+// the ROM, illegal, or overlaps an instruction decoded at another address.
+func (a *Analysis) decode(addr uint16) (Instruction, bool) {
+	if ins, ok := a.ins[addr]; ok {
+		return ins, true
+	}
+	if !a.inROM(addr, 2) {
+		return Instruction{}, false
+	}
+	ins := DecodeBytes(a.ROM[addr-a.Base:])
+	if ins.Mnemonic == MnemonicIllegal {
+		return ins, false
+	}
+	for n := 0; n < ins.Length; n++ {
+		if a.kinds[int(addr-a.Base)+n] == ByteCode {
+			return ins, false
+		}
+	}
+	return ins, true
+}
+
+// walk follows every path from the entry point, marking code and sprites.
+func (a *Analysis) walk() {
+	seen := make(map[flowState]bool)
+	work := []flowState{{addr: a.Entry, planes: 1}}
+	for len(work) > 0 && len(seen) < maxAnalysisStates {
+		s := work[len(work)-1]
+		work = work[:len(work)-1]
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		ins, ok := a.decode(s.addr)
+		if !ok {
+			continue
+		}
+		if _, done := a.ins[s.addr]; !done {
+			a.ins[s.addr] = ins
+			for n := 0; n < ins.Length; n++ {
+				a.kinds[int(s.addr-a.Base)+n] = ByteCode
+			}
+		}
+
+		next := s
+		next.addr = s.addr + uint16(ins.Length)
+		switch {
+		case ins.Op&0xF000 == 0xA000 || ins.Length == 4:
+			next.i, next.iKnown = ins.Operands[1].Value, true
+			a.ref(next.i, refData)
+		case ins.Mnemonic == MnemonicDRW && s.iKnown:
+			a.sprite(s.i, ins, s.planes)
+		case ins.Mnemonic == MnemonicPLANE:
+			next.planes = bits.OnesCount16(ins.Operands[0].Value)
+		case ins.Op&0xF0FF == 0xF055, ins.Op&0xF0FF == 0xF065:
+			if s.iKnown {
+				a.data(s.i, int(ArgX(ins.Op))+1)
+			}
+			// Whether I moves depends on the quirks
+			next.iKnown = false
+		case ins.Op&0xF0FF == 0xF033 && s.iKnown:
+			a.data(s.i, 3)
+		case ins.Mnemonic == MnemonicSAVE || ins.Mnemonic == MnemonicLOAD:
+			if s.iKnown {
+				x, y := int(ArgX(ins.Op)), int(ArgY(ins.Op))
+				if x > y {
+					x, y = y, x
+				}
+				a.data(s.i, y-x+1)
+			}
+		case ins.Op&0xF0FF == 0xF01E, ins.Op&0xF0FF == 0xF029, ins.Op&0xF0FF == 0xF030:
+			next.iKnown = false
+		}
+		if !next.iKnown {
+			next.i = 0
+		}
+
+		switch ins.Flow {
+		case FlowNext:
+			work = append(work, next)
+		case FlowJump:
+			a.ref(ins.Target, refJump)
+			next.addr = ins.Target
+			work = append(work, next)
+		case FlowJumpIndirect:
+			a.ref(ins.Target, refJump)
+		case FlowCall:
+			a.ref(ins.Target, refCall)
+			call := next
+			call.addr = ins.Target
+			// The subroutine may change anything
+			next.i, next.iKnown, next.planes = 0, false, 0
+			work = append(work, next, call)
+		case FlowSkip:
+			work = append(work, next)
+			next.addr = a.skip(next.addr)
+			work = append(work, next)
+		}
+	}
+}
+
+// skip returns where a skip over the instruction at addr lands.
+func (a *Analysis) skip(addr uint16) uint16 {
+	if skipped, ok := a.decode(addr); ok {
+		return addr + uint16(skipped.Length)
+	}
+	return addr + 2
+}
+
+// successors returns where execution can go after the instruction at addr,
+// skipping the instruction that follows whatever its length.
+func (a *Analysis) successors(addr uint16, ins Instruction) []uint16 {
+	next := addr + uint16(ins.Length)
+	return ins.SuccessorsWith(addr, Instruction{Length: int(a.skip(next) - next)})
+}
+
+// sprite marks the bytes drawn by a DRW with I at addr.
+func (a *Analysis) sprite(addr uint16, ins Instruction, planes int) {
+	n := int(ins.Operands[2].Value)
+	if n == 0 {
+		// SUPER-CHIP 16x16 sprites
+		n = 32
+	}
+	if planes == 0 {
+		planes = 1
+	}
+	a.ref(addr, refSprite)
+	for k := 0; k < n*planes; k++ {
+		at := addr + uint16(k)
+		if !a.inROM(at, 1) {
+			break
+		}
+		if a.kinds[at-a.Base] != ByteCode {
+			a.kinds[at-a.Base] = ByteSprite
+		}
+	}
+}
+
+// data marks n bytes from addr as data, if nothing else.
+func (a *Analysis) data(addr uint16, n int) {
+	for k := 0; k < n; k++ {
+		at := addr + uint16(k)
+		if !a.inROM(at, 1) {
+			break
+		}
+		if a.kinds[at-a.Base] == ByteUnknown {
+			a.kinds[at-a.Base] = ByteData
+		}
+	}
+}
+
+// lineStart reports whether addr starts a line of the listing, so can be
+// labelled.
+func (a *Analysis) lineStart(addr uint16) bool {
+	if !a.inROM(addr, 1) {
+		return false
+	}
+	_, ok := a.ins[addr]
+	return ok || a.kinds[addr-a.Base] != ByteCode
+}
+
+// name labels the addresses referred to, and those with symbols.
+func (a *Analysis) name() {
+	for addr, kind := range a.refs {
+		if a.lineStart(addr) {
+			a.labels[addr] = fmt.Sprintf("%s_%03X", refPrefixes[kind], addr)
+		}
+	}
+	for _, sym := range a.dis.symbols.All() {
+		if label, _ := a.dis.symbols.Label(sym.Addr); a.lineStart(sym.Addr) {
+			a.labels[sym.Addr] = label
+		}
+	}
+	// Bytes pointed at but never used are data too
+	for addr, kind := range a.refs {
+		if kind == refData {
+			a.data(addr, 1)
+		}
+	}
+}
+
+// Kind returns what the byte at addr was found to be.
+func (a *Analysis) Kind(addr uint16) ByteKind {
+	if !a.inROM(addr, 1) {
+		return ByteUnknown
+	}
+	return a.kinds[addr-a.Base]
+}
+
+// Instruction returns the instruction starting at addr, if code starts there.
+func (a *Analysis) Instruction(addr uint16) (Instruction, bool) {
+	ins, ok := a.ins[addr]
+	return ins, ok
+}
+
+// Label returns the label of addr, if the listing has one.
+func (a *Analysis) Label(addr uint16) (string, bool) {
+	label, ok := a.labels[addr]
+	return label, ok
+}
+
+// labelAddr returns the address labelled label.
+func (a *Analysis) labelAddr(label string) (uint16, bool) {
+	for addr, l := range a.labels {
+		if l == label {
+			return addr, true
+		}
+	}
+	return 0, false
+}
+
+// Labels returns the labelled addresses in order.
+func (a *Analysis) Labels() []Symbol {
+	syms := make([]Symbol, 0, len(a.labels))
+	for addr, label := range a.labels {
+		syms = append(syms, Symbol{Label: label, Addr: addr})
+	}
+	sort.Slice(syms, func(i, j int) bool { return syms[i].Addr < syms[j].Addr })
+	return syms
+}
+
+// count returns how many bytes are of kind k.
+func (a *Analysis) count(k ByteKind) int {
+	n := 0
+	for _, kind := range a.kinds {
+		if kind == k {
+			n++
+		}
+	}
+	return n
+}
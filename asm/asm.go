@@ -0,0 +1,630 @@
+// Package asm assembles CHIP-8 programs written with the mnemonics printed by
+// chip8's Disassembler, such as `LD I, 0x2A0` and `DRW V0, V1, 0x5`.
+//
+// Each line holds any number of `label:` definitions followed by an
+// instruction, a directive or a constant definition, and comments start with
+// `;`. Mnemonics, registers and directives are case-insensitive. Values can be
+// constant expressions of numbers, 'c' characters, labels, constants and `$`,
+// the address of the current line, with the C operators + - * / % & | ^ ~ <<
+// and >>. The directives are:
+//
+//	NAME = expr, NAME equ expr    define a constant
+//	org expr                      assemble what follows at an address
+//	db expr|"string", ...         bytes of data
+//	dw expr, ...                  big endian words of data
+//	include "file"                assemble another file, relative to this one
+//
+// `LD I, LONG value` assembles to the XO-CHIP four byte form, F000 NNNN, and
+// `LD I, value` to ANNN, so its value must fit in 12 bits.
+package asm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Grazfather/chip8"
+)
+
+// LoadAddress is where CHIP-8 programs are loaded, and where assembly starts.
+const LoadAddress = 0x200
+
+// maxErrors is how many errors are reported before giving up.
+const maxErrors = 10
+
+// maxIncludeDepth limits nested includes.
+const maxIncludeDepth = 32
+
+// Pos is a place in a source file. Line and Col count from 1.
+type Pos struct {
+	File string
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+// Error is a problem with the source at Pos.
+type Error struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// ErrorList is the errors found in a program, sorted by position.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	lines := make([]string, len(l))
+	for n, e := range l {
+		lines[n] = e.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Program is an assembled program.
+type Program struct {
+	// ROM is the memory from LoadAddress to the last byte assembled
+	ROM []byte
+	// Symbols are the labels and their addresses
+	Symbols *chip8.Symbols
+	// Sources maps the address of every instruction and data directive to
+	// the line it came from
+	Sources *chip8.SourceMap
+}
+
+// stmtKind is what a statement does.
+type stmtKind int
+
+const (
+	stmtIns stmtKind = iota
+	stmtData
+	stmtOrg
+	stmtConst
+)
+
+// stmt is an instruction or directive.
+type stmt struct {
+	kind stmtKind
+	pos  Pos
+	addr int
+	size int
+	// mnemonic and ops are an instruction, with long set for the four byte LD I
+	mnemonic string
+	ops      []operand
+	long     bool
+	// items are data, with width 1 for db and 2 for dw
+	items []dataItem
+	width int
+	// e is the value of an org or constant
+	e *expr
+}
+
+// dataItem is an expression or a string in db or dw.
+type dataItem struct {
+	e   *expr
+	str string
+	col int
+}
+
+// symbol is a label or constant.
+type symbol struct {
+	pos   Pos
+	label bool
+	// addr is the address of a label
+	addr int
+	// e is the value of a constant, which is evaluated when first needed
+	e         *expr
+	val       int
+	evaluated bool
+	resolving bool
+	// addrOf is the statement after the definition, which gives a label its
+	// address and $ its value in a constant
+	addrOf int
+}
+
+// Assembler assembles source files.
+type Assembler struct {
+	// ReadFile reads source files. It is os.ReadFile if nil.
+	ReadFile func(filename string) ([]byte, error)
+
+	stmts   []*stmt
+	symbols map[string]*symbol
+	labels  []string
+	errs    ErrorList
+	pc      int
+	// includes are the files being read, innermost last
+	includes []string
+}
+
+// Assemble assembles a file.
+func Assemble(filename string) (*Program, error) {
+	return (&Assembler{}).Assemble(filename)
+}
+
+// Assemble assembles a file, and the files it includes.
+func (a *Assembler) Assemble(filename string) (*Program, error) {
+	src, err := a.readFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return a.AssembleSource(filename, src)
+}
+
+func (a *Assembler) readFile(filename string) ([]byte, error) {
+	if a.ReadFile != nil {
+		return a.ReadFile(filename)
+	}
+	return os.ReadFile(filename)
+}
+
+// AssembleSource assembles src, naming it filename in errors and the source
+// map. Includes are relative to filename's directory.
+func (a *Assembler) AssembleSource(filename string, src []byte) (*Program, error) {
+	a.stmts = nil
+	a.symbols = make(map[string]*symbol)
+	a.labels = nil
+	a.errs = nil
+	a.pc = LoadAddress
+	a.includes = nil
+
+	a.parseFile(filename, src)
+	if len(a.errs) < maxErrors {
+		a.layout()
+	}
+	var p *Program
+	if len(a.errs) < maxErrors {
+		p = a.emit()
+	}
+	if len(a.errs) > 0 {
+		sort.SliceStable(a.errs, func(i, j int) bool {
+			pi, pj := a.errs[i].Pos, a.errs[j].Pos
+			if pi.File != pj.File {
+				return pi.File < pj.File
+			}
+			if pi.Line != pj.Line {
+				return pi.Line < pj.Line
+			}
+			return pi.Col < pj.Col
+		})
+		return nil, a.errs
+	}
+	return p, nil
+}
+
+// errorf records an error, and reports whether to carry on.
+func (a *Assembler) errorf(pos Pos, format string, args ...interface{}) bool {
+	a.errs = append(a.errs, &Error{pos, fmt.Sprintf(format, args...)})
+	return len(a.errs) < maxErrors
+}
+
+// lineError records an error from lexing or parsing a line, whose position
+// only has a column.
+func (a *Assembler) lineError(pos Pos, err error) bool {
+	if e, ok := err.(*Error); ok {
+		pos.Col = e.Pos.Col
+		return a.errorf(pos, "%s", e.Msg)
+	}
+	return a.errorf(pos, "%v", err)
+}
+
+// parseFile parses every line of a file into statements, following includes.
+func (a *Assembler) parseFile(filename string, src []byte) {
+	for _, f := range a.includes {
+		if f == filename {
+			a.errorf(Pos{filename, 1, 1}, "%s includes itself", filename)
+			return
+		}
+	}
+	a.includes = append(a.includes, filename)
+	defer func() { a.includes = a.includes[:len(a.includes)-1] }()
+	for n, line := range strings.Split(string(src), "\n") {
+		if !a.parseLine(Pos{filename, n + 1, 1}, line) {
+			return
+		}
+	}
+}
+
+// parseLine parses a line, reporting whether to carry on.
+func (a *Assembler) parseLine(pos Pos, line string) bool {
+	toks, err := lex(line)
+	if err != nil {
+		return a.lineError(pos, err)
+	}
+	at := func(t token) Pos {
+		p := pos
+		p.Col = t.col
+		return p
+	}
+	i := 0
+	// Labels
+	for toks[i].kind == tokIdent && toks[i+1].kind == tokPunct && toks[i+1].text == ":" {
+		if !a.define(at(toks[i]), toks[i].text, &symbol{label: true}) {
+			return false
+		}
+		i += 2
+	}
+	t := toks[i]
+	if t.kind == tokEOL {
+		return true
+	}
+	if t.kind != tokIdent {
+		return a.errorf(at(t), "expected an instruction or directive, got %s", t.describe())
+	}
+	// Constants
+	next := toks[i+1]
+	if (next.kind == tokPunct && next.text == "=") || (next.kind == tokIdent && strings.EqualFold(next.text, "equ")) {
+		e, err := a.parseExpr(toks, i+2)
+		if err != nil {
+			return a.lineError(pos, err)
+		}
+		if !a.define(at(t), t.text, &symbol{e: e}) {
+			return false
+		}
+		a.stmts = append(a.stmts, &stmt{kind: stmtConst, pos: at(t), e: e})
+		return true
+	}
+
+	word := strings.ToUpper(strings.TrimPrefix(t.text, "."))
+	switch word {
+	case "ORG":
+		e, err := a.parseExpr(toks, i+1)
+		if err != nil {
+			return a.lineError(pos, err)
+		}
+		a.stmts = append(a.stmts, &stmt{kind: stmtOrg, pos: at(t), e: e})
+	case "DB", "DW":
+		items, err := parseData(toks, i+1)
+		if err != nil {
+			return a.lineError(pos, err)
+		}
+		width := 1
+		if word == "DW" {
+			width = 2
+		}
+		a.stmts = append(a.stmts, &stmt{kind: stmtData, pos: at(t), items: items, width: width})
+	case "INCLUDE":
+		name := toks[i+1]
+		if name.kind != tokString || toks[i+2].kind != tokEOL {
+			return a.errorf(at(name), "expected a quoted file name")
+		}
+		file := name.text
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(pos.File), file)
+		}
+		if len(a.includes) >= maxIncludeDepth {
+			return a.errorf(at(name), "includes nested too deeply")
+		}
+		src, err := a.readFile(file)
+		if err != nil {
+			return a.errorf(at(name), "%v", err)
+		}
+		a.parseFile(file, src)
+		return len(a.errs) < maxErrors
+	default:
+		ops, err := parseOperands(toks, i+1)
+		if err != nil {
+			return a.lineError(pos, err)
+		}
+		a.stmts = append(a.stmts, &stmt{kind: stmtIns, pos: at(t), mnemonic: word, ops: ops})
+	}
+	return true
+}
+
+// define adds a label or constant to the symbol table.
+func (a *Assembler) define(pos Pos, name string, sym *symbol) bool {
+	if old, ok := a.symbols[name]; ok {
+		return a.errorf(pos, "%s is already defined at %s", name, old.pos)
+	}
+	up := strings.ToUpper(name)
+	if specialRegs[up] || up == "LONG" || (len(up) == 2 && up[0] == 'V' && isHexDigit(up[1])) {
+		return a.errorf(pos, "%s is the name of a register", name)
+	}
+	sym.pos = pos
+	// Labels are at the statement after them, which is placed in layout
+	sym.addrOf = len(a.stmts)
+	a.symbols[name] = sym
+	if sym.label {
+		a.labels = append(a.labels, name)
+	}
+	return true
+}
+
+// parseExpr parses a whole expression from toks[i] to the end of the line.
+func (a *Assembler) parseExpr(toks []token, i int) (*expr, error) {
+	p := &exprParser{toks: toks, i: i}
+	e, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.tok(); t.kind != tokEOL {
+		return nil, &Error{Pos{Col: t.col}, fmt.Sprintf("unexpected %s", t.describe())}
+	}
+	return e, nil
+}
+
+// parseData parses the comma separated items of db or dw.
+func parseData(toks []token, i int) ([]dataItem, error) {
+	var items []dataItem
+	for {
+		t := toks[i]
+		if t.kind == tokString {
+			items = append(items, dataItem{str: t.text, col: t.col})
+			i++
+		} else {
+			p := &exprParser{toks: toks, i: i}
+			e, err := p.parse()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, dataItem{e: e, col: t.col})
+			i = p.i
+		}
+		switch t := toks[i]; {
+		case t.kind == tokEOL:
+			return items, nil
+		case t.kind == tokPunct && t.text == ",":
+			i++
+		default:
+			return nil, &Error{Pos{Col: t.col}, fmt.Sprintf("expected , got %s", t.describe())}
+		}
+	}
+}
+
+// env evaluates expressions for a statement. In layout, labels after the
+// statement aren't known yet.
+type env struct {
+	a    *Assembler
+	addr int
+	// placed is how many statements have addresses, or more than there are
+	// once the labels at the end of the program have them too
+	placed int
+}
+
+// final reports whether every label has its address.
+func (e env) final() bool {
+	return e.placed > len(e.a.stmts)
+}
+
+func (e env) here() int {
+	return e.addr
+}
+
+func (e env) lookup(name string) (int, error) {
+	sym, ok := e.a.symbols[name]
+	if !ok {
+		return 0, fmt.Errorf("undefined: %s", name)
+	}
+	if sym.label {
+		if sym.addrOf >= e.placed {
+			return 0, fmt.Errorf("%s is defined later, so its address isn't known yet", name)
+		}
+		return sym.addr, nil
+	}
+	if sym.evaluated {
+		return sym.val, nil
+	}
+	if sym.resolving {
+		return 0, fmt.Errorf("%s is defined in terms of itself", name)
+	}
+	sym.resolving = true
+	defer func() { sym.resolving = false }()
+	// $ in a constant is where it is defined
+	inner := e
+	inner.addr = e.a.stmtAddr(sym.addrOf)
+	v, err := sym.e.eval(inner)
+	if err != nil {
+		return 0, err
+	}
+	if e.final() {
+		sym.val, sym.evaluated = v, true
+	}
+	return v, nil
+}
+
+// stmtAddr returns the address of the nth statement, or the end of the
+// program.
+func (a *Assembler) stmtAddr(n int) int {
+	if n < len(a.stmts) {
+		return a.stmts[n].addr
+	}
+	return a.pc
+}
+
+// layout gives every statement an address and size, and every label an
+// address.
+func (a *Assembler) layout() {
+	a.pc = LoadAddress
+	labels := make(map[int][]*symbol)
+	for _, name := range a.labels {
+		sym := a.symbols[name]
+		labels[sym.addrOf] = append(labels[sym.addrOf], sym)
+	}
+	for n, s := range a.stmts {
+		if s.kind == stmtOrg {
+			v, err := s.e.eval(env{a, a.pc, n})
+			if err != nil {
+				if !a.errorf(s.pos, "%v", err) {
+					return
+				}
+			} else if v < LoadAddress || v > 0xFFFF {
+				if !a.errorf(s.pos, "org 0x%X is outside 0x%X-0xFFFF", v, LoadAddress) {
+					return
+				}
+			} else {
+				a.pc = v
+			}
+		}
+		s.addr = a.pc
+		for _, sym := range labels[n] {
+			sym.addr = a.pc
+		}
+		switch s.kind {
+		case stmtIns:
+			s.size = 2
+			if s.mnemonic == "LD" && len(s.ops) == 2 && s.ops[0].kind == "I" && s.ops[1].kind == "N" {
+				s.long = s.ops[1].long
+				if s.long {
+					s.size = 4
+				}
+			}
+		case stmtData:
+			for _, item := range s.items {
+				if item.e == nil {
+					s.size += len(item.str) * s.width
+				} else {
+					s.size += s.width
+				}
+			}
+		}
+		a.pc += s.size
+	}
+	for _, sym := range labels[len(a.stmts)] {
+		sym.addr = a.pc
+	}
+}
+
+// emit assembles every statement into a Program.
+func (a *Assembler) emit() *Program {
+	mem := make([]byte, 0x10000)
+	// owner is the statement that wrote each byte, to catch overlaps
+	owner := make([]*stmt, 0x10000)
+	end := LoadAddress
+	p := &Program{Symbols: chip8.NewSymbols(), Sources: chip8.NewSourceMap()}
+	for _, name := range a.labels {
+		p.Symbols.Add(name, uint16(a.symbols[name].addr))
+	}
+	e := env{a: a, placed: len(a.stmts) + 1}
+	for _, s := range a.stmts {
+		e.addr = s.addr
+		var out []byte
+		var ok bool
+		switch s.kind {
+		case stmtIns:
+			out, ok = a.emitIns(s, e)
+		case stmtData:
+			out, ok = a.emitData(s, e)
+		case stmtConst:
+			// Report bad constants even if they aren't used
+			if _, err := s.e.eval(e); err != nil {
+				ok = a.errorf(s.pos, "%v", err)
+			} else {
+				ok = true
+			}
+		default:
+			ok = true
+		}
+		if !ok {
+			if len(a.errs) >= maxErrors {
+				return nil
+			}
+			continue
+		}
+		if len(out) == 0 {
+			continue
+		}
+		if s.addr+len(out) > len(mem) {
+			if !a.errorf(s.pos, "assembles past the end of memory") {
+				return nil
+			}
+			continue
+		}
+		for n := range out {
+			if prev := owner[s.addr+n]; prev != nil {
+				if !a.errorf(s.pos, "overlaps %s at 0x%04X", prev.pos, s.addr+n) {
+					return nil
+				}
+				break
+			}
+			owner[s.addr+n] = s
+		}
+		copy(mem[s.addr:], out)
+		p.Sources.Add(uint16(s.addr), chip8.SourceLocation{File: s.pos.File, Line: s.pos.Line})
+		if s.addr+len(out) > end {
+			end = s.addr + len(out)
+		}
+	}
+	p.ROM = mem[LoadAddress:end]
+	return p
+}
+
+// emitIns assembles an instruction, reporting whether to carry on if it
+// can't.
+func (a *Assembler) emitIns(s *stmt, e env) ([]byte, bool) {
+	at := func(col int) Pos {
+		p := s.pos
+		p.Col = col
+		return p
+	}
+	vals := make([]int, len(s.ops))
+	for n, op := range s.ops {
+		if op.kind != "N" {
+			continue
+		}
+		v, err := op.e.eval(e)
+		if err != nil {
+			return nil, a.errorf(at(op.col), "%v", err)
+		}
+		vals[n] = v
+	}
+	if s.long {
+		v := vals[1]
+		if v > 0xFFFF || v < 0 {
+			return nil, a.errorf(at(s.ops[1].col), "address 0x%X doesn't fit in 16 bits", v)
+		}
+		return []byte{0xF0, 0x00, byte(v >> 8), byte(v)}, true
+	}
+	if s.mnemonic == "LD" && len(s.ops) == 2 && s.ops[0].kind == "I" && s.ops[1].kind == "N" && vals[1] > 0xFFF {
+		return nil, a.errorf(at(s.ops[1].col), "address 0x%X doesn't fit in 12 bits, use LONG for the XO-CHIP form", vals[1])
+	}
+	sig := signature(s.mnemonic, s.ops)
+	enc, ok := encodings[sig]
+	if !ok {
+		return nil, a.errorf(s.pos, "no instruction %s", sig)
+	}
+	op, err := encode(enc, s.ops, vals)
+	if err != nil {
+		return nil, a.lineError(s.pos, err)
+	}
+	return []byte{byte(op >> 8), byte(op)}, true
+}
+
+// emitData assembles db or dw.
+func (a *Assembler) emitData(s *stmt, e env) ([]byte, bool) {
+	var out []byte
+	limit := 1<<(8*uint(s.width)) - 1
+	for _, item := range s.items {
+		if item.e == nil {
+			for _, c := range []byte(item.str) {
+				if s.width == 2 {
+					out = append(out, 0)
+				}
+				out = append(out, c)
+			}
+			continue
+		}
+		v, err := item.e.eval(e)
+		if err != nil {
+			p := s.pos
+			p.Col = item.col
+			return nil, a.errorf(p, "%v", err)
+		}
+		if v > limit || v < -(limit+1)/2 {
+			p := s.pos
+			p.Col = item.col
+			return nil, a.errorf(p, "value %d (0x%X) doesn't fit in 0x%X", v, v, limit)
+		}
+		if s.width == 2 {
+			out = append(out, byte(v>>8))
+		}
+		out = append(out, byte(v))
+	}
+	return out, true
+}
@@ -0,0 +1,103 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func assemble(src string) ([]byte, error) {
+	p, err := (&Assembler{}).AssembleSource("test.s", []byte(src))
+	if err != nil {
+		return nil, err
+	}
+	return p.ROM, nil
+}
+
+func TestEncodings(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"CLS", "00e0"},
+		{"RET", "00ee"},
+		{"SCD 3", "00c3"},
+		{"SYS 0x123", "0123"},
+		{"JP 0x2A4", "12a4"},
+		{"CALL 0x300", "2300"},
+		{"SE V1, 0x12", "3112"},
+		{"SNE VA, 255", "4aff"},
+		{"SE V1, V2", "5120"},
+		{"SAVE V1, V4", "5142"},
+		{"LOAD V1, V4", "5143"},
+		{"LD V3, -1", "63ff"},
+		{"ADD V3, 2", "7302"},
+		{"LD V1, V2", "8120"},
+		{"SUBN V1, V2", "8127"},
+		{"SHL V1, V2", "812e"},
+		{"SNE V1, V2", "9120"},
+		{"LD I, 0x300", "a300"},
+		{"LD I, 0x0300", "a300"},
+		{"JP V0, 0x300", "b300"},
+		{"RND V0, 0x0F", "c00f"},
+		{"DRW V0, V1, 5", "d015"},
+		{"SKP V2", "e29e"},
+		{"SKNP V2", "e2a1"},
+		{"PLANE 3", "f301"},
+		{"AUDIO", "f002"},
+		{"LD V1, DT", "f107"},
+		{"LD V1, K", "f10a"},
+		{"LD ST, V1", "f118"},
+		{"ADD I, V1", "f11e"},
+		{"LD HF, V1", "f130"},
+		{"LD [I], V5", "f555"},
+		{"LD V5, [I]", "f565"},
+		{"LD R, V5", "f575"},
+		{"LD I, LONG 0x1234", "f0001234"},
+		{"LD I, LONG 0x300", "f0000300"},
+		{"LD I, LONG end\nend:", "f0000204"},
+		{"ld v0, 1 + 2 * 3", "6007"},
+		{"LD V0, (1 + 2) * 3", "6009"},
+		{"LD V0, 1 << 2 | 1", "6005"},
+		{"X = 4\nLD V0, X * 2", "6008"},
+		{"start: JP start", "1200"},
+		{"db 1, 2, \"ab\"\ndw 0x1234", "0102616212 34"},
+	}
+	for _, tt := range tests {
+		rom, err := assemble(tt.src)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		if got, want := fmt.Sprintf("%x", rom), strings.Replace(tt.want, " ", "", -1); got != want {
+			t.Errorf("%q assembled to %s, want %s", tt.src, got, want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"LD I, 0x1000", "test.s:1:7: address 0x1000 doesn't fit in 12 bits"},
+		{"LD I, 0xFFFF", "doesn't fit in 12 bits"},
+		{"LD I, LONG 0x10000", "doesn't fit in 16 bits"},
+		{"LD V0, 0x100", "doesn't fit in 0xFF"},
+		{"JP V1, 0x300", "only V0"},
+		{"FOO V0", "test.s:1:1"},
+		{"JP nowhere", "nowhere"},
+		{"a:\na:", "a"},
+		{"LD V0, 1 +", "test.s:1"},
+	}
+	for _, tt := range tests {
+		_, err := assemble(tt.src)
+		if err == nil {
+			t.Errorf("%q assembled", tt.src)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: got error %q, want it to contain %q", tt.src, err, tt.want)
+		}
+	}
+}
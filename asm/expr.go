@@ -0,0 +1,173 @@
+package asm
+
+import (
+	"fmt"
+)
+
+// expr is a constant expression. Leaves are numbers, names and $, the address
+// of the statement.
+type expr struct {
+	op   string
+	val  int
+	name string
+	col  int
+	l, r *expr
+}
+
+// evalEnv looks up names and $ for eval.
+type evalEnv interface {
+	lookup(name string) (int, error)
+	here() int
+}
+
+// Binary operators and their precedence, higher binds tighter.
+var precedence = map[string]int{
+	"|":  1,
+	"^":  2,
+	"&":  3,
+	"<<": 4, ">>": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+func (e *expr) eval(env evalEnv) (int, error) {
+	switch e.op {
+	case "num":
+		return e.val, nil
+	case "name":
+		return env.lookup(e.name)
+	case "$":
+		return env.here(), nil
+	}
+	l, err := e.l.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	if e.r == nil {
+		switch e.op {
+		case "-":
+			return -l, nil
+		case "~":
+			return ^l, nil
+		}
+		return l, nil
+	}
+	r, err := e.r.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	switch e.op {
+	case "|":
+		return l | r, nil
+	case "^":
+		return l ^ r, nil
+	case "&":
+		return l & r, nil
+	case "<<":
+		return l << uint(r&63), nil
+	case ">>":
+		return l >> uint(r&63), nil
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/", "%":
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if e.op == "/" {
+			return l / r, nil
+		}
+		return l % r, nil
+	}
+	return 0, fmt.Errorf("unknown operator %s", e.op)
+}
+
+// exprParser parses an expression from the tokens of a line.
+type exprParser struct {
+	toks []token
+	i    int
+}
+
+func (p *exprParser) tok() token {
+	return p.toks[p.i]
+}
+
+func (p *exprParser) parse() (*expr, error) {
+	return p.parseBinary(0)
+}
+
+func (p *exprParser) parseBinary(minPrec int) (*expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.tok()
+		prec, ok := precedence[t.text]
+		if t.kind != tokPunct || !ok || prec <= minPrec {
+			return lhs, nil
+		}
+		p.i++
+		rhs, err := p.parseBinary(prec)
+		if err != nil {
+			return nil, err
+		}
+		lhs = &expr{op: t.text, col: lhs.col, l: lhs, r: rhs}
+	}
+}
+
+func (p *exprParser) parseUnary() (*expr, error) {
+	t := p.tok()
+	if t.kind == tokPunct && (t.text == "-" || t.text == "~" || t.text == "+") {
+		p.i++
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &expr{op: t.text, col: t.col, l: e}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (*expr, error) {
+	t := p.tok()
+	switch {
+	case t.kind == tokNumber:
+		p.i++
+		return &expr{op: "num", val: t.val, col: t.col}, nil
+	case t.kind == tokIdent:
+		p.i++
+		return &expr{op: "name", name: t.text, col: t.col}, nil
+	case t.kind == tokPunct && t.text == "$":
+		p.i++
+		return &expr{op: "$", col: t.col}, nil
+	case t.kind == tokPunct && t.text == "(":
+		p.i++
+		e, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if c := p.tok(); c.kind != tokPunct || c.text != ")" {
+			return nil, &Error{Pos{Col: c.col}, "expected )"}
+		}
+		p.i++
+		return e, nil
+	case t.kind == tokEOL:
+		return nil, &Error{Pos{Col: t.col}, "expected a value"}
+	}
+	return nil, &Error{Pos{Col: t.col}, fmt.Sprintf("unexpected %s", t.describe())}
+}
+
+// describe names a token for error messages.
+func (t token) describe() string {
+	switch t.kind {
+	case tokEOL:
+		return "end of line"
+	case tokString:
+		return "string"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
@@ -0,0 +1,209 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// operand is an argument to an instruction. kind is V for a register, N for
+// a value, or the name of a special register such as I, DT or [I].
+type operand struct {
+	kind string
+	reg  int
+	e    *expr
+	col  int
+	// long is set for values written as LONG expr, which makes LD I take the
+	// XO-CHIP four byte form
+	long bool
+}
+
+// specialRegs are operands that name something other than a V register.
+var specialRegs = map[string]bool{
+	"I": true, "DT": true, "ST": true, "K": true, "F": true, "HF": true, "B": true, "R": true,
+}
+
+// parseOperands parses the comma separated operands starting at toks[i].
+// Commas are optional, as older listings leave some out.
+func parseOperands(toks []token, i int) ([]operand, error) {
+	var ops []operand
+	// Skip a comma straight after the mnemonic, as in "RND, V0, 0x10"
+	if toks[i].kind == tokPunct && toks[i].text == "," {
+		i++
+	}
+	for toks[i].kind != tokEOL {
+		t := toks[i]
+		up := strings.ToUpper(t.text)
+		switch {
+		case t.kind == tokIdent && len(up) == 2 && up[0] == 'V' && isHexDigit(up[1]):
+			r, _ := strconv.ParseUint(up[1:], 16, 8)
+			ops = append(ops, operand{kind: "V", reg: int(r), col: t.col})
+			i++
+		case t.kind == tokIdent && specialRegs[up]:
+			ops = append(ops, operand{kind: up, col: t.col})
+			i++
+		case t.kind == tokPunct && t.text == "[" && toks[i+1].kind == tokIdent &&
+			strings.ToUpper(toks[i+1].text) == "I" && toks[i+2].text == "]":
+			ops = append(ops, operand{kind: "[I]", col: t.col})
+			i += 3
+		default:
+			long := false
+			if t.kind == tokIdent && up == "LONG" {
+				long = true
+				i++
+			}
+			p := &exprParser{toks: toks, i: i}
+			e, err := p.parse()
+			if err != nil {
+				return nil, err
+			}
+			ops = append(ops, operand{kind: "N", e: e, col: t.col, long: long})
+			i = p.i
+		}
+		switch t := toks[i]; {
+		case t.kind == tokPunct && t.text == ",":
+			i++
+			if toks[i].kind == tokEOL {
+				return nil, &Error{Pos{Col: toks[i].col}, "expected an operand after ,"}
+			}
+		case t.kind == tokEOL:
+		case t.kind == tokPunct:
+			return nil, &Error{Pos{Col: t.col}, fmt.Sprintf("unexpected %s", t.describe())}
+		}
+	}
+	return ops, nil
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'A' && c <= 'F')
+}
+
+// Fields an operand can be encoded in.
+const (
+	fieldNone = iota
+	// fieldX and fieldY are registers in bits 8-11 and 4-7
+	fieldX
+	fieldY
+	// fieldV0 is a register that must be V0
+	fieldV0
+	// fieldN, fieldNN and fieldNNN are values in the low 4, 8 and 12 bits
+	fieldN
+	fieldNN
+	fieldNNN
+	// fieldXN is a value in bits 8-11
+	fieldXN
+)
+
+// encoding is how to assemble an instruction with a particular mnemonic and
+// kinds of operands.
+type encoding struct {
+	op     uint16
+	fields []int
+}
+
+// encodings are keyed by mnemonic and operand kinds, like "LD V,N". They are
+// the instructions printed by chip8's Disassembler. LD I,N also has a long
+// form, which is assembled separately.
+var encodings = map[string]encoding{
+	"CLS":       {0x00E0, nil},
+	"RET":       {0x00EE, nil},
+	"SCD N":     {0x00C0, []int{fieldN}},
+	"SCU N":     {0x00D0, []int{fieldN}},
+	"SCR":       {0x00FB, nil},
+	"SCL":       {0x00FC, nil},
+	"EXIT":      {0x00FD, nil},
+	"LOW":       {0x00FE, nil},
+	"HIGH":      {0x00FF, nil},
+	"SYS N":     {0x0000, []int{fieldNNN}},
+	"JP N":      {0x1000, []int{fieldNNN}},
+	"CALL N":    {0x2000, []int{fieldNNN}},
+	"SE V,N":    {0x3000, []int{fieldX, fieldNN}},
+	"SNE V,N":   {0x4000, []int{fieldX, fieldNN}},
+	"SE V,V":    {0x5000, []int{fieldX, fieldY}},
+	"SAVE V,V":  {0x5002, []int{fieldX, fieldY}},
+	"LOAD V,V":  {0x5003, []int{fieldX, fieldY}},
+	"LD V,N":    {0x6000, []int{fieldX, fieldNN}},
+	"ADD V,N":   {0x7000, []int{fieldX, fieldNN}},
+	"LD V,V":    {0x8000, []int{fieldX, fieldY}},
+	"OR V,V":    {0x8001, []int{fieldX, fieldY}},
+	"AND V,V":   {0x8002, []int{fieldX, fieldY}},
+	"XOR V,V":   {0x8003, []int{fieldX, fieldY}},
+	"ADD V,V":   {0x8004, []int{fieldX, fieldY}},
+	"SUB V,V":   {0x8005, []int{fieldX, fieldY}},
+	"SHR V,V":   {0x8006, []int{fieldX, fieldY}},
+	"SUBN V,V":  {0x8007, []int{fieldX, fieldY}},
+	"SHL V,V":   {0x800E, []int{fieldX, fieldY}},
+	"SNE V,V":   {0x9000, []int{fieldX, fieldY}},
+	"JP V,N":    {0xB000, []int{fieldV0, fieldNNN}},
+	"RND V,N":   {0xC000, []int{fieldX, fieldNN}},
+	"DRW V,V,N": {0xD000, []int{fieldX, fieldY, fieldN}},
+	"SKP V":     {0xE09E, []int{fieldX}},
+	"SKNP V":    {0xE0A1, []int{fieldX}},
+	"PLANE N":   {0xF001, []int{fieldXN}},
+	"AUDIO":     {0xF002, nil},
+	"LD V,DT":   {0xF007, []int{fieldX, fieldNone}},
+	"LD V,K":    {0xF00A, []int{fieldX, fieldNone}},
+	"LD DT,V":   {0xF015, []int{fieldNone, fieldX}},
+	"LD ST,V":   {0xF018, []int{fieldNone, fieldX}},
+	"ADD I,V":   {0xF01E, []int{fieldNone, fieldX}},
+	"LD F,V":    {0xF029, []int{fieldNone, fieldX}},
+	"LD HF,V":   {0xF030, []int{fieldNone, fieldX}},
+	"PITCH V":   {0xF03A, []int{fieldX}},
+	"LD B,V":    {0xF033, []int{fieldNone, fieldX}},
+	"LD [I],V":  {0xF055, []int{fieldNone, fieldX}},
+	"LD V,[I]":  {0xF065, []int{fieldX, fieldNone}},
+	"LD R,V":    {0xF075, []int{fieldNone, fieldX}},
+	"LD V,R":    {0xF085, []int{fieldX, fieldNone}},
+	"LD I,N":    {0xA000, []int{fieldNone, fieldNNN}},
+}
+
+// signature returns the key of ins in encodings.
+func signature(mnemonic string, ops []operand) string {
+	kinds := make([]string, len(ops))
+	for n, op := range ops {
+		kinds[n] = op.kind
+	}
+	if len(kinds) == 0 {
+		return mnemonic
+	}
+	return mnemonic + " " + strings.Join(kinds, ",")
+}
+
+// fieldLimits are the ranges of the value fields. Negative values are allowed
+// down to -limit/2 and wrap.
+var fieldLimits = map[int]int{
+	fieldN:   0xF,
+	fieldNN:  0xFF,
+	fieldNNN: 0xFFF,
+	fieldXN:  0xF,
+}
+
+// encode assembles an instruction whose operand values have been evaluated.
+func encode(enc encoding, ops []operand, vals []int) (uint16, error) {
+	op := enc.op
+	for n, field := range enc.fields {
+		o := ops[n]
+		switch field {
+		case fieldX:
+			op |= uint16(o.reg) << 8
+		case fieldY:
+			op |= uint16(o.reg) << 4
+		case fieldV0:
+			if o.reg != 0 {
+				return 0, &Error{Pos{Col: o.col}, "only V0 can be used here"}
+			}
+		case fieldN, fieldNN, fieldNNN, fieldXN:
+			limit := fieldLimits[field]
+			v := vals[n]
+			if v > limit || v < -(limit+1)/2 {
+				return 0, &Error{Pos{Col: o.col}, fmt.Sprintf("value %d (0x%X) doesn't fit in 0x%X", v, v, limit)}
+			}
+			v &= limit
+			if field == fieldXN {
+				v <<= 8
+			}
+			op |= uint16(v)
+		}
+	}
+	return op, nil
+}
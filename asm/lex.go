@@ -0,0 +1,113 @@
+package asm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOL tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokPunct
+)
+
+// token is a word of a line of source. Numbers and characters have their
+// value in val, and strings their unquoted text in text.
+type token struct {
+	kind tokenKind
+	text string
+	val  int
+	// col is where the token starts, from 1
+	col int
+}
+
+// Punctuation longest first so the tokenizer can match greedily.
+var puncts = []string{
+	"<<", ">>",
+	",", ":", "=", "[", "]", "(", ")", "+", "-", "*", "/", "%", "&", "|", "^", "~", "$",
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '.' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdent(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+// lex splits a line into tokens, ending with a tokEOL. Comments start with ;.
+func lex(line string) ([]token, error) {
+	var toks []token
+	i := 0
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t' || line[i] == '\r') {
+			i++
+		}
+		if i >= len(line) || line[i] == ';' {
+			return append(toks, token{kind: tokEOL, col: i + 1}), nil
+		}
+		start := i
+		c := line[i]
+		switch {
+		case isIdentStart(c):
+			for i < len(line) && isIdent(line[i]) {
+				i++
+			}
+			toks = append(toks, token{kind: tokIdent, text: line[start:i], col: start + 1})
+		case c >= '0' && c <= '9':
+			for i < len(line) && isIdent(line[i]) {
+				i++
+			}
+			text := line[start:i]
+			n, err := strconv.ParseInt(strings.Replace(text, "_", "", -1), 0, 64)
+			if err != nil {
+				return nil, &Error{Pos{Col: start + 1}, fmt.Sprintf("bad number %q", text)}
+			}
+			toks = append(toks, token{kind: tokNumber, text: text, val: int(n), col: start + 1})
+		case c == '"' || c == '\'':
+			i++
+			for i < len(line) && line[i] != c {
+				if line[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(line) {
+				return nil, &Error{Pos{Col: start + 1}, "unterminated quote"}
+			}
+			i++
+			text := line[start:i]
+			if c == '\'' {
+				r, _, tail, err := strconv.UnquoteChar(text[1:len(text)-1], '\'')
+				if err != nil || tail != "" || r > 0xFF {
+					return nil, &Error{Pos{Col: start + 1}, fmt.Sprintf("bad character %s", text)}
+				}
+				toks = append(toks, token{kind: tokNumber, text: text, val: int(r), col: start + 1})
+				break
+			}
+			s, err := strconv.Unquote(text)
+			if err != nil {
+				return nil, &Error{Pos{Col: start + 1}, fmt.Sprintf("bad string %s", text)}
+			}
+			toks = append(toks, token{kind: tokString, text: s, col: start + 1})
+		default:
+			matched := false
+			for _, p := range puncts {
+				if strings.HasPrefix(line[i:], p) {
+					toks = append(toks, token{kind: tokPunct, text: p, col: start + 1})
+					i += len(p)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, &Error{Pos{Col: start + 1}, fmt.Sprintf("unexpected %q", c)}
+			}
+		}
+	}
+}
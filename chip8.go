@@ -1,7 +1,7 @@
 package chip8
 
 import (
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
@@ -36,10 +36,23 @@ func IllegalInstruction(opcode uint16) error {
 	return fmt.Errorf(ErrIllegal, opcode)
 }
 
-const MAX_MEM_ADDRESS = 0x1000
+// ErrExited is returned by RunOne once the program has executed 00FD.
+var ErrExited = errors.New("program exited")
+
+// Stack errors are returned by RunOne instead of running a call or return
+// that would leave the stack unbalanced.
+var (
+	ErrStackOverflow  = errors.New("stack overflow: call with all 24 stack entries in use")
+	ErrStackUnderflow = errors.New("stack underflow: return without a call")
+)
+
+const (
+	MAX_MEM_ADDRESS    = 0x1000
+	XO_MAX_MEM_ADDRESS = 0x10000
+)
 
 type Chip8 struct {
-	mem    [0x1000]byte
+	mem    [XO_MAX_MEM_ADDRESS]byte
 	v      [16]byte
 	stack  [24]uint16
 	sp     int
@@ -47,25 +60,98 @@ type Chip8 struct {
 	pc     uint16
 	delay  uint8
 	sound  uint8
-	screen IterableImage
+	screen *myScreen
+	rpl    [16]byte
+	exited bool
+	// XO-CHIP state
+	planes  byte
+	pattern [16]byte
+	pitch   byte
 	Renderer
 	keypad     Keypad
 	RenderFlag bool
-	timer      *time.Ticker
-	r          *rand.Rand
+	// Silent suppresses the terminal bell when the sound timer expires
+	Silent bool
+	timer  *time.Ticker
+	r      *rand.Rand
+	// src is the state behind r, unless a custom source was given
+	src      *splitMix
+	quirks   Quirks
+	memHook  MemHook
+	tracer   *Tracer
+	profiler *Profiler
+	coverage *Coverage
+	// romSize is how many bytes were loaded by LoadBinary or LoadROM
+	romSize int
 }
 
 // TODO Implement incrementing I, PC behaviour (halt on overflow, or wrap
 // around?)
 
-func NewChip8(r Renderer, k Keypad) *Chip8 {
-	return &Chip8{
+// Option configures optional parts of a Chip8 in NewChip8.
+type Option func(*Chip8)
+
+// WithSeed seeds the random number generator used by CXNN so runs are
+// reproducible.
+func WithSeed(seed int64) Option {
+	return func(c *Chip8) {
+		c.src = newSplitMix(seed)
+		c.r = rand.New(c.src)
+	}
+}
+
+// WithRandSource makes CXNN draw from src. The state of a custom source is not
+// included in save states.
+func WithRandSource(src rand.Source) Option {
+	return func(c *Chip8) {
+		c.src = nil
+		c.r = rand.New(src)
+	}
+}
+
+func NewChip8(r Renderer, k Keypad, q Quirks, opts ...Option) *Chip8 {
+	src := newSplitMix(time.Now().UnixNano())
+	c := &Chip8{
 		screen:   &myScreen{},
 		Renderer: r,
 		keypad:   k,
-		timer:    time.NewTicker(17 * time.Millisecond),
-		r:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		r:        rand.New(src),
+		src:      src,
+		quirks:   q,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Quirks returns the quirks the interpreter is running with.
+func (c *Chip8) Quirks() Quirks {
+	return c.quirks
+}
+
+// Screen returns the image the interpreter draws to.
+func (c *Chip8) Screen() IterableImage {
+	return c.screen
+}
+
+// MemSize returns the amount of addressable memory, which is larger in
+// XO-CHIP mode.
+func (c *Chip8) MemSize() int {
+	if c.quirks.XOChip {
+		return XO_MAX_MEM_ADDRESS
+	}
+	return MAX_MEM_ADDRESS
+}
+
+func (c *Chip8) addrMask() uint16 {
+	return uint16(c.MemSize() - 1)
+}
+
+// AudioPattern returns the XO-CHIP audio pattern buffer and the pitch it is
+// played back at.
+func (c *Chip8) AudioPattern() ([16]byte, byte) {
+	return c.pattern, c.pitch
 }
 
 func (c *Chip8) Render() {
@@ -83,14 +169,23 @@ func (c *Chip8) LoadBinary(filename string) (err error) {
 	}
 	defer f.Close()
 
-	n, err := f.Read(c.mem[0x200:])
-	_ = n
+	n, err := f.Read(c.mem[0x200:c.MemSize()])
+	c.romSize = n
 	if err != nil {
 		return
 	}
 	return
 }
 
+// LoadROM loads a program from memory, such as one just compiled.
+func (c *Chip8) LoadROM(data []byte) error {
+	if len(data) > c.MemSize()-0x200 {
+		return fmt.Errorf("ROM is %d bytes, which doesn't fit in memory", len(data))
+	}
+	c.romSize = copy(c.mem[0x200:c.MemSize()], data)
+	return nil
+}
+
 func (c *Chip8) Reset() {
 	for i := 0; i < len(c.v); i++ {
 		c.v[i] = 0
@@ -102,36 +197,99 @@ func (c *Chip8) Reset() {
 	c.pc = 0x200
 	c.i = 0
 	c.sp = 48
+	c.exited = false
+	c.planes = 1
+	c.pattern = [16]byte{}
+	c.pitch = 64
+	// The RPL flags are deliberately kept across resets
 
 	c.screen = &myScreen{}
 	c.screen.OnEachPixel(ClearPixel)
-	copy(c.mem[:], font)
+	copy(c.mem[FONT_OFFSET:], font)
+	copy(c.mem[BIG_FONT_OFFSET:], bigFont)
 }
 
 func (c *Chip8) RunOne() error {
+	if c.tracer == nil && c.profiler == nil && c.coverage == nil {
+		return c.runOne()
+	}
+	// Keep the instruction in case it overwrites itself
+	pc, sp := c.pc, c.sp
+	code := c.code(pc)
+	exited := c.exited
+	err := c.runOne()
+	if err != nil && (err != ErrExited || exited) {
+		return err
+	}
+	if c.tracer != nil {
+		c.tracer.trace(c, pc, code)
+	}
+	if c.profiler != nil {
+		c.profiler.count(c, pc, sp)
+	}
+	if c.coverage != nil {
+		c.coverage.record(pc, uint16(code[0])<<8|uint16(code[1]), c.pc)
+	}
+	return err
+}
+
+func (c *Chip8) runOne() error {
 
 	c.RenderFlag = false
-	ins := binary.BigEndian.Uint16(c.mem[c.pc:])
+	if c.exited {
+		return ErrExited
+	}
+	ins := c.fetch(c.pc)
 	switch (ins & 0xF000) >> 12 {
 	case 0x0:
-		switch ins {
-		case 0x00E0:
+		switch {
+		case ins == 0x00E0:
 			c.Opcode00E0(ins)
-		case 0x00EE:
+		case ins == 0x00EE:
+			if c.sp >= len(c.stack)*2 {
+				return ErrStackUnderflow
+			}
 			c.Opcode00EE(ins)
+		case ins&0xFFF0 == 0x00C0:
+			c.Opcode00CN(ins)
+		case ins&0xFFF0 == 0x00D0 && c.quirks.XOChip:
+			c.Opcode00DN(ins)
+		case ins == 0x00FB:
+			c.Opcode00FB(ins)
+		case ins == 0x00FC:
+			c.Opcode00FC(ins)
+		case ins == 0x00FD:
+			c.Opcode00FD(ins)
+			return ErrExited
+		case ins == 0x00FE:
+			c.Opcode00FE(ins)
+		case ins == 0x00FF:
+			c.Opcode00FF(ins)
 		default:
 			c.Opcode0NNN(ins)
 		}
 	case 0x1:
 		c.Opcode1NNN(ins)
 	case 0x2:
+		if c.sp <= 0 {
+			return ErrStackOverflow
+		}
 		c.Opcode2NNN(ins)
 	case 0x3:
 		c.Opcode3XNN(ins)
 	case 0x4:
 		c.Opcode4XNN(ins)
 	case 0x5:
-		c.Opcode5XY0(ins)
+		switch {
+		case ins&0xF == 0x0:
+			c.Opcode5XY0(ins)
+		case ins&0xF == 0x2 && c.quirks.XOChip:
+			c.Opcode5XY2(ins)
+		case ins&0xF == 0x3 && c.quirks.XOChip:
+			c.Opcode5XY3(ins)
+		default:
+			return IllegalInstruction(ins)
+		}
 	case 0x6:
 		c.Opcode6XNN(ins)
 	case 0x7:
@@ -171,7 +329,11 @@ func (c *Chip8) RunOne() error {
 	case 0xC:
 		c.OpcodeCXNN(ins)
 	case 0xD:
-		c.OpcodeDXYN(ins)
+		if ArgN(ins) == 0 {
+			c.OpcodeDXY0(ins)
+		} else {
+			c.OpcodeDXYN(ins)
+		}
 	case 0xE:
 		switch ins & 0xFF {
 		case 0x9E:
@@ -183,6 +345,21 @@ func (c *Chip8) RunOne() error {
 		}
 	case 0xF:
 		switch ins & 0xFF {
+		case 0x00:
+			if ins != 0xF000 || !c.quirks.XOChip {
+				return IllegalInstruction(ins)
+			}
+			c.OpcodeF000(ins)
+		case 0x01:
+			if !c.quirks.XOChip {
+				return IllegalInstruction(ins)
+			}
+			c.OpcodeFN01(ins)
+		case 0x02:
+			if ins != 0xF002 || !c.quirks.XOChip {
+				return IllegalInstruction(ins)
+			}
+			c.OpcodeF002(ins)
 		case 0x07:
 			c.OpcodeFX07(ins)
 		case 0x0A:
@@ -195,12 +372,23 @@ func (c *Chip8) RunOne() error {
 			c.OpcodeFX1E(ins)
 		case 0x29:
 			c.OpcodeFX29(ins)
+		case 0x30:
+			c.OpcodeFX30(ins)
+		case 0x3A:
+			if !c.quirks.XOChip {
+				return IllegalInstruction(ins)
+			}
+			c.OpcodeFX3A(ins)
 		case 0x33:
 			c.OpcodeFX33(ins)
 		case 0x55:
 			c.OpcodeFX55(ins)
 		case 0x65:
 			c.OpcodeFX65(ins)
+		case 0x75:
+			c.OpcodeFX75(ins)
+		case 0x85:
+			c.OpcodeFX85(ins)
 		default:
 			return IllegalInstruction(ins)
 		}
@@ -212,19 +400,46 @@ func (c *Chip8) RunOne() error {
 	return nil
 }
 
+// KeepTime ticks the timers at roughly 60Hz from the wall clock. It never
+// returns, so run it in its own goroutine.
 func (c *Chip8) KeepTime() {
+	c.timer = time.NewTicker(17 * time.Millisecond)
 	for {
 		select {
 		case <-c.timer.C:
-			if c.delay != 0 {
-				c.delay--
-			}
-			if c.sound != 0 {
-				c.sound--
-				if c.sound == 0 {
-					fmt.Printf("\a")
-				}
-			}
+			c.Tick()
+		}
+	}
+}
+
+// Tick decrements the delay and sound timers once, as happens every 60th of a
+// second.
+func (c *Chip8) Tick() {
+	if c.delay != 0 {
+		c.delay--
+	}
+	if c.sound != 0 {
+		c.sound--
+		if c.sound == 0 && !c.Silent {
+			fmt.Printf("\a")
 		}
 	}
 }
+
+// RunFrame runs cyclesPerFrame instructions and then ticks the timers once,
+// making execution independent of the wall clock. RenderFlag is left set if
+// any of the instructions drew to the screen.
+func (c *Chip8) RunFrame(cyclesPerFrame int) error {
+	render := false
+	for n := 0; n < cyclesPerFrame; n++ {
+		err := c.RunOne()
+		render = render || c.RenderFlag
+		if err != nil {
+			c.RenderFlag = render
+			return err
+		}
+	}
+	c.Tick()
+	c.RenderFlag = render
+	return nil
+}
@@ -0,0 +1,76 @@
+package chip8
+
+import "testing"
+
+func TestRunFrame(t *testing.T) {
+	rom := []byte{
+		0x60, 0x0A, // LD V0, 10
+		0xF0, 0x15, // LD DT, V0
+		0xF2, 0x0A, // LD V2, K
+		0x71, 0x01, // ADD V1, 1
+		0x12, 0x06, // JP 0x206
+	}
+	c := NewChip8(&NullDisplay{}, &FixedKeypad{Key: 7}, Quirks{})
+	c.Reset()
+	if err := c.LoadROM(rom); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		cycles int
+		pc     uint16
+		v1, dt byte
+	}{
+		// The timer is set and then ticks once at the end of the frame
+		{3, 0x206, 0, 9},
+		// Half the instructions are the jump back
+		{10, 0x206, 5, 8},
+		{1, 0x208, 6, 7},
+	}
+	for n, tt := range tests {
+		if err := c.RunFrame(tt.cycles); err != nil {
+			t.Fatal(err)
+		}
+		if c.pc != tt.pc || c.v[1] != tt.v1 || c.delay != tt.dt {
+			t.Errorf("frame %d: PC 0x%X V1 %d DT %d, want PC 0x%X V1 %d DT %d",
+				n, c.pc, c.v[1], c.delay, tt.pc, tt.v1, tt.dt)
+		}
+	}
+	if c.v[2] != 7 {
+		t.Errorf("LD V2, K got key %d, want 7", c.v[2])
+	}
+	for n := 0; n < 10; n++ {
+		c.RunFrame(1)
+	}
+	if c.delay != 0 {
+		t.Errorf("DT is %d after running out, want 0", c.delay)
+	}
+}
+
+// randoms returns the first n values CXNN gives with seed.
+func randoms(t *testing.T, seed int64, n int) []byte {
+	c := NewChip8(&NullDisplay{}, &NoKeypad{}, Quirks{}, WithSeed(seed))
+	c.Reset()
+	if err := c.LoadROM([]byte{
+		0xC0, 0xFF, // RND V0, 0xFF
+		0x12, 0x00, // JP 0x200
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var vals []byte
+	for len(vals) < n {
+		c.RunOne()
+		vals = append(vals, c.v[0])
+		c.RunOne()
+	}
+	return vals
+}
+
+func TestWithSeed(t *testing.T) {
+	a, b := randoms(t, 0, 16), randoms(t, 0, 16)
+	if string(a) != string(b) {
+		t.Errorf("seed 0 gave % X and then % X", a, b)
+	}
+	if c := randoms(t, 1, 16); string(a) == string(c) {
+		t.Errorf("seeds 0 and 1 both gave % X", a)
+	}
+}
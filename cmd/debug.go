@@ -1,18 +1,115 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+
+	"github.com/nsf/termbox-go"
 
 	"github.com/Grazfather/chip8"
+	"github.com/Grazfather/chip8/octo"
 )
 
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Println("usage: chip8 <filename>")
+	quirks := flag.String("quirks", chip8.DefaultQuirks, fmt.Sprintf("quirks profile, one of %v", chip8.QuirkNames()))
+	seed := flag.Int64("seed", 0, "seed for the random number generator, random if not given")
+	gdb := flag.String("gdb", "", "serve the GDB remote protocol on this address, e.g. localhost:1234, instead of the REPL")
+	dap := flag.String("dap", "", "serve the Debug Adapter Protocol on this address, or on stdin and stdout if \"stdio\", instead of the REPL")
+	srcmap := flag.String("srcmap", "", "source map file mapping addresses to source lines")
+	sym := flag.String("sym", "", "symbol map file of labels and addresses, <filename>.sym by default if it exists")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Println("usage: chip8 [-quirks PROFILE] [-seed N] [-gdb ADDR] [-dap ADDR] [-srcmap FILE] [-sym FILE] <filename>")
+		os.Exit(1)
+	}
+	q, err := chip8.QuirksByName(*quirks)
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
+	var opts []chip8.Option
+	seeded := false
+	flag.Visit(func(f *flag.Flag) { seeded = seeded || f.Name == "seed" })
+	if seeded {
+		opts = append(opts, chip8.WithSeed(*seed))
+	}
 
-	debugger := chip8.NewDebugger(os.Args[1])
+	debugger := chip8.NewDebugger(flag.Arg(0), q, opts...)
+	// Octo sources are compiled and debugged with their labels and lines,
+	// unless maps are given
+	if filepath.Ext(flag.Arg(0)) == ".8o" {
+		p, err := octo.Compile(flag.Arg(0))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		debugger.SetROM(p.ROM)
+		debugger.SetSymbols(p.Symbols)
+		debugger.SetSourceMap(p.Sources)
+	}
+	if *srcmap != "" {
+		m, err := chip8.LoadSourceMap(*srcmap)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		debugger.SetSourceMap(m)
+	}
+	if *sym == "" {
+		if _, err := os.Stat(flag.Arg(0) + ".sym"); err == nil {
+			*sym = flag.Arg(0) + ".sym"
+		}
+	}
+	if *sym != "" {
+		s, err := chip8.LoadSymbols(*sym)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		debugger.SetSymbols(s)
+	}
+	if *gdb != "" {
+		serveTerminal(func(t *chip8.Terminal, k chip8.Keypad) error {
+			return debugger.ServeGDB(*gdb, t, k)
+		})
+		return
+	}
+	if *dap == "stdio" {
+		// The terminal is the protocol stream so nothing can be shown or typed
+		if err := debugger.ServeDAP(*dap, &chip8.NullDisplay{}, &chip8.NoKeypad{}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *dap != "" {
+		serveTerminal(func(t *chip8.Terminal, k chip8.Keypad) error {
+			return debugger.ServeDAP(*dap, t, k)
+		})
+		return
+	}
 	debugger.Start()
 }
+
+// serveTerminal shows the game in the terminal while a remote client drives
+// it. Pressing the backtick key quits.
+func serveTerminal(serve func(t *chip8.Terminal, k chip8.Keypad) error) {
+	t, err := chip8.NewTerminal()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	events := make(chan termbox.Event)
+	k := chip8.NewTermKeypad(events)
+	go func() {
+		<-events
+		termbox.Close()
+		os.Exit(0)
+	}()
+	err = serve(t, k)
+	termbox.Close()
+	fmt.Println(err)
+	os.Exit(1)
+}
@@ -1,25 +1,40 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/jroimartin/gocui"
 
 	"github.com/Grazfather/chip8"
+	"github.com/Grazfather/chip8/asm"
+	"github.com/Grazfather/chip8/octo"
 )
 
+// c is the running emulator, used by layout to size the display.
+var c *chip8.Chip8
+
 func layout(g *gocui.Gui) error {
 	maxX, maxY := g.Size()
 	var err error
-	if maxY < chip8.SCREEN_HEIGHT || maxX < chip8.SCREEN_WIDTH {
+	width, height := chip8.SCREEN_WIDTH, chip8.SCREEN_HEIGHT
+	if c != nil {
+		width, height = chip8.CellSize(c.Screen())
+	}
+	if maxY < height || maxX < width {
 		return fmt.Errorf("Cannot display if less than %d x %d! Resize your terminal! (^Q to quit)",
-			chip8.SCREEN_WIDTH, chip8.SCREEN_HEIGHT)
+			width, height)
 	}
-	left := (maxX - chip8.SCREEN_WIDTH) / 2
-	_, err = g.SetView("display", left, 0, chip8.SCREEN_WIDTH+2+left, chip8.SCREEN_HEIGHT+2)
+	left := (maxX - width) / 2
+	_, err = g.SetView("display", left, 0, width+2+left, height+2)
 	if err != nil && err != gocui.ErrUnknownView {
 		return err
 	}
@@ -27,10 +42,110 @@ func layout(g *gocui.Gui) error {
 }
 
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Println("usage: chip8 <filename>")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "asm":
+			runCompile("asm", asm.Assemble, os.Args[2:])
+			return
+		case "octo":
+			runCompile("octo", octo.Compile, os.Args[2:])
+			return
+		case "dis":
+			runDis(os.Args[2:])
+			return
+		}
+	}
+	quirks := flag.String("quirks", chip8.DefaultQuirks, fmt.Sprintf("quirks profile, one of %v", chip8.QuirkNames()))
+	seed := flag.Int64("seed", 0, "seed for the random number generator, random if not given")
+	cycles := flag.Int("cycles", 10, "instructions to run per 60Hz frame")
+	headless := flag.Int("headless", 0, "run this many frames without a UI, then print the screen")
+	rewind := flag.Int("rewind", 30, "seconds of play that can be rewound by holding backspace")
+	traceFile := flag.String("trace", "", "write every instruction run to this file")
+	traceFormat := flag.String("tracefmt", "text", "trace format, text or binary")
+	traceRanges := flag.String("tracerange", "", "only trace these comma separated address ranges, like 0x200-0x2FF")
+	profileTop := flag.Int("profile", 0, "print this many of the most run addresses, and the cycles in each subroutine, on exit")
+	pprofFile := flag.String("pprof", "", "write a profile for go tool pprof to this file on exit")
+	coverFile := flag.String("cover", "", "write which instructions ran to this file on exit")
+	coverFormat := flag.String("coverfmt", "text", fmt.Sprintf("coverage format, one of %v", chip8.CoverageFormats))
+	srcmap := flag.String("srcmap", "", "source map, for html and lcov coverage")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Println("usage: chip8 [-quirks PROFILE] [-seed N] [-cycles N] [-headless FRAMES] [-trace FILE] [-profile N] [-pprof FILE] [-cover FILE] <filename>")
+		os.Exit(1)
+	}
+	q, err := chip8.QuirksByName(*quirks)
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
+	var opts []chip8.Option
+	seeded := false
+	flag.Visit(func(f *flag.Flag) { seeded = seeded || f.Name == "seed" })
+	if seeded {
+		opts = append(opts, chip8.WithSeed(*seed))
+	}
+
+	// Octo sources are compiled and run directly
+	var prog *asm.Program
+	if isOcto(flag.Arg(0)) {
+		if prog, err = octo.Compile(flag.Arg(0)); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	var tracer *chip8.Tracer
+	if *traceFile != "" {
+		tracer, err = openTrace(*traceFile, *traceFormat, *traceRanges)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer closeTrace(tracer)
+	}
+
+	var profiler *chip8.Profiler
+	if *profileTop > 0 || *pprofFile != "" {
+		profiler = chip8.NewProfiler()
+		if prog != nil {
+			profiler.SetSymbols(prog.Symbols)
+		}
+		// Deferred before the UI is set up, so this runs after it is closed
+		defer func() {
+			if err := writeProfile(profiler, c, *profileTop, *pprofFile); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}()
+	}
+
+	var coverage *chip8.Coverage
+	if *coverFile != "" {
+		var sources *chip8.SourceMap
+		if *srcmap != "" {
+			if sources, err = chip8.LoadSourceMap(*srcmap); err != nil {
+				fmt.Println(err)
+				closeTrace(tracer)
+				os.Exit(1)
+			}
+		}
+		coverage = chip8.NewCoverage()
+		if prog != nil {
+			if sources == nil {
+				sources = prog.Sources
+			}
+			coverage.SetSymbols(prog.Symbols)
+		}
+		defer func() {
+			if err := writeCoverage(coverage, c, sources, *coverFile, *coverFormat); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}()
+	}
+
+	if *headless > 0 {
+		runHeadless(flag.Arg(0), prog, q, opts, tracer, profiler, coverage, *cycles, *headless)
+		return
+	}
 
 	g, err := gocui.NewGui(gocui.OutputNormal)
 	if err != nil {
@@ -49,11 +164,15 @@ func main() {
 	g.SetCurrentView(v.Name())
 	k := chip8.NewGocuiKeypad(g, v)
 	r := chip8.NewGocuiRenderer(v)
-	c := chip8.NewChip8(r, k)
+	c = chip8.NewChip8(r, k, q, opts...)
+	c.SetTracer(tracer)
+	c.SetProfiler(profiler)
+	c.SetCoverage(coverage)
 	c.Reset()
 
-	if err := c.LoadBinary(os.Args[1]); err != nil {
-		fmt.Printf("Error loading %s: %v\n", os.Args[1], err)
+	if err := loadROM(c, flag.Arg(0), prog); err != nil {
+		fmt.Printf("Error loading %s: %v\n", flag.Arg(0), err)
+		closeTrace(tracer)
 		os.Exit(1)
 	}
 
@@ -62,19 +181,96 @@ func main() {
 		log.Panicln(err)
 	}
 
-	go func() {
-		go c.KeepTime()
+	// jobs run on the emulator goroutine between frames, so the hotkeys
+	// never see the machine mid-instruction.
+	jobs := make(chan func(), 1)
+	queue := func(job func()) {
+		// Drop the request rather than block the UI if one is pending
+		select {
+		case jobs <- job:
+		default:
+		}
+	}
+	stateFile := flag.Arg(0) + ".state"
+	status := func(format string, a ...interface{}) {
+		msg := fmt.Sprintf(format, a...)
+		g.Update(func(g *gocui.Gui) error {
+			v.Title = msg
+			return nil
+		})
+	}
+	if err := g.SetKeybinding("", gocui.KeyF5, gocui.ModNone,
+		func(g *gocui.Gui, v *gocui.View) error {
+			queue(func() {
+				if err := saveState(c, stateFile); err != nil {
+					status("save failed: %v", err)
+					return
+				}
+				status("saved %s", stateFile)
+			})
+			return nil
+		}); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("", gocui.KeyF9, gocui.ModNone,
+		func(g *gocui.Gui, v *gocui.View) error {
+			queue(func() {
+				if err := loadState(c, stateFile); err != nil {
+					status("load failed: %v", err)
+					return
+				}
+				status("loaded %s", stateFile)
+			})
+			return nil
+		}); err != nil {
+		log.Panicln(err)
+	}
+
+	// Holding backspace rewinds. We only get key repeats, so like the keypad
+	// treat the key as released once they stop for a moment.
+	rewinder := chip8.NewRewinder(*rewind * 60)
+	rewinder.Capture(c)
+	// rewinding is set from the key handler and read by the emulator loop
+	var rewinding int32
+	rewindUp := time.AfterFunc(0, func() { atomic.StoreInt32(&rewinding, 0) })
+	for _, key := range []gocui.Key{gocui.KeyBackspace, gocui.KeyBackspace2} {
+		if err := g.SetKeybinding("", key, gocui.ModNone,
+			func(g *gocui.Gui, v *gocui.View) error {
+				rewindUp.Reset(100 * time.Millisecond)
+				atomic.StoreInt32(&rewinding, 1)
+				return nil
+			}); err != nil {
+			log.Panicln(err)
+		}
+	}
 
-		tick := time.Tick(2 * time.Millisecond)
+	go func() {
+		tick := time.Tick(time.Second / 60)
 	LOOP:
 		for {
 			select {
+			case job := <-jobs:
+				job()
+				g.Update(func(g *gocui.Gui) error {
+					c.Render()
+					return nil
+				})
 			case <-tick:
-				err := c.RunOne()
+				if atomic.LoadInt32(&rewinding) != 0 {
+					if rewinder.Rewind(c, 1) > 0 {
+						g.Update(func(g *gocui.Gui) error {
+							c.Render()
+							return nil
+						})
+					}
+					continue
+				}
+				err := c.RunFrame(*cycles)
 				if err != nil {
 					fmt.Fprintln(os.Stderr, err)
 					break LOOP
 				}
+				rewinder.Capture(c)
 				if c.RenderFlag {
 					g.Update(func(g *gocui.Gui) error {
 						c.Render()
@@ -89,3 +285,284 @@ func main() {
 		log.Panicln(err)
 	}
 }
+
+func saveState(c *chip8.Chip8, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := c.SaveState(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func loadState(c *chip8.Chip8, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.LoadState(f)
+}
+
+// isOcto reports whether filename is Octo source rather than a ROM.
+func isOcto(filename string) bool {
+	return filepath.Ext(filename) == ".8o"
+}
+
+// loadROM loads prog if a source file was compiled, and the ROM file if not.
+func loadROM(emu *chip8.Chip8, filename string, prog *asm.Program) error {
+	if prog != nil {
+		return emu.LoadROM(prog.ROM)
+	}
+	return emu.LoadBinary(filename)
+}
+
+// runHeadless runs the ROM for a fixed number of frames with no display or
+// keys held, answering waits for a key with key 0, and prints the final
+// screen, so runs are reproducible.
+func runHeadless(rom string, prog *asm.Program, q chip8.Quirks, opts []chip8.Option, tracer *chip8.Tracer, profiler *chip8.Profiler, coverage *chip8.Coverage, cycles, frames int) {
+	emu := chip8.NewChip8(&chip8.NullDisplay{}, &chip8.FixedKeypad{}, q, opts...)
+	c = emu
+	emu.Silent = true
+	emu.SetTracer(tracer)
+	emu.SetProfiler(profiler)
+	emu.SetCoverage(coverage)
+	emu.Reset()
+	if err := loadROM(emu, rom, prog); err != nil {
+		fmt.Printf("Error loading %s: %v\n", rom, err)
+		closeTrace(tracer)
+		os.Exit(1)
+	}
+	for n := 0; n < frames; n++ {
+		if err := emu.RunFrame(cycles); err != nil {
+			if err != chip8.ErrExited {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			break
+		}
+	}
+	fmt.Print(chip8.ImageString(emu.Screen()))
+}
+
+// openTrace creates a trace file limited to ranges, a comma separated list of
+// START-END addresses.
+func openTrace(filename, format, ranges string) (*chip8.Tracer, error) {
+	f, err := chip8.TraceFormatByName(format)
+	if err != nil {
+		return nil, err
+	}
+	type addrRange struct{ start, end uint16 }
+	var filters []addrRange
+	for _, r := range strings.Split(ranges, ",") {
+		if r == "" {
+			continue
+		}
+		start, end, err := parseRange(r)
+		if err != nil {
+			return nil, fmt.Errorf("trace %v", err)
+		}
+		filters = append(filters, addrRange{start, end})
+	}
+	t, err := chip8.CreateTrace(filename, f)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range filters {
+		t.Filter(r.start, r.end)
+	}
+	return t, nil
+}
+
+// closeTrace flushes and closes the trace, if there is one. os.Exit skips the
+// deferred close, so call it before exiting on an error.
+func closeTrace(tracer *chip8.Tracer) {
+	if tracer == nil {
+		return
+	}
+	if err := tracer.Close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// parseRange parses a START-END address range.
+func parseRange(r string) (start, end uint16, err error) {
+	bounds := strings.SplitN(r, "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("range %q is not START-END", r)
+	}
+	s, err := strconv.ParseUint(bounds[0], 0, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("range %q: %v", r, err)
+	}
+	e, err := strconv.ParseUint(bounds[1], 0, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("range %q: %v", r, err)
+	}
+	if s > e {
+		return 0, 0, fmt.Errorf("range %q ends before it starts", r)
+	}
+	return uint16(s), uint16(e), nil
+}
+
+// writeProfile prints the top hottest addresses of c to stderr, if top is set,
+// and writes a pprof profile to filename, if it is set.
+func writeProfile(p *chip8.Profiler, c *chip8.Chip8, top int, filename string) error {
+	if c == nil {
+		return nil
+	}
+	if top > 0 {
+		if err := p.WriteReport(os.Stderr, c, top); err != nil {
+			return err
+		}
+	}
+	if filename == "" {
+		return nil
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := p.WritePprof(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeCoverage writes the coverage of c to filename in format.
+func writeCoverage(cov *chip8.Coverage, c *chip8.Chip8, m *chip8.SourceMap, filename, format string) error {
+	if c == nil {
+		return nil
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := cov.Write(f, c, m, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runCompile is the asm and octo subcommands, which build a source file into
+// a ROM and its symbol map with compile.
+func runCompile(name string, compile func(filename string) (*asm.Program, error), args []string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	out := flags.String("o", "", "ROM to write, the source file with a .ch8 extension by default")
+	sym := flags.String("sym", "", "symbol map to write, <ROM>.sym by default")
+	srcmap := flags.String("srcmap", "", "source map to write, if any")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Printf("usage: chip8 %s [-o ROM] [-sym FILE] [-srcmap FILE] <source>\n", name)
+		os.Exit(1)
+	}
+	src := flags.Arg(0)
+	if *out == "" {
+		*out = strings.TrimSuffix(src, filepath.Ext(src)) + ".ch8"
+	}
+	if *sym == "" {
+		*sym = *out + ".sym"
+	}
+	p, err := compile(src)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*out, p.ROM, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := writeMap(*sym, p.Symbols.Write); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *srcmap != "" {
+		if err := writeMap(*srcmap, p.Sources.Write); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// runDis is the dis subcommand, which writes a listing of a ROM to stdout.
+func runDis(args []string) {
+	flags := flag.NewFlagSet("dis", flag.ExitOnError)
+	ranges := flags.String("range", "", "only list this address range, like 0x200-0x2FF")
+	raw := flags.Bool("raw", false, "add the opcodes of instructions to their comments")
+	linear := flags.Bool("linear", false, "decode every instruction in order, rather than following the code")
+	syntax := flags.String("syntax", "mnemonic", "syntax of the listing, mnemonic or octo")
+	asJSON := flags.Bool("json", false, "write the listing as JSON")
+	sym := flags.String("sym", "", "symbol map to label addresses with, <ROM>.sym by default if it exists")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Println("usage: chip8 dis [-range START-END] [-raw] [-linear] [-syntax mnemonic|octo] [-json] [-sym FILE] <ROM>")
+		os.Exit(1)
+	}
+	opts := chip8.ListingOptions{Raw: *raw}
+	var err error
+	if opts.Syntax, err = chip8.SyntaxByName(*syntax); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *ranges != "" {
+		if opts.Start, opts.End, err = parseRange(*ranges); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			flags.Usage()
+			os.Exit(1)
+		}
+	}
+	rom, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(rom) > chip8.XO_MAX_MEM_ADDRESS-0x200 {
+		fmt.Fprintf(os.Stderr, "%s is %d bytes, which doesn't fit in memory\n", flags.Arg(0), len(rom))
+		os.Exit(1)
+	}
+	var d chip8.Disassembler
+	if *sym == "" {
+		if _, err := os.Stat(flags.Arg(0) + ".sym"); err == nil {
+			*sym = flags.Arg(0) + ".sym"
+		}
+	}
+	if *sym != "" {
+		s, err := chip8.LoadSymbols(*sym)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		d.SetSymbols(s)
+	}
+	analyze := d.Analyze
+	if *linear {
+		analyze = d.AnalyzeLinear
+	}
+	a := analyze(rom)
+	if *asJSON {
+		err = a.WriteJSON(os.Stdout, opts)
+	} else {
+		err = a.WriteListing(os.Stdout, opts)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// writeMap creates filename and writes a map to it with write.
+func writeMap(filename string, write func(io.Writer) error) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,384 @@
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// BranchCount is how often a skip instruction skipped the next instruction
+// and how often it didn't.
+type BranchCount struct {
+	Skipped    uint64
+	NotSkipped uint64
+}
+
+// Partial reports whether the branch ran but only went one way.
+func (b BranchCount) Partial() bool {
+	return (b.Skipped == 0) != (b.NotSkipped == 0)
+}
+
+// isSkip reports whether ins is one of the conditional skips: 3XNN, 4XNN,
+// 5XY0, 9XY0, EX9E and EXA1.
+func isSkip(ins uint16) bool {
+	switch ins & 0xF000 {
+	case 0x3000, 0x4000:
+		return true
+	case 0x5000, 0x9000:
+		return ins&0xF == 0
+	case 0xE000:
+		return ins&0xFF == 0x9E || ins&0xFF == 0xA1
+	}
+	return false
+}
+
+// Coverage records which addresses ran and which way each skip went. Install
+// it with SetCoverage.
+type Coverage struct {
+	mu       sync.Mutex
+	hits     [XO_MAX_MEM_ADDRESS]uint64
+	branches map[uint16]*BranchCount
+	symbols  *Symbols
+	dis      Disassembler
+}
+
+func NewCoverage() *Coverage {
+	return &Coverage{branches: make(map[uint16]*BranchCount)}
+}
+
+// SetSymbols makes the report show labels.
+func (cov *Coverage) SetSymbols(s *Symbols) {
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+	cov.symbols = s
+	cov.dis.SetSymbols(s)
+}
+
+// Reset forgets everything recorded so far.
+func (cov *Coverage) Reset() {
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+	cov.hits = [XO_MAX_MEM_ADDRESS]uint64{}
+	cov.branches = make(map[uint16]*BranchCount)
+}
+
+// record notes that the instruction ins ran at pc, leaving the PC at next.
+func (cov *Coverage) record(pc, ins, next uint16) {
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+	cov.hits[pc]++
+	if !isSkip(ins) {
+		return
+	}
+	b, ok := cov.branches[pc]
+	if !ok {
+		b = &BranchCount{}
+		cov.branches[pc] = b
+	}
+	if next == pc+2 {
+		b.NotSkipped++
+	} else {
+		b.Skipped++
+	}
+}
+
+// Hits returns how many times the instruction at addr ran.
+func (cov *Coverage) Hits(addr uint16) uint64 {
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+	return cov.hits[addr]
+}
+
+// Branch returns which ways the skip at addr went, if it ran.
+func (cov *Coverage) Branch(addr uint16) (BranchCount, bool) {
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+	b, ok := cov.branches[addr]
+	if !ok {
+		return BranchCount{}, false
+	}
+	return *b, true
+}
+
+// covLine is an instruction, or a byte of data, in the ROM listing.
+type covLine struct {
+	addr   uint16
+	ins    instruction
+	data   bool
+	hits   uint64
+	branch *BranchCount
+}
+
+// listing disassembles the ROM loaded in c. Bytes that are skipped over to
+// reach an instruction that ran at an odd address are listed as data.
+func (cov *Coverage) listing(c *Chip8) []covLine {
+	var lines []covLine
+	end := 0x200 + c.romSize
+	for addr := 0x200; addr < end && addr+1 < c.MemSize(); {
+		l := covLine{addr: uint16(addr), hits: cov.hits[addr], branch: cov.branches[uint16(addr)]}
+		if l.hits == 0 && cov.hits[addr+1] > 0 {
+			l.data = true
+			lines = append(lines, l)
+			addr++
+			continue
+		}
+		l.ins = cov.dis.dis(c.mem[addr:])
+		lines = append(lines, l)
+		addr += int(l.ins.Size())
+	}
+	return lines
+}
+
+// WriteReport writes the disassembly of the ROM loaded in c annotated with how
+// many times each instruction ran and which ways each skip went. Skips that
+// only went one way are marked with a !.
+func (cov *Coverage) WriteReport(w io.Writer, c *Chip8) error {
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+	lines := cov.listing(c)
+	ran, code := 0, 0
+	ways, skips := 0, 0
+	for _, l := range lines {
+		if l.data {
+			continue
+		}
+		code++
+		if l.hits > 0 {
+			ran++
+		}
+		if l.ins.IsSkip() {
+			skips += 2
+			if l.branch != nil {
+				if l.branch.Skipped > 0 {
+					ways++
+				}
+				if l.branch.NotSkipped > 0 {
+					ways++
+				}
+			}
+		}
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%d of %d instructions ran (%s), %d of %d skip outcomes taken (%s)\n\n",
+		ran, code, coverPercent(ran, code), ways, skips, coverPercent(ways, skips))
+	for _, l := range lines {
+		if label, ok := cov.symbols.Label(l.addr); ok {
+			fmt.Fprintf(bw, "%10s  %s:\n", "", label)
+		}
+		count := "-"
+		if l.hits > 0 {
+			count = fmt.Sprint(l.hits)
+		}
+		if l.data {
+			fmt.Fprintf(bw, "%10s  0x%04X %02X   DB 0x%02X\n", count, l.addr, c.mem[l.addr], c.mem[l.addr])
+			continue
+		}
+		line := fmt.Sprintf("%10s  0x%04X %04X %s", count, l.addr, l.ins.Op, l.ins)
+		if b := l.branch; b != nil {
+			mark := ""
+			if b.Partial() {
+				mark = " !"
+			}
+			line = fmt.Sprintf("%-45s skipped %d, not skipped %d%s", line, b.Skipped, b.NotSkipped, mark)
+		}
+		fmt.Fprintln(bw, line)
+	}
+	return bw.Flush()
+}
+
+func coverPercent(n, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+// covSourceLine is the coverage of a line of source.
+type covSourceLine struct {
+	line     int
+	hits     uint64
+	branches []BranchCount
+	// ran is set for each branch whose skip ran at all
+	ran []bool
+}
+
+// sourceLines returns the coverage of each line in m of the ROM loaded in c, by
+// file. A line counts as run as many times as its most run instruction.
+func (cov *Coverage) sourceLines(c *Chip8, m *SourceMap) map[string][]covSourceLine {
+	byLoc := make(map[SourceLocation]*covSourceLine)
+	addrs := make([]uint16, 0, len(m.lines))
+	for addr := range m.lines {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	for _, addr := range addrs {
+		loc := m.lines[addr]
+		l, ok := byLoc[loc]
+		if !ok {
+			l = &covSourceLine{line: loc.Line}
+			byLoc[loc] = l
+		}
+		if cov.hits[addr] > l.hits {
+			l.hits = cov.hits[addr]
+		}
+		if int(addr)+1 < c.MemSize() && isSkip(uint16(c.mem[addr])<<8|uint16(c.mem[addr+1])) {
+			b, ok := cov.branches[addr]
+			if !ok {
+				b = &BranchCount{}
+			}
+			l.branches = append(l.branches, *b)
+			l.ran = append(l.ran, ok)
+		}
+	}
+	files := make(map[string][]covSourceLine)
+	for loc, l := range byLoc {
+		files[loc.File] = append(files[loc.File], *l)
+	}
+	for _, lines := range files {
+		sort.Slice(lines, func(i, j int) bool { return lines[i].line < lines[j].line })
+	}
+	return files
+}
+
+// sortedFiles returns the keys of files in order.
+func sortedFiles(files map[string][]covSourceLine) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WriteLcov writes the coverage of the source lines in m, for the ROM loaded
+// in c, as an lcov tracefile. Each skip is a branch with two outcomes.
+func (cov *Coverage) WriteLcov(w io.Writer, c *Chip8, m *SourceMap) error {
+	cov.mu.Lock()
+	defer cov.mu.Unlock()
+	files := cov.sourceLines(c, m)
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "TN:")
+	for _, name := range sortedFiles(files) {
+		fmt.Fprintf(bw, "SF:%s\n", name)
+		ran, branches, taken := 0, 0, 0
+		for _, l := range files[name] {
+			for n, b := range l.branches {
+				branches += 2
+				if !l.ran[n] {
+					fmt.Fprintf(bw, "BRDA:%d,%d,0,-\nBRDA:%d,%d,1,-\n", l.line, n, l.line, n)
+					continue
+				}
+				fmt.Fprintf(bw, "BRDA:%d,%d,0,%d\n", l.line, n, b.Skipped)
+				fmt.Fprintf(bw, "BRDA:%d,%d,1,%d\n", l.line, n, b.NotSkipped)
+				if b.Skipped > 0 {
+					taken++
+				}
+				if b.NotSkipped > 0 {
+					taken++
+				}
+			}
+		}
+		fmt.Fprintf(bw, "BRF:%d\nBRH:%d\n", branches, taken)
+		for _, l := range files[name] {
+			fmt.Fprintf(bw, "DA:%d,%d\n", l.line, l.hits)
+			if l.hits > 0 {
+				ran++
+			}
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\n", len(files[name]), ran)
+		fmt.Fprintln(bw, "end_of_record")
+	}
+	return bw.Flush()
+}
+
+// covHTMLStyle colours lines that ran, didn't run, and skips that only went
+// one way.
+const covHTMLStyle = `body { font-family: sans-serif; }
+pre { font-family: monospace; margin: 0; }
+.ran { background: #cfc; }
+.missed { background: #fcc; }
+.partial { background: #ffc; }
+.count { color: #666; display: inline-block; width: 6em; text-align: right; padding-right: 1em; }`
+
+// WriteHTML writes the source files in m as a web page, with each line
+// coloured by whether the ROM loaded in c ran it. The source files are read
+// from disk.
+func (cov *Coverage) WriteHTML(w io.Writer, c *Chip8, m *SourceMap) error {
+	cov.mu.Lock()
+	files := cov.sourceLines(c, m)
+	cov.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>Coverage</title>\n<style>\n%s\n</style></head><body>\n", covHTMLStyle)
+	for _, name := range sortedFiles(files) {
+		src, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		lines := make(map[int]covSourceLine)
+		ran := 0
+		for _, l := range files[name] {
+			lines[l.line] = l
+			if l.hits > 0 {
+				ran++
+			}
+		}
+		fmt.Fprintf(bw, "<h2>%s</h2>\n<p>%d of %d lines ran (%s)</p>\n",
+			html.EscapeString(name), ran, len(files[name]), coverPercent(ran, len(files[name])))
+		s := bufio.NewScanner(src)
+		for n := 1; s.Scan(); n++ {
+			class, count := "", ""
+			if l, ok := lines[n]; ok {
+				class, count = "missed", "0"
+				if l.hits > 0 {
+					class, count = "ran", fmt.Sprint(l.hits)
+				}
+				for _, b := range l.branches {
+					if b.Partial() {
+						class = "partial"
+					}
+				}
+			}
+			fmt.Fprintf(bw, "<pre class=\"%s\"><span class=\"count\">%s</span>%4d  %s</pre>\n",
+				class, count, n, html.EscapeString(s.Text()))
+		}
+		err = s.Err()
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(bw, "</body></html>")
+	return bw.Flush()
+}
+
+// CoverageFormats are the formats Write takes.
+var CoverageFormats = []string{"html", "lcov", "text"}
+
+// Write writes the coverage of the ROM loaded in c in the named format, one
+// of CoverageFormats. The html and lcov formats are of source lines, so they
+// need the source map m.
+func (cov *Coverage) Write(w io.Writer, c *Chip8, m *SourceMap, format string) error {
+	switch format {
+	case "text":
+		return cov.WriteReport(w, c)
+	case "html", "lcov":
+		if m == nil {
+			return fmt.Errorf("%s coverage needs a source map", format)
+		}
+		if format == "html" {
+			return cov.WriteHTML(w, c, m)
+		}
+		return cov.WriteLcov(w, c, m)
+	}
+	return fmt.Errorf("unknown coverage format %q (have %v)", format, CoverageFormats)
+}
+
+// SetCoverage makes c record every instruction it runs in cov, or stops
+// recording if cov is nil.
+func (c *Chip8) SetCoverage(cov *Coverage) {
+	c.coverage = cov
+}
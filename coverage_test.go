@@ -0,0 +1,153 @@
+package chip8
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// coverageROM runs its SE both ways, then loops, never reaching its SNE.
+var coverageROM = []byte{
+	0x60, 0x00, // 0x200 LD V0, 0
+	0x30, 0x01, // 0x202 loop: SE V0, 1
+	0x70, 0x01, // 0x204 ADD V0, 1
+	0x12, 0x02, // 0x206 JP loop
+	0x40, 0x00, // 0x208 SNE V0, 0
+	0x00, 0xFD, // 0x20A EXIT
+}
+
+// coverageSource is the source of coverageROM, and the line of each
+// instruction in it.
+var coverageSource = []string{
+	": main",
+	"  v0 := 0",
+	"  loop",
+	"    if v0 != 1 then",
+	"    v0 += 1",
+	"  again",
+	"  if v0 == 0 then",
+	"  exit",
+}
+
+var coverageLines = map[uint16]int{0x200: 2, 0x202: 4, 0x204: 5, 0x206: 6, 0x208: 7, 0x20A: 8}
+
+// runCovered runs the first 6 instructions of coverageROM, which takes the
+// SE at 0x202 once each way.
+func runCovered(t *testing.T) (*Chip8, *Coverage) {
+	t.Helper()
+	c := NewChip8(&NullDisplay{}, &NoKeypad{}, Quirks{}, WithSeed(1))
+	c.Reset()
+	if err := c.LoadROM(coverageROM); err != nil {
+		t.Fatal(err)
+	}
+	cov := NewCoverage()
+	c.SetCoverage(cov)
+	for n := 0; n < 6; n++ {
+		if err := c.RunOne(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c, cov
+}
+
+// coverageMap writes coverageSource to a file and maps coverageROM onto it.
+func coverageMap(t *testing.T) (string, *SourceMap) {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "cover.8o")
+	writeScript(t, filename, coverageSource...)
+	m := NewSourceMap()
+	for addr, line := range coverageLines {
+		m.Add(addr, SourceLocation{filename, line})
+	}
+	return filename, m
+}
+
+func TestCoverageBranches(t *testing.T) {
+	_, cov := runCovered(t)
+	for addr, want := range map[uint16]uint64{0x200: 1, 0x202: 2, 0x204: 1, 0x206: 2, 0x208: 0} {
+		if got := cov.Hits(addr); got != want {
+			t.Errorf("Hits(0x%04X) = %d, want %d", addr, got, want)
+		}
+	}
+	b, ok := cov.Branch(0x202)
+	if !ok || b != (BranchCount{Skipped: 1, NotSkipped: 1}) || b.Partial() {
+		t.Errorf("Branch(0x202) = %+v, %v, want skipped and not skipped once", b, ok)
+	}
+	if _, ok := cov.Branch(0x208); ok {
+		t.Error("Branch(0x208) is set, but the SNE never ran")
+	}
+	if _, ok := cov.Branch(0x204); ok {
+		t.Error("Branch(0x204) is set for an ADD")
+	}
+}
+
+func TestCoverageReport(t *testing.T) {
+	c, cov := runCovered(t)
+	var buf bytes.Buffer
+	if err := cov.Write(&buf, c, nil, "text"); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"4 of 6 instructions ran (66.7%), 2 of 4 skip outcomes taken (50.0%)",
+		"skipped 1, not skipped 1\n",
+		"-  0x0208 4000 SNE V0, 0x00\n",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("report doesn't have %q:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestCoverageLcov(t *testing.T) {
+	c, cov := runCovered(t)
+	filename, m := coverageMap(t)
+	var buf bytes.Buffer
+	if err := cov.Write(&buf, c, m, "lcov"); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"TN:",
+		"SF:" + filename,
+		"BRDA:4,0,0,1",
+		"BRDA:4,0,1,1",
+		"BRDA:7,0,0,-",
+		"BRDA:7,0,1,-",
+		"BRF:4",
+		"BRH:2",
+		"DA:2,1",
+		"DA:4,2",
+		"DA:5,1",
+		"DA:6,2",
+		"DA:7,0",
+		"DA:8,0",
+		"LF:6",
+		"LH:4",
+		"end_of_record",
+	}, "\n") + "\n"
+	if buf.String() != want {
+		t.Errorf("lcov:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestCoverageHTML(t *testing.T) {
+	c, cov := runCovered(t)
+	_, m := coverageMap(t)
+	var buf bytes.Buffer
+	if err := cov.Write(&buf, c, m, "html"); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"<p>4 of 6 lines ran (66.7%)</p>",
+		`<pre class=""><span class="count"></span>   1  : main</pre>`,
+		`<pre class="ran"><span class="count">2</span>   4      if v0 != 1 then</pre>`,
+		`<pre class="missed"><span class="count">0</span>   7    if v0 == 0 then</pre>`,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("html doesn't have %q:\n%s", line, buf.String())
+		}
+	}
+	if err := cov.Write(&buf, c, nil, "html"); err == nil {
+		t.Error("html coverage without a source map succeeded")
+	}
+}
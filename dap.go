@@ -0,0 +1,753 @@
+package chip8
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The CHIP-8 has a single thread of execution.
+const dapThreadID = 1
+
+// Variable references for the scopes we report.
+const (
+	dapRegistersRef = 1
+	dapStackRef     = 2
+)
+
+// dapMessage is a request from the client.
+type dapMessage struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapSource struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type dapBreakpoint struct {
+	Verified             bool   `json:"verified"`
+	Message              string `json:"message,omitempty"`
+	Line                 int    `json:"line,omitempty"`
+	InstructionReference string `json:"instructionReference,omitempty"`
+}
+
+type dapStackFrame struct {
+	ID                          int        `json:"id"`
+	Name                        string     `json:"name"`
+	Source                      *dapSource `json:"source,omitempty"`
+	Line                        int        `json:"line"`
+	Column                      int        `json:"column"`
+	InstructionPointerReference string     `json:"instructionPointerReference"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	VariablesReference int    `json:"variablesReference"`
+	MemoryReference    string `json:"memoryReference,omitempty"`
+}
+
+type dapInstruction struct {
+	Address          string     `json:"address"`
+	InstructionBytes string     `json:"instructionBytes,omitempty"`
+	Instruction      string     `json:"instruction"`
+	Symbol           string     `json:"symbol,omitempty"`
+	Location         *dapSource `json:"location,omitempty"`
+	Line             int        `json:"line,omitempty"`
+}
+
+// dapSession serves one Debug Adapter Protocol client.
+type dapSession struct {
+	d        *Debugger
+	w        *bufio.Writer
+	seq      int
+	requests chan *dapMessage
+	// running is set while the machine runs between requests
+	running bool
+	first   bool
+	// depth stops a step once SP is at least this deep, or never when -1
+	depth       int
+	stopOnEntry bool
+	// Addresses of the breakpoints set for each source and by instruction
+	lineBPs map[string][]uint16
+	insBPs  []uint16
+}
+
+// ServeDAP loads the ROM and serves the Debug Adapter Protocol, either on
+// stdin and stdout if addr is "stdio", or one client at a time on the TCP
+// address addr. Drawing and keys go through r and k.
+func (d *Debugger) ServeDAP(addr string, r Renderer, k Keypad) error {
+	if err := d.Attach(r, k); err != nil {
+		return err
+	}
+	if addr == "stdio" {
+		// The bell would corrupt the protocol stream
+		d.c.Silent = true
+		return d.serveDAP(os.Stdin, os.Stdout)
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		d.serveDAP(conn, conn)
+		conn.Close()
+	}
+}
+
+func (d *Debugger) serveDAP(r io.Reader, w io.Writer) error {
+	s := &dapSession{
+		d:        d,
+		w:        bufio.NewWriter(w),
+		requests: make(chan *dapMessage),
+		depth:    -1,
+		lineBPs:  make(map[string][]uint16),
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.readRequests(r)
+	}()
+	s.serve()
+	// Let the reader finish if we stopped first
+	go func() {
+		for range s.requests {
+		}
+	}()
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// readRequests decodes Content-Length framed requests until the stream ends.
+func (s *dapSession) readRequests(r io.Reader) error {
+	defer close(s.requests)
+	tp := textproto.NewReader(bufio.NewReader(r))
+	for {
+		header, err := tp.ReadMIMEHeader()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			return fmt.Errorf("bad Content-Length %q", header.Get("Content-Length"))
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(tp.R, body); err != nil {
+			return err
+		}
+		var msg dapMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			return err
+		}
+		if msg.Type == "request" {
+			s.requests <- &msg
+		}
+	}
+}
+
+func (s *dapSession) send(msg interface{}) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n", len(body))
+	s.w.Write(body)
+	s.w.Flush()
+}
+
+func (s *dapSession) respond(req *dapMessage, body interface{}) {
+	s.seq++
+	s.send(dapResponse{s.seq, "response", req.Seq, true, req.Command, "", body})
+}
+
+func (s *dapSession) fail(req *dapMessage, format string, a ...interface{}) {
+	s.seq++
+	s.send(dapResponse{s.seq, "response", req.Seq, false, req.Command, fmt.Sprintf(format, a...), nil})
+}
+
+func (s *dapSession) event(name string, body interface{}) {
+	s.seq++
+	s.send(dapEvent{s.seq, "event", name, body})
+}
+
+// serve handles requests, running the machine at the debugger's pace between
+// them while it isn't stopped.
+func (s *dapSession) serve() {
+	tick := time.NewTicker(2 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		var ticks <-chan time.Time
+		if s.running {
+			ticks = tick.C
+		}
+		select {
+		case req, ok := <-s.requests:
+			if !ok || s.handle(req) {
+				return
+			}
+		case <-ticks:
+			s.runOne()
+		}
+	}
+}
+
+func (s *dapSession) stopped(reason, text string) {
+	s.running = false
+	body := map[string]interface{}{
+		"reason":            reason,
+		"threadId":          dapThreadID,
+		"allThreadsStopped": true,
+	}
+	if text != "" {
+		body["description"] = text
+	}
+	s.event("stopped", body)
+}
+
+func (s *dapSession) resume(depth int) {
+	s.running = true
+	s.first = true // So we can continue through a breakpoint
+	s.depth = depth
+}
+
+// runOne runs an instruction, stopping when it hits something or finishes a
+// step.
+func (s *dapSession) runOne() {
+	stop, ok := s.d.advance(s.first)
+	s.first = false
+	switch {
+	case !ok:
+		if s.depth >= 0 && s.d.c.sp >= s.depth {
+			s.stopped("step", "")
+		}
+	case stop.Reason == StopExited:
+		s.running = false
+		s.event("exited", map[string]int{"exitCode": 0})
+		s.event("terminated", nil)
+	case stop.Reason == StopError:
+		s.stopped("exception", stop.String())
+	case stop.Reason == StopWatchpoint:
+		s.stopped("data breakpoint", stop.String())
+	default:
+		s.stopped("breakpoint", stop.String())
+	}
+}
+
+// handle answers a request, returning true when the session is over.
+func (s *dapSession) handle(req *dapMessage) bool {
+	d := s.d
+	switch req.Command {
+	case "initialize":
+		s.respond(req, map[string]bool{
+			"supportsConfigurationDoneRequest":  true,
+			"supportsConditionalBreakpoints":    true,
+			"supportsHitConditionalBreakpoints": true,
+			"supportsEvaluateForHovers":         true,
+			"supportsSetVariable":               true,
+			"supportsDisassembleRequest":        true,
+			"supportsInstructionBreakpoints":    true,
+			"supportsReadMemoryRequest":         true,
+			"supportsTerminateRequest":          true,
+		})
+		s.event("initialized", nil)
+	case "launch", "attach":
+		var args struct {
+			Program     string `json:"program"`
+			SourceMap   string `json:"sourceMap"`
+			StopOnEntry bool   `json:"stopOnEntry"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		if args.SourceMap != "" {
+			m, err := LoadSourceMap(args.SourceMap)
+			if err != nil {
+				s.fail(req, "%v", err)
+				return false
+			}
+			d.SetSourceMap(m)
+		}
+		if args.Program != "" && args.Program != d.rom {
+			d.rom = args.Program
+			d.romData = nil
+			d.undo.Reset()
+			d.rewind.Reset()
+			if err := d.load(); err != nil {
+				s.fail(req, "%v", err)
+				return false
+			}
+			d.c.Render()
+		}
+		s.stopOnEntry = args.StopOnEntry
+		s.respond(req, nil)
+	case "configurationDone":
+		s.respond(req, nil)
+		if s.stopOnEntry {
+			s.stopped("entry", "")
+		} else {
+			s.resume(-1)
+		}
+	case "setBreakpoints":
+		s.setBreakpoints(req)
+	case "setInstructionBreakpoints":
+		s.setInstructionBreakpoints(req)
+	case "setExceptionBreakpoints":
+		s.respond(req, nil)
+	case "threads":
+		s.respond(req, map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": dapThreadID, "name": "CHIP-8"}},
+		})
+	case "stackTrace":
+		s.stackTrace(req)
+	case "scopes":
+		s.respond(req, map[string]interface{}{
+			"scopes": []map[string]interface{}{
+				{"name": "Registers", "presentationHint": "registers", "variablesReference": dapRegistersRef, "expensive": false},
+				{"name": "Stack", "variablesReference": dapStackRef, "expensive": false},
+			},
+		})
+	case "variables":
+		s.variables(req)
+	case "setVariable":
+		s.setVariable(req)
+	case "evaluate":
+		s.evaluate(req)
+	case "readMemory":
+		s.readMemory(req)
+	case "disassemble":
+		s.disassemble(req)
+	case "continue":
+		s.respond(req, map[string]bool{"allThreadsContinued": true})
+		s.resume(-1)
+	case "next":
+		// Anything but a call stops after one instruction
+		s.respond(req, nil)
+		s.resume(d.c.sp)
+	case "stepIn":
+		s.respond(req, nil)
+		s.resume(0)
+	case "stepOut":
+		if d.Depth() == 0 {
+			s.fail(req, "not in a subroutine")
+			break
+		}
+		s.respond(req, nil)
+		s.resume(d.c.sp + 2)
+	case "pause":
+		s.respond(req, nil)
+		if s.running {
+			s.stopped("pause", "")
+		}
+	case "disconnect", "terminate":
+		s.respond(req, nil)
+		if req.Command == "terminate" {
+			s.event("terminated", nil)
+		}
+		return true
+	default:
+		s.fail(req, "unsupported request %q", req.Command)
+	}
+	return false
+}
+
+// dapBreakpointArgs is a source or instruction breakpoint from the client.
+type dapBreakpointArgs struct {
+	Line                 int    `json:"line"`
+	InstructionReference string `json:"instructionReference"`
+	Offset               int    `json:"offset"`
+	Condition            string `json:"condition"`
+	HitCondition         string `json:"hitCondition"`
+}
+
+// addBreakpoint sets a debugger breakpoint at addr for b, reporting why it
+// couldn't if it fails.
+func (s *dapSession) addBreakpoint(addr uint16, b dapBreakpointArgs) string {
+	var cond *Expr
+	if b.Condition != "" {
+		var err error
+		if cond, err = ParseExpr(b.Condition); err != nil {
+			return err.Error()
+		}
+	}
+	ignore := 0
+	if b.HitCondition != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(b.HitCondition))
+		if err != nil || n < 1 {
+			return fmt.Sprintf("hit condition must be a positive count, not %q", b.HitCondition)
+		}
+		ignore = n - 1
+	}
+	s.d.AddBreakpoint(addr, cond, false)
+	s.d.SetBreakpointIgnore(addr, ignore)
+	return ""
+}
+
+// setBreakpoints replaces the breakpoints in a source file, mapping lines to
+// addresses with the source map.
+func (s *dapSession) setBreakpoints(req *dapMessage) {
+	var args struct {
+		Source      dapSource           `json:"source"`
+		Breakpoints []dapBreakpointArgs `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, "%v", err)
+		return
+	}
+	d := s.d
+	path := args.Source.Path
+	for _, addr := range s.lineBPs[path] {
+		d.RemoveBreakpoint(addr, false)
+	}
+	delete(s.lineBPs, path)
+
+	bps := make([]dapBreakpoint, len(args.Breakpoints))
+	for n, b := range args.Breakpoints {
+		bps[n].Line = b.Line
+		if d.sources == nil {
+			bps[n].Message = "no source map loaded"
+			continue
+		}
+		addrs := d.sources.Addrs(SourceLocation{path, b.Line})
+		if len(addrs) == 0 {
+			bps[n].Message = "no code at this line"
+			continue
+		}
+		if msg := s.addBreakpoint(addrs[0], b); msg != "" {
+			bps[n].Message = msg
+			continue
+		}
+		s.lineBPs[path] = append(s.lineBPs[path], addrs[0])
+		bps[n].Verified = true
+		bps[n].InstructionReference = fmt.Sprintf("0x%04X", addrs[0])
+	}
+	s.respond(req, map[string]interface{}{"breakpoints": bps})
+}
+
+// setInstructionBreakpoints replaces the breakpoints set by address.
+func (s *dapSession) setInstructionBreakpoints(req *dapMessage) {
+	var args struct {
+		Breakpoints []dapBreakpointArgs `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, "%v", err)
+		return
+	}
+	d := s.d
+	for _, addr := range s.insBPs {
+		d.RemoveBreakpoint(addr, false)
+	}
+	s.insBPs = nil
+
+	bps := make([]dapBreakpoint, len(args.Breakpoints))
+	for n, b := range args.Breakpoints {
+		addr, err := d.parseAddr(b.InstructionReference)
+		if err == nil {
+			addr, err = d.parseAddr(strconv.Itoa(int(addr) + b.Offset))
+		}
+		if err != nil {
+			bps[n].Message = err.Error()
+			continue
+		}
+		bps[n].InstructionReference = fmt.Sprintf("0x%04X", addr)
+		if msg := s.addBreakpoint(addr, b); msg != "" {
+			bps[n].Message = msg
+			continue
+		}
+		s.insBPs = append(s.insBPs, addr)
+		bps[n].Verified = true
+	}
+	s.respond(req, map[string]interface{}{"breakpoints": bps})
+}
+
+// frames returns the call stack, innermost first. Frame 0 is at PC and each
+// frame after it is at the call that made the one before.
+func (s *dapSession) frames() []dapStackFrame {
+	c := s.d.c
+	var frames []dapStackFrame
+	pc := c.pc
+	top := c.sp / 2
+	if top > len(c.stack) {
+		top = len(c.stack)
+	}
+	for n := top; n <= len(c.stack); n++ {
+		entry := mainRoutine
+		if n < len(c.stack) && int(c.stack[n])+1 < len(c.mem) {
+			// The stack holds the address of each call
+			entry = int(ArgNNN(binary.BigEndian.Uint16(c.mem[c.stack[n]:])))
+		}
+		f := dapStackFrame{
+			ID:                          len(frames),
+			Name:                        routineName(s.d.symbols, entry),
+			InstructionPointerReference: fmt.Sprintf("0x%04X", pc),
+		}
+		if s.d.sources != nil {
+			if loc, ok := s.d.sources.Line(pc); ok {
+				f.Source = &dapSource{Name: filepath.Base(loc.File), Path: loc.File}
+				f.Line = loc.Line
+				f.Column = 1
+			}
+		}
+		frames = append(frames, f)
+		if n < len(c.stack) {
+			pc = c.stack[n]
+		}
+	}
+	return frames
+}
+
+func (s *dapSession) stackTrace(req *dapMessage) {
+	var args struct {
+		StartFrame int `json:"startFrame"`
+		Levels     int `json:"levels"`
+	}
+	json.Unmarshal(req.Arguments, &args)
+	frames := s.frames()
+	total := len(frames)
+	if args.StartFrame < 0 {
+		args.StartFrame = 0
+	}
+	if args.StartFrame > total {
+		args.StartFrame = total
+	}
+	frames = frames[args.StartFrame:]
+	if args.Levels > 0 && args.Levels < len(frames) {
+		frames = frames[:args.Levels]
+	}
+	s.respond(req, map[string]interface{}{"stackFrames": frames, "totalFrames": total})
+}
+
+func (s *dapSession) variables(req *dapMessage) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	json.Unmarshal(req.Arguments, &args)
+	c := s.d.c
+	vars := []dapVariable{}
+	switch args.VariablesReference {
+	case dapRegistersRef:
+		for n, v := range c.v {
+			vars = append(vars, dapVariable{Name: fmt.Sprintf("V%X", n), Value: fmt.Sprintf("0x%02X", v)})
+		}
+		vars = append(vars,
+			dapVariable{Name: "I", Value: fmt.Sprintf("0x%04X", c.i), MemoryReference: fmt.Sprintf("0x%04X", c.i)},
+			dapVariable{Name: "PC", Value: fmt.Sprintf("0x%04X", c.pc), MemoryReference: fmt.Sprintf("0x%04X", c.pc)},
+			dapVariable{Name: "SP", Value: fmt.Sprintf("0x%02X", c.sp)},
+			dapVariable{Name: "DT", Value: fmt.Sprintf("0x%02X", c.delay)},
+			dapVariable{Name: "ST", Value: fmt.Sprintf("0x%02X", c.sound)},
+		)
+	case dapStackRef:
+		for n := c.sp / 2; n >= 0 && n < len(c.stack); n++ {
+			vars = append(vars, dapVariable{
+				Name:            fmt.Sprintf("0x%02X", n*2),
+				Value:           fmt.Sprintf("0x%04X", c.stack[n]),
+				MemoryReference: fmt.Sprintf("0x%04X", c.stack[n]),
+			})
+		}
+	}
+	s.respond(req, map[string]interface{}{"variables": vars})
+}
+
+func (s *dapSession) setVariable(req *dapMessage) {
+	var args struct {
+		VariablesReference int    `json:"variablesReference"`
+		Name               string `json:"name"`
+		Value              string `json:"value"`
+	}
+	json.Unmarshal(req.Arguments, &args)
+	if args.VariablesReference != dapRegistersRef {
+		s.fail(req, "only registers can be set")
+		return
+	}
+	e, err := ParseExpr(args.Value)
+	if err != nil {
+		s.fail(req, "%v", err)
+		return
+	}
+	c := s.d.c
+	v := e.Eval(c, 0)
+	name := strings.ToUpper(args.Name)
+	switch name {
+	case "I":
+		c.i = uint16(v)
+	case "PC":
+		c.pc = uint16(v)
+	case "SP":
+		if v < 0 || v > 2*len(c.stack) || v%2 != 0 {
+			s.fail(req, "SP must be even and from 0 to %d", 2*len(c.stack))
+			return
+		}
+		c.sp = v
+	case "DT":
+		c.delay = byte(v)
+	case "ST":
+		c.sound = byte(v)
+	default:
+		r, err := strconv.ParseUint(strings.TrimPrefix(name, "V"), 16, 8)
+		if !strings.HasPrefix(name, "V") || err != nil || r > 0xF {
+			s.fail(req, "unknown register %q", args.Name)
+			return
+		}
+		c.v[r] = byte(v)
+		v = int(c.v[r])
+	}
+	s.respond(req, map[string]string{"value": fmt.Sprintf("0x%X", v)})
+}
+
+// evaluate evaluates a debugger expression, as used for conditions.
+func (s *dapSession) evaluate(req *dapMessage) {
+	var args struct {
+		Expression string `json:"expression"`
+	}
+	json.Unmarshal(req.Arguments, &args)
+	e, err := ParseExpr(args.Expression)
+	if err != nil {
+		s.fail(req, "%v", err)
+		return
+	}
+	v := e.Eval(s.d.c, 0)
+	s.respond(req, map[string]interface{}{
+		"result":             fmt.Sprintf("0x%X (%d)", v, v),
+		"variablesReference": 0,
+	})
+}
+
+// memRange resolves a memory reference plus offset and a count to a range of
+// memory, clamped to what's available.
+func (s *dapSession) memRange(ref string, offset, count int) (int, int, error) {
+	addr, err := s.d.parseAddr(ref)
+	if err != nil {
+		return 0, 0, err
+	}
+	size := s.d.c.MemSize()
+	start := int(addr) + offset
+	end := start + count
+	if start < 0 {
+		start = 0
+	}
+	if start > size {
+		start = size
+	}
+	if end > size {
+		end = size
+	}
+	if end < start {
+		end = start
+	}
+	return start, end, nil
+}
+
+func (s *dapSession) readMemory(req *dapMessage) {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Count           int    `json:"count"`
+	}
+	json.Unmarshal(req.Arguments, &args)
+	start, end, err := s.memRange(args.MemoryReference, args.Offset, args.Count)
+	if err != nil {
+		s.fail(req, "%v", err)
+		return
+	}
+	s.respond(req, map[string]interface{}{
+		"address":         fmt.Sprintf("0x%04X", start),
+		"data":            base64.StdEncoding.EncodeToString(s.d.c.mem[start:end]),
+		"unreadableBytes": args.Count - (end - start),
+	})
+}
+
+// disassemble returns exactly the number of instructions asked for, up to one
+// per byte of memory, padding with invalid entries outside of memory.
+// Instructions are assumed to be 2 bytes when counting back from the
+// reference.
+func (s *dapSession) disassemble(req *dapMessage) {
+	var args struct {
+		MemoryReference   string `json:"memoryReference"`
+		Offset            int    `json:"offset"`
+		InstructionOffset int    `json:"instructionOffset"`
+		InstructionCount  int    `json:"instructionCount"`
+	}
+	json.Unmarshal(req.Arguments, &args)
+	ref, err := s.d.parseAddr(args.MemoryReference)
+	if err != nil {
+		s.fail(req, "%v", err)
+		return
+	}
+	c := s.d.c
+	if args.InstructionCount <= 0 {
+		s.fail(req, "instructionCount must be positive")
+		return
+	}
+	if args.InstructionCount > c.MemSize() {
+		args.InstructionCount = c.MemSize()
+	}
+	addr := int(ref) + args.Offset + args.InstructionOffset*2
+	ins := make([]dapInstruction, 0, args.InstructionCount)
+	for len(ins) < args.InstructionCount {
+		if addr < 0 || addr+1 >= c.MemSize() {
+			ins = append(ins, dapInstruction{Address: fmt.Sprintf("0x%04X", addr&0xFFFF), Instruction: "??"})
+			addr += 2
+			continue
+		}
+		i := s.d.dis.dis(c.mem[addr:c.MemSize()])
+		end := addr + int(i.Size())
+		if end > c.MemSize() {
+			end = c.MemSize()
+		}
+		di := dapInstruction{
+			Address:          fmt.Sprintf("0x%04X", addr),
+			InstructionBytes: fmt.Sprintf("%X", c.mem[addr:end]),
+			Instruction:      i.String(),
+		}
+		di.Symbol, _ = s.d.symbols.Label(uint16(addr))
+		if s.d.sources != nil {
+			if loc, ok := s.d.sources.Line(uint16(addr)); ok {
+				di.Location = &dapSource{Name: filepath.Base(loc.File), Path: loc.File}
+				di.Line = loc.Line
+			}
+		}
+		ins = append(ins, di)
+		addr += int(i.Size())
+	}
+	s.respond(req, map[string]interface{}{"instructions": ins})
+}
@@ -0,0 +1,79 @@
+package chip8
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestDAPBadRequests checks requests that could crash or hang the server fail
+// instead.
+func TestDAPBadRequests(t *testing.T) {
+	d := NewDebugger("test.ch8", Quirks{})
+	d.SetROM([]byte{0x12, 0x00})
+	if err := d.Attach(&NullDisplay{}, &NoKeypad{}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		command string
+		args    string
+		success bool
+	}{
+		{"readMemory", `{"memoryReference": "0x200", "offset": 100000, "count": 16}`, true},
+		{"readMemory", `{"memoryReference": "0x200", "offset": -100000, "count": 100000000}`, true},
+		{"setVariable", `{"variablesReference": 1, "name": "SP", "value": "-2"}`, false},
+		{"setVariable", `{"variablesReference": 1, "name": "SP", "value": "3"}`, false},
+		{"setVariable", `{"variablesReference": 1, "name": "SP", "value": "50"}`, false},
+		{"setVariable", `{"variablesReference": 1, "name": "SP", "value": "46"}`, true},
+		{"stackTrace", `{}`, true},
+		{"setVariable", `{"variablesReference": 1, "name": "SP", "value": "48"}`, true},
+		{"stepOut", `{}`, false},
+		{"stackTrace", `{"startFrame": -1}`, true},
+		{"stackTrace", `{"startFrame": 5, "levels": -3}`, true},
+		{"disassemble", `{"memoryReference": "0x200", "instructionCount": -1}`, false},
+		{"disassemble", `{"memoryReference": "0x200", "instructionCount": 0}`, false},
+		{"disassemble", `{"memoryReference": "0x200", "instructionCount": 1000000000000}`, true},
+		{"disassemble", `{"memoryReference": "0x200", "instructionOffset": -4, "instructionCount": 8}`, true},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		s := &dapSession{d: d, w: bufio.NewWriter(&out), depth: -1}
+		s.handle(&dapMessage{Seq: 1, Type: "request", Command: tt.command, Arguments: json.RawMessage(tt.args)})
+		body := out.String()
+		var resp dapResponse
+		if err := json.Unmarshal([]byte(body[strings.Index(body, "{"):]), &resp); err != nil {
+			t.Fatalf("%s %s: %v in %q", tt.command, tt.args, err, body)
+		}
+		if resp.Success != tt.success {
+			t.Errorf("%s %s: success is %v, want %v (%s)", tt.command, tt.args, resp.Success, tt.success, resp.Message)
+		}
+	}
+}
+
+// TestDAPDisassembleCount checks a huge instructionCount is capped rather than
+// allocated.
+func TestDAPDisassembleCount(t *testing.T) {
+	d := NewDebugger("test.ch8", Quirks{})
+	d.SetROM([]byte{0x12, 0x00})
+	if err := d.Attach(&NullDisplay{}, &NoKeypad{}); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	s := &dapSession{d: d, w: bufio.NewWriter(&out), depth: -1}
+	s.handle(&dapMessage{Seq: 1, Type: "request", Command: "disassemble",
+		Arguments: json.RawMessage(`{"memoryReference": "0x200", "instructionCount": 1000000000000}`)})
+	body := out.String()
+	var resp struct {
+		Body struct {
+			Instructions []dapInstruction `json:"instructions"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal([]byte(body[strings.Index(body, "{"):]), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(resp.Body.Instructions); n != d.c.MemSize() {
+		t.Errorf("got %d instructions, want %d", n, d.c.MemSize())
+	}
+}
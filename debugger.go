@@ -3,11 +3,11 @@ package chip8
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"strconv"
 	"strings"
-	"time"
+	"sync/atomic"
 
 	"github.com/fatih/color"
 	"github.com/jroimartin/gocui"
@@ -20,34 +20,41 @@ var green = color.New(color.FgGreen).SprintFunc()
 var cyan = color.New(color.FgCyan).SprintFunc()
 var white = color.New(color.FgWhite, color.Bold).SprintFunc()
 
-// TODO: Make part of debugger
-func parseAddr(s string) (uint16, error) {
-	addr, err := strconv.ParseUint(s, 0, 16)
-	if err != nil {
-		return 0, fmt.Errorf("couldn't parse address from %s", s)
-	}
-	if int(addr) >= 0x1000 {
-		return 0, fmt.Errorf("addr out of range")
-	}
-	return uint16(addr), nil
-}
-
-type Breakpoint struct {
-	enabled  bool
-	timesHit int
-}
-
+// Debugger is a command driven front end to a Session. Start shows it with
+// gocui, but commands can be run with Handle without any UI.
 type Debugger struct {
-	c       *Chip8
-	rom     string
-	bps     map[uint16]Breakpoint
-	tbps    map[uint16]Breakpoint
-	dis     Disassembler
-	ui      *ui
-	last    string
-	stop    bool
-	stopped bool
-	first   bool
+	*Session
+	ui *ui
+	// out is where command output goes
+	out  io.Writer
+	last string
+	// macros are the commands made with define
+	macros map[string][]string
+	// bpCommands run when the breakpoint at their address is hit
+	bpCommands map[uint16][]string
+	// block is the define or commands block being entered
+	block *block
+	// queued are breakpoint commands waiting to run
+	queued   []string
+	draining bool
+	// depth is how deeply scripts and macros are nested
+	depth int
+	// frame is the frame selected with the frame command
+	frame int
+	// tracer is the trace started with the trace command
+	tracer *Tracer
+	// profiler is the profile started with the profile command
+	profiler *Profiler
+	// coverage is recorded from the coverage command
+	coverage *Coverage
+	// resumed is set from when the session starts running until it stops
+	resumed bool
+	// busy is set from when a command starts running the machine until it
+	// stops, so only one runs it at a time
+	busy int32
+	// post runs a function on the UI's goroutine. When it is set, the
+	// machine runs in a goroutine of its own so the UI stays responsive.
+	post func(func())
 }
 
 type ui struct {
@@ -56,16 +63,24 @@ type ui struct {
 	debugView   *gocui.View
 	promptView  *gocui.View
 	stackView   *gocui.View
+	// screen is sized from the emulator's display once it exists
+	screen func() IterableImage
 }
 
-func NewDebugger(rom string) *Debugger {
-	return &Debugger{
-		c:    nil,
-		rom:  rom,
-		bps:  make(map[uint16]Breakpoint),
-		tbps: make(map[uint16]Breakpoint),
-		dis:  Disassembler{},
+func NewDebugger(rom string, q Quirks, opts ...Option) *Debugger {
+	d := &Debugger{
+		Session:    NewSession(rom, q, opts...),
+		out:        os.Stdout,
+		macros:     make(map[string][]string),
+		bpCommands: make(map[uint16][]string),
 	}
+	d.Listen(d.onEvent)
+	return d
+}
+
+// SetOutput sets where command output goes when there is no UI.
+func (d *Debugger) SetOutput(w io.Writer) {
+	d.out = w
 }
 
 // The promptEditor adds readline keys and assumes one line
@@ -116,13 +131,17 @@ func (e promptEditor) Edit(v *gocui.View, key gocui.Key, ch rune, mod gocui.Modi
 func (ui *ui) layout(g *gocui.Gui) error {
 	maxX, maxY := g.Size()
 	var err error
-	if maxY < SCREEN_HEIGHT || maxX < SCREEN_WIDTH {
+	width := SCREEN_WIDTH
+	if ui.screen != nil {
+		width, _ = CellSize(ui.screen())
+	}
+	if maxY < SCREEN_HEIGHT || maxX < width {
 		return fmt.Errorf("Cannot display if less than %d x %d! Resize your terminal! (^Q to quit)",
-			SCREEN_WIDTH, SCREEN_HEIGHT)
+			width, SCREEN_HEIGHT)
 	}
 	// TODO: Choose vertical or horizontal layout if only one would work
-	left := (maxX - SCREEN_WIDTH) / 2
-	ui.displayView, err = g.SetView("display", left, 0, SCREEN_WIDTH+2+left, SCREEN_HEIGHT+2)
+	left := (maxX - width) / 2
+	ui.displayView, err = g.SetView("display", left, 0, width+2+left, SCREEN_HEIGHT+2)
 	if err != nil && err != gocui.ErrUnknownView {
 		return err
 	}
@@ -177,49 +196,97 @@ func (d *Debugger) quit(g *gocui.Gui, v *gocui.View) error {
 }
 
 func (d *Debugger) halt(g *gocui.Gui, v *gocui.View) error {
-	if d.stopped {
+	if !d.Running() {
 		g.Update(func(g *gocui.Gui) error {
 			d.Printf("Already stopped. Press Ctrl-Q or q to quit\n")
 			return nil
 		})
 		return nil
 	}
-	g.Update(func(g *gocui.Gui) error {
-		d.Println("Received halt")
-		return nil
-	})
-	d.stop = true
+	d.Break()
 	d.ui.SetCurrentView(d.ui.promptView.Name())
 	d.ui.Cursor = true
 	return nil
 }
 
-func (d *Debugger) cont() {
-	d.stop = false
-	d.stopped = false
-	d.first = true // So we can continue through a breakpoint
-	d.ui.Cursor = false
-	d.ui.SetCurrentView(d.ui.displayView.Name())
+// resume runs f, which runs the machine until it stops, in the background
+// if there is a UI to keep responsive.
+func (d *Debugger) resume(f func() Stop) {
+	if !atomic.CompareAndSwapInt32(&d.busy, 0, 1) {
+		d.Println("Already running. Press Ctrl-C to stop")
+		return
+	}
+	if d.post != nil {
+		// onEvent clears busy when f stops
+		go f()
+		return
+	}
+	f()
+}
+
+// idle reports whether no command is running the machine, saying so if one
+// is, so other commands don't race with it.
+func (d *Debugger) idle() bool {
+	if atomic.LoadInt32(&d.busy) != 0 {
+		d.Println("Already running. Press Ctrl-C to stop")
+		return false
+	}
+	return true
 }
 
-func (d *Debugger) StepOne() {
-	err := d.c.RunOne()
-	if d.c.RenderFlag {
-		d.c.Render()
+// onEvent shows what the session is doing, from the UI's goroutine if there
+// is one.
+func (d *Debugger) onEvent(e Event) {
+	if e.Kind == EventStopped {
+		// Stopping is the last thing a run does, so breakpoint commands
+		// can run the machine again
+		atomic.StoreInt32(&d.busy, 0)
 	}
-	d.stopped = false
-	if err != nil {
-		d.Println(err)
+	if d.post == nil {
+		d.showEvent(e)
+		return
+	}
+	d.post(func() { d.showEvent(e) })
+}
+
+func (d *Debugger) showEvent(e Event) {
+	switch e.Kind {
+	case EventContinued:
+		d.resumed = true
+		if d.ui != nil {
+			d.ui.Cursor = false
+			d.ui.SetCurrentView(d.ui.displayView.Name())
+		}
+	case EventStopped:
+		d.frame = 0
+		if e.Stop.Reason != StopStep {
+			d.Println(red(e.Stop.String()))
+		}
+		if d.resumed {
+			d.Printf("Ran %d cycles\n", e.Stop.Cycles)
+			d.resumed = false
+		}
+		d.printState()
+		if d.ui != nil {
+			d.cleanPrompt()
+		}
+		if e.Stop.Reason == StopBreakpoint {
+			d.queued = append(d.queued, d.bpCommands[e.Stop.PC]...)
+			if d.post != nil {
+				d.runQueued()
+			}
+		}
+	case EventChanged:
+		d.printState()
 	}
-	d.stop = true
 }
 
 func (d *Debugger) Println(a ...interface{}) {
-	fmt.Fprintln(d.ui.debugView, a...)
+	fmt.Fprintln(d.out, a...)
 }
 
 func (d *Debugger) Printf(format string, a ...interface{}) {
-	fmt.Fprintf(d.ui.debugView, format, a...)
+	fmt.Fprintf(d.out, format, a...)
 }
 
 func (d *Debugger) printContext() error {
@@ -238,6 +305,20 @@ func (d *Debugger) cleanPrompt() {
 	v.SetCursor(0, 0)
 }
 
+// uiRenderer draws from the UI's goroutine, since the session runs in the
+// background.
+type uiRenderer struct {
+	g *gocui.Gui
+	r Renderer
+}
+
+func (u *uiRenderer) Render(i IterableImage) {
+	u.g.Update(func(g *gocui.Gui) error {
+		u.r.Render(i)
+		return nil
+	})
+}
+
 func (d *Debugger) Start() {
 	g, err := gocui.NewGui(gocui.OutputNormal)
 	if err != nil {
@@ -245,21 +326,27 @@ func (d *Debugger) Start() {
 	}
 	defer g.Close()
 
-	d.ui = &ui{g, nil, nil, nil, nil}
+	d.ui = &ui{Gui: g}
+	d.post = func(f func()) {
+		g.Update(func(g *gocui.Gui) error {
+			f()
+			return nil
+		})
+	}
 	g.SetManagerFunc(d.ui.layout)
 	// HACK: layout needs to have been called to grab handles to views
 	d.ui.layout(g)
 	g.SetCurrentView("display")
 
 	k := NewGocuiKeypad(g, d.ui.displayView)
-	r := NewGocuiRenderer(d.ui.displayView)
-	d.c = NewChip8(r, k)
-	d.c.Reset()
-
-	if err := d.c.LoadBinary(d.rom); err != nil {
-		fmt.Printf("Error loading %s: %v\n", os.Args[1], err)
+	r := &uiRenderer{g, NewGocuiRenderer(d.ui.displayView)}
+	if err := d.Attach(r, k); err != nil {
+		g.Close()
+		fmt.Printf("Error loading %s: %v\n", d.rom, err)
 		os.Exit(1)
 	}
+	d.ui.screen = d.c.Screen
+	d.out = d.ui.debugView
 
 	if err := g.SetKeybinding("", gocui.KeyCtrlQ, gocui.ModNone, d.quit); err != nil {
 		log.Panicln(err)
@@ -278,69 +365,22 @@ func (d *Debugger) Start() {
 		log.Panicln(err)
 	}
 
-	go d.run()
+	d.printContext()
+	d.ui.Update(func(g *gocui.Gui) error {
+		d.cleanPrompt()
+		d.sourceInitFiles()
+		return nil
+	})
 
 	if err := g.MainLoop(); err != nil && err != gocui.ErrQuit {
 		log.Panicln(err)
 	}
 }
 
-func (d *Debugger) run() {
-	go d.c.KeepTime()
-	var tick = time.Tick(2 * time.Millisecond)
-	d.printContext()
-
-	d.stop = true
-	d.first = true // To allow us to run while on a bp
-LOOP:
-	for {
-		var err error
-		select {
-		case <-tick:
-			if d.stopped {
-				continue
-			}
-			if v, ok := d.bps[d.c.pc]; ok && v.enabled && !d.first {
-				d.ui.Update(func(g *gocui.Gui) error {
-					d.Printf(red("Hit breakpoint at 0x%04X\n"), d.c.pc)
-					d.bps[d.c.pc] = Breakpoint{true, v.timesHit + 1}
-					return nil
-				})
-				d.stop = true
-			}
-			if v, ok := d.tbps[d.c.pc]; ok && v.enabled && !d.first {
-				d.ui.Update(func(g *gocui.Gui) error {
-					d.Printf(red("Hit temp breakpoint at 0x%04X\n"), d.c.pc)
-					d.tbps[d.c.pc] = Breakpoint{true, v.timesHit + 1}
-					return nil
-				})
-				removeBreakpoint(d.tbps, d.c.pc)
-				d.stop = true
-			}
-			d.first = false
-			if !d.stop {
-				err = d.c.RunOne()
-				if d.c.RenderFlag {
-					d.ui.Update(func(g *gocui.Gui) error {
-						d.c.Render()
-						return nil
-					})
-				}
-			}
-			if err != nil {
-				fmt.Fprintln(os.Stderr, err)
-				break LOOP
-			}
-			if d.stop {
-				d.stopped = true
-				d.printContext()
-				d.cleanPrompt()
-			}
-		}
-	}
-}
-
 func (d *Debugger) printStack() {
+	if d.ui == nil {
+		return
+	}
 	d.ui.stackView.Clear()
 	for i := 0; i < len(d.c.stack); i++ {
 		if i < d.c.sp/2 {
@@ -355,6 +395,31 @@ func (d *Debugger) printStack() {
 	}
 }
 
+// printLabel prints a header for the label at addr, if it has one.
+func (d *Debugger) printLabel(addr uint16, indent string) {
+	if label, ok := d.symbols.Label(addr); ok {
+		d.Printf(indent+yellow("%s:")+"\n", label)
+	}
+}
+
+// printListing prints the instructions around addr, highlighting it.
+func (d *Debugger) printListing(addr uint16) {
+	start := addr - 4
+	if addr < 4 {
+		start = 0
+	}
+	for a := start; a < addr+8 && int(a)+1 < d.c.MemSize(); {
+		ins := d.dis.dis(d.c.code(a))
+		d.printLabel(a, "")
+		format := "0x%04X" + green(" %04X ") + cyan("%s\n")
+		if a == addr {
+			format = white("0x%04X") + green(" %04X ") + blue("%s\n")
+		}
+		d.Printf(format, a, binary.BigEndian.Uint16(d.c.code(a)), ins)
+		a += ins.Size()
+	}
+}
+
 func (d *Debugger) printState() {
 	d.printStack()
 	d.Println(green("-- ") + yellow("Registers") + green(" --"))
@@ -371,79 +436,138 @@ func (d *Debugger) printState() {
 	for i := uint16(4); i > 0; i -= 2 {
 		addr := d.c.pc - i
 		if addr < d.c.pc {
+			d.printLabel(addr, "")
 			d.Printf("0x%04X %04X %s\n",
 				addr,
-				binary.BigEndian.Uint16(d.c.mem[addr:]),
-				d.dis.dis(d.c.mem[addr:]))
+				binary.BigEndian.Uint16(d.c.code(addr)),
+				d.dis.dis(d.c.code(addr)))
 		}
 	}
 	// Print current instruction
-	ins := d.dis.dis(d.c.mem[d.c.pc:])
+	ins := d.dis.dis(d.c.code(d.c.pc))
+	d.printLabel(d.c.pc, "")
 	d.Printf(white("0x%04X")+green(" %04X ")+blue("%s\n"),
 		d.c.pc,
-		binary.BigEndian.Uint16(d.c.mem[d.c.pc:]),
+		binary.BigEndian.Uint16(d.c.code(d.c.pc)),
 		ins)
 	// If we're on a call, peek at its dest
-	i := uint16(2)
-	if ins.isCall() {
+	i := ins.Size()
+	if ins.IsCall() {
 		addr := ins.callTarget()
+		d.printLabel(addr+2, "   ")
 		d.Printf("⤷  0x%04X"+green(" %04X ")+cyan("%s\n"),
-			addr+i,
-			binary.BigEndian.Uint16(d.c.mem[addr+i:]),
-			d.dis.dis(d.c.mem[addr+i:]))
-		i += 2
-		for ; i < 8 && int(addr+i) < len(d.c.mem); i += 2 {
+			addr+2,
+			binary.BigEndian.Uint16(d.c.code(addr+2)),
+			d.dis.dis(d.c.code(addr+2)))
+		for j := uint16(4); j < 8 && int(addr+j) < d.c.MemSize(); i, j = i+2, j+2 {
 			d.Printf("   0x%04X"+green(" %04X ")+cyan("%s\n"),
-				addr+i,
-				binary.BigEndian.Uint16(d.c.mem[addr+i:]),
-				d.dis.dis(d.c.mem[addr+i:]))
+				addr+j,
+				binary.BigEndian.Uint16(d.c.code(addr+j)),
+				d.dis.dis(d.c.code(addr+j)))
 		}
 	}
 	// Print a few instructions forward
-	for ; i < 16 && int(d.c.pc+i) < len(d.c.mem); i += 2 {
-		addr := d.c.pc + i
+	for addr := d.c.pc + ins.Size(); i < 16 && int(addr) < d.c.MemSize(); {
+		next := d.dis.dis(d.c.code(addr))
+		d.printLabel(addr, "")
 		d.Printf("0x%04X"+green(" %04X ")+cyan("%s\n"),
 			addr,
-			binary.BigEndian.Uint16(d.c.mem[addr:]),
-			d.dis.dis(d.c.mem[addr:]))
+			binary.BigEndian.Uint16(d.c.code(addr)),
+			next)
+		i += next.Size()
+		addr += next.Size()
 	}
 
 }
 
 var commands = map[string]func(*Debugger, []string){
-	"reset": reset,
-	"ctx":   context,
-	"ib":    breakpoints,
-	"b":     addBreak,
-	"tb":    addTBreak,
-	"db":    disableBreak,
-	"dtb":   disableTBreak,
-	"eb":    enableBreak,
-	"etb":   enableTBreak,
-	"rb":    removeBreak,
-	"rtb":   removeTBreak,
-	"c":     cont,
-	"s":     step,
-	"si":    step,
-	"n":     next,
-	"ni":    next,
-	"x":     examine,
-	"e":     edit,
-	"q":     quit,
+	"reset":    reset,
+	"ctx":      context,
+	"ib":       breakpoints,
+	"b":        addBreak,
+	"tb":       addTBreak,
+	"db":       disableBreak,
+	"dtb":      disableTBreak,
+	"eb":       enableBreak,
+	"etb":      enableTBreak,
+	"rb":       removeBreak,
+	"rtb":      removeTBreak,
+	"cond":     condBreak,
+	"ignore":   ignoreBreak,
+	"watch":    addWatch,
+	"rwatch":   addReadWatch,
+	"awatch":   addAccessWatch,
+	"iw":       watchpoints,
+	"dw":       disableWatch,
+	"ew":       enableWatch,
+	"rw":       removeWatch,
+	"c":        cont,
+	"s":        step,
+	"si":       step,
+	"n":        next,
+	"ni":       next,
+	"finish":   finish,
+	"until":    until,
+	"advance":  advance,
+	"x":        examine,
+	"e":        edit,
+	"save":     save,
+	"load":     load,
+	"rewind":   rewind,
+	"rs":       reverseStep,
+	"rsi":      reverseStep,
+	"rc":       reverseCont,
+	"sym":      syms,
+	"bt":       backtrace,
+	"frame":    frame,
+	"trace":    trace,
+	"profile":  profile,
+	"coverage": coverage,
+	"dis":      disassemble,
+	"q":        quit,
 }
 
+// whileRunning are the commands that don't touch the session, so can be run
+// while the machine runs in the background. The commands a script or macro
+// runs are checked as they run.
+var whileRunning = map[string]bool{
+	"define": true,
+	"source": true,
+	"q":      true,
+}
+
+// Handle runs a command line. Lines inside a define or commands block are
+// collected until its "end".
 func (d *Debugger) Handle(line string) error {
+	if d.block != nil {
+		d.collect(line)
+		return nil
+	}
 	if line == "" && d.last != "" {
 		line = d.last
 	}
-	ops := strings.Split(line, " ")
+	ops := strings.Fields(line)
+	if len(ops) == 0 {
+		return nil
+	}
 	cmd := ops[0]
 	ops = ops[1:]
 	if f, ok := commands[cmd]; ok {
+		if !whileRunning[cmd] && !d.idle() {
+			return nil
+		}
 		f(d, ops)
 		d.last = line
+	} else if body, ok := d.macros[cmd]; ok {
+		d.runMacro(body, ops)
+		d.last = line
 	} else {
 		d.Printf("illegal command: '%s'\n", cmd)
 	}
+	if d.block != nil {
+		// Don't start another block on Enter
+		d.last = ""
+	}
+	d.runQueued()
 	return nil
 }
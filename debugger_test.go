@@ -0,0 +1,96 @@
+package chip8
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer that the running machine's events and the
+// commands can both write to.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+// newTestDebugger returns a debugger for rom without a UI, writing to out.
+func newTestDebugger(t *testing.T, rom []byte, out *syncBuffer) *Debugger {
+	d := NewDebugger("test.ch8", Quirks{})
+	d.SetOutput(out)
+	d.SetROM(rom)
+	if err := d.Attach(&NullDisplay{}, &NoKeypad{}); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// TestCommandsWhileRunning changes breakpoints while the machine runs in the
+// background, as it does with the UI. Run with -race.
+func TestCommandsWhileRunning(t *testing.T) {
+	var out syncBuffer
+	d := newTestDebugger(t, testROM, &out)
+	// Stand in for the UI's goroutine, which events and commands run on
+	ui := make(chan func(), 16)
+	defer close(ui)
+	go func() {
+		for f := range ui {
+			f()
+		}
+	}()
+	d.post = func(f func()) { ui <- f }
+	handle := func(cmd string) {
+		done := make(chan bool)
+		ui <- func() {
+			d.Handle(cmd)
+			close(done)
+		}
+		<-done
+	}
+	continued, stopped := make(chan bool, 1), make(chan Stop, 1)
+	d.Listen(func(e Event) {
+		switch e.Kind {
+		case EventContinued:
+			continued <- true
+		case EventStopped:
+			stopped <- e.Stop
+		}
+	})
+
+	handle("c")
+	<-continued
+	for _, cmd := range []string{"b 0x206", "tb 0x206", "watch 0x300", "e 0x300 1", "c"} {
+		handle(cmd)
+	}
+	if n := strings.Count(out.String(), "Already running"); n != 5 {
+		t.Errorf("%d commands were refused while running, want 5:\n%s", n, out.String())
+	}
+	handle("define nothing")
+	handle("end")
+	// Let the machine run on to the breakpoint it mustn't have
+	time.Sleep(20 * time.Millisecond)
+
+	d.Break()
+	if stop := <-stopped; stop.Reason != StopHalt {
+		t.Fatalf("stopped with %v, want a halt", stop)
+	}
+	handle("b 0x206")
+	handle("c")
+	<-continued
+	if stop := <-stopped; stop.Reason != StopBreakpoint || stop.PC != 0x206 {
+		t.Errorf("stopped with %v, want the breakpoint at 0x206", stop)
+	}
+}
@@ -1,196 +1,411 @@
 package chip8
 
 import (
+	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
 func reset(d *Debugger, ops []string) {
 	d.Println("Reseting CPU")
-	d.c.Reset()
-	if err := d.c.LoadBinary(d.rom); err != nil {
-		panic("Cannot reload rom")
+	if err := d.Reset(); err != nil {
+		d.Println(err)
 	}
-	d.stopped = false // Redraws context
 }
 
 func context(d *Debugger, ops []string) {
-	// Setting stop makes the debugger show the context
-	d.stop = true
-	d.stopped = false // Redraws context
+	d.printState()
 }
 
-func (d *Debugger) printBreakpoints(breaks map[uint16]Breakpoint) {
-	// TODO: Sort in any way?
-	for a, v := range breaks {
-		if v.enabled {
-			d.Printf(green("+ 0x%04X ")+"(hit %d times)\n", a, v.timesHit)
+func (d *Debugger) printBreakpoints(breaks []Breakpoint, cmds map[uint16][]string) {
+	for _, v := range breaks {
+		extra := ""
+		if v.Cond != nil {
+			extra += " if " + v.Cond.String()
+		}
+		if v.Ignore > 0 {
+			extra += fmt.Sprintf(" (ignore next %d)", v.Ignore)
+		}
+		if v.Enabled {
+			d.Printf(green("+ 0x%04X ")+"(hit %d times)%s\n", v.Addr, v.TimesHit, extra)
 		} else {
-			d.Printf("- 0x%04X (hit %d times)\n", a, v.timesHit)
+			d.Printf("- 0x%04X (hit %d times)%s\n", v.Addr, v.TimesHit, extra)
+		}
+		for _, line := range cmds[v.Addr] {
+			d.Println("    " + line)
 		}
 	}
 }
 
 func breakpoints(d *Debugger, ops []string) {
-	if (len(d.bps) == 0) && (len(d.tbps) == 0) {
+	bps, tbps := d.Breakpoints(false), d.Breakpoints(true)
+	if (len(bps) == 0) && (len(tbps) == 0) {
 		d.Println(white("No breakpoints"))
 		return
 	}
-	if len(d.bps) > 0 {
+	if len(bps) > 0 {
 		d.Println(white("Breakpoints"))
-		d.printBreakpoints(d.bps)
+		d.printBreakpoints(bps, d.bpCommands)
 	}
-	if len(d.tbps) > 0 {
+	if len(tbps) > 0 {
 		d.Println(white("Temp Breakpoints"))
-		d.printBreakpoints(d.tbps)
+		d.printBreakpoints(tbps, nil)
 	}
 }
 
-func addBreak(d *Debugger, ops []string) {
-	if len(ops) != 1 {
-		d.Println("Usage: b <addr>")
+// parseCond parses the optional `if EXPR` following a breakpoint address.
+func parseCond(ops []string) (*Expr, error) {
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	if ops[0] != "if" || len(ops) == 1 {
+		return nil, fmt.Errorf("expected 'if <expr>'")
+	}
+	return ParseExpr(strings.Join(ops[1:], " "))
+}
+
+func (d *Debugger) addBreak(usage string, temp bool, ops []string) {
+	if len(ops) < 1 {
+		d.Println(usage)
 		return
 	}
-	addr, err := parseAddr(ops[0])
+	addr, err := d.parseAddr(ops[0])
 	if err != nil {
 		d.Println(err)
 		return
 	}
-	addBreakpoint(d.bps, addr)
+	cond, err := parseCond(ops[1:])
+	if err != nil {
+		d.Println(err)
+		return
+	}
+	d.AddBreakpoint(addr, cond, temp)
 	d.Printf("Added bp at 0x%04X\n", addr)
 }
 
+func addBreak(d *Debugger, ops []string) {
+	d.addBreak("Usage: b <addr> [if <expr>]", false, ops)
+}
+
 func addTBreak(d *Debugger, ops []string) {
-	if len(ops) != 1 {
-		d.Println("Usage: tb <addr>")
+	d.addBreak("Usage: tb <addr> [if <expr>]", true, ops)
+}
+
+func condBreak(d *Debugger, ops []string) {
+	if len(ops) < 1 {
+		d.Println("Usage: cond <addr> [<expr>]")
 		return
 	}
-	addr, err := parseAddr(ops[0])
+	addr, err := d.parseAddr(ops[0])
 	if err != nil {
 		d.Println(err)
 		return
 	}
-	addBreakpoint(d.tbps, addr)
-	d.Printf("Added bp at 0x%04X\n", addr)
-}
-
-func addBreakpoint(breaks map[uint16]Breakpoint, addr uint16) {
-	breaks[addr] = Breakpoint{true, 0}
+	var cond *Expr
+	if len(ops) > 1 {
+		if cond, err = ParseExpr(strings.Join(ops[1:], " ")); err != nil {
+			d.Println(err)
+			return
+		}
+	}
+	if !d.SetBreakpointCondition(addr, cond) {
+		d.Printf("No bp at 0x%04X\n", addr)
+		return
+	}
+	if cond == nil {
+		d.Printf("bp at 0x%04X is now unconditional\n", addr)
+	} else {
+		d.Printf("bp at 0x%04X now stops if %s\n", addr, cond)
+	}
 }
 
-func disableBreak(d *Debugger, ops []string) {
-	if len(ops) != 1 {
-		d.Println("Usage: db <addr>")
+func ignoreBreak(d *Debugger, ops []string) {
+	if len(ops) != 2 {
+		d.Println("Usage: ignore <addr> <count>")
 		return
 	}
-	addr, err := parseAddr(ops[0])
+	addr, err := d.parseAddr(ops[0])
 	if err != nil {
 		d.Println(err)
 		return
 	}
-	disableBreakpoint(d.bps, addr)
-	d.Printf("Disabled bp at 0x%04X\n", addr)
+	count, err := strconv.Atoi(ops[1])
+	if err != nil || count < 0 {
+		d.Println("Usage: ignore <addr> <count>")
+		return
+	}
+	if !d.SetBreakpointIgnore(addr, count) {
+		d.Printf("No bp at 0x%04X\n", addr)
+		return
+	}
+	d.Printf("Will ignore next %d hits of bp at 0x%04X\n", count, addr)
 }
 
-func disableTBreak(d *Debugger, ops []string) {
+// changeBreak parses the address for a command that changes a breakpoint
+// and reports what f did to it.
+func (d *Debugger) changeBreak(usage, done string, ops []string, f func(uint16) bool) {
 	if len(ops) != 1 {
-		d.Println("Usage: dtb <addr>")
+		d.Println(usage)
 		return
 	}
-	addr, err := parseAddr(ops[0])
+	addr, err := d.parseAddr(ops[0])
 	if err != nil {
 		d.Println(err)
 		return
 	}
-	disableBreakpoint(d.tbps, addr)
-	d.Printf("Disabled bp at 0x%04X\n", addr)
+	if !f(addr) {
+		d.Printf("No bp at 0x%04X\n", addr)
+		return
+	}
+	d.Printf("%s bp at 0x%04X\n", done, addr)
 }
 
-func disableBreakpoint(breaks map[uint16]Breakpoint, addr uint16) {
-	breaks[addr] = Breakpoint{false, breaks[addr].timesHit}
+func disableBreak(d *Debugger, ops []string) {
+	d.changeBreak("Usage: db <addr>", "Disabled", ops, func(addr uint16) bool {
+		return d.EnableBreakpoint(addr, false, false)
+	})
+}
+
+func disableTBreak(d *Debugger, ops []string) {
+	d.changeBreak("Usage: dtb <addr>", "Disabled", ops, func(addr uint16) bool {
+		return d.EnableBreakpoint(addr, true, false)
+	})
 }
 
 func enableBreak(d *Debugger, ops []string) {
-	if len(ops) != 1 {
-		d.Println("Usage: db <addr>")
+	d.changeBreak("Usage: eb <addr>", "Enabled", ops, func(addr uint16) bool {
+		return d.EnableBreakpoint(addr, false, true)
+	})
+}
+
+func enableTBreak(d *Debugger, ops []string) {
+	d.changeBreak("Usage: etb <addr>", "Enabled", ops, func(addr uint16) bool {
+		return d.EnableBreakpoint(addr, true, true)
+	})
+}
+
+func removeBreak(d *Debugger, ops []string) {
+	d.changeBreak("Usage: rb <addr>", "Removed", ops, func(addr uint16) bool {
+		delete(d.bpCommands, addr)
+		return d.RemoveBreakpoint(addr, false)
+	})
+}
+
+func removeTBreak(d *Debugger, ops []string) {
+	d.changeBreak("Usage: rtb <addr>", "Removed", ops, func(addr uint16) bool {
+		return d.RemoveBreakpoint(addr, true)
+	})
+}
+
+// parseRange parses ADDR [LEN] into [start, end).
+func (d *Debugger) parseRange(ops []string) (int, int, error) {
+	addr, err := d.parseAddr(ops[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	length := 1
+	if len(ops) > 1 {
+		l, err := strconv.ParseUint(ops[1], 0, 16)
+		if err != nil || l == 0 {
+			return 0, 0, fmt.Errorf("couldn't parse length from %s", ops[1])
+		}
+		length = int(l)
+	}
+	end := int(addr) + length
+	if end > d.c.MemSize() {
+		end = d.c.MemSize()
+	}
+	return int(addr), end, nil
+}
+
+func (d *Debugger) addWatchpoint(usage string, kind MemAccessKind, ops []string) {
+	if len(ops) < 1 || len(ops) > 2 {
+		d.Println(usage)
 		return
 	}
-	addr, err := parseAddr(ops[0])
+	start, end, err := d.parseRange(ops)
 	if err != nil {
 		d.Println(err)
 		return
 	}
-	enableBreakpoint(d.bps, addr)
-	d.Printf("Enabled bp at 0x%04X\n", addr)
+	id := d.AddWatchpoint(start, end, kind)
+	d.Printf("Added watchpoint %d on 0x%04X-0x%04X\n", id, start, end-1)
 }
 
-func enableTBreak(d *Debugger, ops []string) {
+func addWatch(d *Debugger, ops []string) {
+	d.addWatchpoint("Usage: watch <addr> [len]", MemWrite, ops)
+}
+
+func addReadWatch(d *Debugger, ops []string) {
+	d.addWatchpoint("Usage: rwatch <addr> [len]", MemRead|MemFetch, ops)
+}
+
+func addAccessWatch(d *Debugger, ops []string) {
+	d.addWatchpoint("Usage: awatch <addr> [len]", MemRead|MemWrite|MemFetch, ops)
+}
+
+func watchpoints(d *Debugger, ops []string) {
+	wps := d.Watchpoints()
+	if len(wps) == 0 {
+		d.Println(white("No watchpoints"))
+		return
+	}
+	d.Println(white("Watchpoints"))
+	for _, w := range wps {
+		kind := "access"
+		switch w.Kind {
+		case MemWrite:
+			kind = "write"
+		case MemRead | MemFetch:
+			kind = "read"
+		}
+		if w.Enabled {
+			d.Printf(green("+ %d 0x%04X-0x%04X %s ")+"(hit %d times)\n", w.ID, w.Start, w.End-1, kind, w.TimesHit)
+		} else {
+			d.Printf("- %d 0x%04X-0x%04X %s (hit %d times)\n", w.ID, w.Start, w.End-1, kind, w.TimesHit)
+		}
+	}
+}
+
+// changeWatch parses the number for a command that changes a watchpoint and
+// reports what f did to it.
+func (d *Debugger) changeWatch(usage, done string, ops []string, f func(int) bool) {
 	if len(ops) != 1 {
-		d.Println("Usage: tdb <addr>")
+		d.Println(usage)
 		return
 	}
-	addr, err := parseAddr(ops[0])
+	id, err := strconv.Atoi(ops[0])
 	if err != nil {
-		d.Println(err)
+		d.Println(usage)
+		return
+	}
+	if !f(id) {
+		d.Printf("No watchpoint %d\n", id)
 		return
 	}
-	enableBreakpoint(d.tbps, addr)
-	d.Printf("Enabled bp at 0x%04X\n", addr)
+	d.Printf("%s watchpoint %d\n", done, id)
 }
 
-func enableBreakpoint(breaks map[uint16]Breakpoint, addr uint16) {
-	breaks[addr] = Breakpoint{true, breaks[addr].timesHit}
+func disableWatch(d *Debugger, ops []string) {
+	d.changeWatch("Usage: dw <num>", "Disabled", ops, func(id int) bool {
+		return d.EnableWatchpoint(id, false)
+	})
 }
 
-func removeBreak(d *Debugger, ops []string) {
+func enableWatch(d *Debugger, ops []string) {
+	d.changeWatch("Usage: ew <num>", "Enabled", ops, func(id int) bool {
+		return d.EnableWatchpoint(id, true)
+	})
+}
+
+func removeWatch(d *Debugger, ops []string) {
+	d.changeWatch("Usage: rw <num>", "Removed", ops, d.RemoveWatchpoint)
+}
+
+func cont(d *Debugger, ops []string) {
+	d.resume(d.Continue)
+}
+
+func step(d *Debugger, ops []string) {
+	d.Step()
+}
+
+func next(d *Debugger, ops []string) {
+	d.resume(d.Next)
+}
+
+func finish(d *Debugger, ops []string) {
+	if d.Depth() == 0 {
+		d.Println("Not in a subroutine")
+		return
+	}
+	d.resume(d.Finish)
+}
+
+func until(d *Debugger, ops []string) {
 	if len(ops) != 1 {
-		d.Println("Usage: rb <addr>")
+		d.Println("Usage: until <addr>")
 		return
 	}
-	addr, err := parseAddr(ops[0])
+	addr, err := d.parseAddr(ops[0])
 	if err != nil {
 		d.Println(err)
 		return
 	}
-	removeBreakpoint(d.bps, addr)
-	d.Printf("Removed bp at 0x%04X\n", addr)
+	d.resume(func() Stop { return d.Until(addr) })
 }
 
-func removeTBreak(d *Debugger, ops []string) {
+func advance(d *Debugger, ops []string) {
 	if len(ops) != 1 {
-		d.Println("Usage: trb <addr>")
+		d.Println("Usage: advance <count>")
 		return
 	}
-	addr, err := parseAddr(ops[0])
-	if err != nil {
-		d.Println(err)
+	n, err := strconv.Atoi(ops[0])
+	if err != nil || n < 1 {
+		d.Println("Usage: advance <count>")
 		return
 	}
-	removeBreakpoint(d.bps, addr)
-	d.Printf("Removed bp at 0x%04X\n", addr)
-}
-
-func removeBreakpoint(breaks map[uint16]Breakpoint, addr uint16) {
-	delete(breaks, addr)
+	d.resume(func() Stop { return d.Advance(n) })
 }
 
-func cont(d *Debugger, ops []string) {
-	d.cont()
+// printFrame prints a frame as "#N call-site → target (label)".
+func (d *Debugger) printFrame(n int, f Frame) {
+	marker := "  "
+	if n == d.frame {
+		marker = "→ "
+	}
+	target := fmt.Sprintf("0x%04X", f.Target)
+	if label, ok := d.symbols.Label(f.Target); ok {
+		target += " (" + label + ")"
+	}
+	if !f.Call {
+		target = red("not a call")
+	}
+	d.Printf(marker+white("#%d")+" 0x%04X → %s\n", n, f.CallSite, target)
 }
 
-func step(d *Debugger, ops []string) {
-	d.StepOne()
+func backtrace(d *Debugger, ops []string) {
+	regs := d.Registers()
+	if regs.SP < 0 || regs.SP > len(regs.Stack)*2 || regs.SP%2 != 0 {
+		d.Printf(red("Stack pointer 0x%02X is out of range\n"), regs.SP)
+		return
+	}
+	frames := d.Backtrace()
+	if len(frames) == 0 {
+		d.Println(white("Not in a subroutine"))
+		return
+	}
+	for n, f := range frames {
+		d.printFrame(n, f)
+	}
+	if len(frames) == len(regs.Stack) {
+		d.Println(red("The stack is full, another call will overflow it"))
+	}
 }
 
-func next(d *Debugger, ops []string) {
-	// Next is just like step, except for if we're on a call instruction,
-	// we stop after the call finishes.
-	if ins := d.dis.dis(d.c.mem[d.c.pc : d.c.pc+2]); ins.isCall() {
-		addBreakpoint(d.tbps, d.c.pc+2)
-		cont(d, nil)
-	} else {
-		d.StepOne()
+func frame(d *Debugger, ops []string) {
+	frames := d.Backtrace()
+	if len(frames) == 0 {
+		d.Println("Not in a subroutine")
+		return
 	}
+	switch len(ops) {
+	case 0:
+	case 1:
+		n, err := strconv.Atoi(ops[0])
+		if err != nil || n < 0 || n >= len(frames) {
+			d.Printf("No frame %s, there are %d\n", ops[0], len(frames))
+			return
+		}
+		d.frame = n
+	default:
+		d.Println("Usage: frame [<num>]")
+		return
+	}
+	f := frames[d.frame]
+	d.printFrame(d.frame, f)
+	d.printListing(f.CallSite + 2)
 }
 
 func examine(d *Debugger, ops []string) {
@@ -203,28 +418,25 @@ func examine(d *Debugger, ops []string) {
 		count = 1
 	} else {
 		var err error
-		count, err = parseAddr(ops[0])
+		count, err = d.parseAddr(ops[0])
 		if err != nil {
 			d.Println(err)
 			return
 		}
 		ops = ops[1:]
 	}
-	addr, err := parseAddr(ops[0])
+	addr, err := d.parseAddr(ops[0])
 	if err != nil {
 		d.Println(err)
 		return
 	}
-	var i uint16
-	if addr+count > MAX_MEM_ADDRESS {
-		count = MAX_MEM_ADDRESS - addr
-	}
-	for ; count > 16; count -= 16 {
-		d.Printf(white("%#04x: ")+"% x\n", addr+i, d.c.mem[addr+i:addr+i+16])
-		i += 16
-	}
-	if count != 0 {
-		d.Printf(white("%#04x: ")+"% x\n", addr+i, d.c.mem[addr+i:addr+i+count])
+	mem := d.Examine(addr, int(count))
+	for i := 0; i < len(mem); i += 16 {
+		end := i + 16
+		if end > len(mem) {
+			end = len(mem)
+		}
+		d.Printf(white("%#04x: ")+"% x\n", int(addr)+i, mem[i:end])
 	}
 }
 
@@ -233,7 +445,7 @@ func edit(d *Debugger, ops []string) {
 		d.Println("usage: e ADDR value")
 		return
 	}
-	addr, err := parseAddr(ops[0])
+	addr, err := d.parseAddr(ops[0])
 	if err != nil {
 		d.Println(err)
 		return
@@ -243,10 +455,310 @@ func edit(d *Debugger, ops []string) {
 		d.Println(err)
 		return
 	}
-	d.c.mem[addr] = byte(v)
+	d.SetMem(addr, []byte{byte(v)})
+}
+
+func save(d *Debugger, ops []string) {
+	if len(ops) != 1 {
+		d.Println("usage: save FILE")
+		return
+	}
+	f, err := os.Create(ops[0])
+	if err != nil {
+		d.Println(err)
+		return
+	}
+	defer f.Close()
+	if err := d.SaveState(f); err != nil {
+		d.Println(err)
+		return
+	}
+	d.Printf("Saved state to %s\n", ops[0])
+}
+
+func load(d *Debugger, ops []string) {
+	if len(ops) != 1 {
+		d.Println("usage: load FILE")
+		return
+	}
+	f, err := os.Open(ops[0])
+	if err != nil {
+		d.Println(err)
+		return
+	}
+	defer f.Close()
+	if err := d.LoadState(f); err != nil {
+		d.Println(err)
+		return
+	}
+	d.Printf("Loaded state from %s\n", ops[0])
+}
+
+// parseCount parses an optional count of at least 1 for a command.
+func (d *Debugger) parseCount(usage string, ops []string) (int, bool) {
+	if len(ops) > 1 {
+		d.Println(usage)
+		return 0, false
+	}
+	if len(ops) == 0 {
+		return 1, true
+	}
+	v, err := strconv.Atoi(ops[0])
+	if err != nil || v < 1 {
+		d.Println(usage)
+		return 0, false
+	}
+	return v, true
+}
+
+func rewind(d *Debugger, ops []string) {
+	if n, ok := d.parseCount("usage: rewind [FRAMES]", ops); ok {
+		done := d.Rewind(n)
+		d.Printf("Rewound %d frames (%d left)\n", done, d.RewindLen())
+	}
+}
+
+func reverseStep(d *Debugger, ops []string) {
+	if n, ok := d.parseCount("usage: rs [COUNT]", ops); ok {
+		d.ReverseStep(n)
+	}
+}
+
+func reverseCont(d *Debugger, ops []string) {
+	d.ReverseContinue()
+}
+
+func syms(d *Debugger, ops []string) {
+	switch len(ops) {
+	case 0:
+		if d.symbols.Len() == 0 {
+			d.Println(white("No symbols"))
+			return
+		}
+		for _, sym := range d.symbols.All() {
+			d.Printf(white("0x%04X ")+"%s\n", sym.Addr, sym.Label)
+		}
+	case 1:
+		s, err := LoadSymbols(ops[0])
+		if err != nil {
+			d.Println(err)
+			return
+		}
+		d.SetSymbols(s)
+		d.Printf("Loaded %d symbols from %s\n", s.Len(), ops[0])
+	default:
+		d.Println("Usage: sym [<file>]")
+	}
+}
+
+// stopTrace finishes the trace started with the trace command, if any.
+func (d *Debugger) stopTrace() {
+	if d.tracer == nil {
+		return
+	}
+	d.c.SetTracer(nil)
+	if err := d.tracer.Close(); err != nil {
+		d.Println(err)
+	}
+	d.tracer = nil
+}
+
+func trace(d *Debugger, ops []string) {
+	usage := "Usage: trace on <file> [text|binary] [<start>-<end>...] | trace off"
+	if len(ops) == 1 && ops[0] == "off" {
+		if d.tracer == nil {
+			d.Println("Not tracing")
+			return
+		}
+		d.stopTrace()
+		d.Println("Trace stopped")
+		return
+	}
+	if len(ops) < 2 || ops[0] != "on" {
+		d.Println(usage)
+		return
+	}
+	filename, ops := ops[1], ops[2:]
+	format := TraceText
+	if len(ops) > 0 && !strings.Contains(ops[0], "-") {
+		f, err := TraceFormatByName(ops[0])
+		if err != nil {
+			d.Println(err)
+			return
+		}
+		format, ops = f, ops[1:]
+	}
+	var ranges [][2]uint16
+	for _, op := range ops {
+		bounds := strings.SplitN(op, "-", 2)
+		if len(bounds) != 2 {
+			d.Println(usage)
+			return
+		}
+		start, err := d.parseAddr(bounds[0])
+		if err != nil {
+			d.Println(err)
+			return
+		}
+		end, err := d.parseAddr(bounds[1])
+		if err != nil {
+			d.Println(err)
+			return
+		}
+		ranges = append(ranges, [2]uint16{start, end})
+	}
+	t, err := CreateTrace(filename, format)
+	if err != nil {
+		d.Println(err)
+		return
+	}
+	for _, r := range ranges {
+		t.Filter(r[0], r[1])
+	}
+	d.stopTrace()
+	d.tracer = t
+	d.c.SetTracer(t)
+	d.Printf("Tracing to %s\n", filename)
+}
+
+func profile(d *Debugger, ops []string) {
+	usage := "Usage: profile on|off|reset | profile [<count>] | profile save <file>"
+	if len(ops) > 0 && ops[0] == "on" {
+		if d.profiler == nil {
+			d.profiler = NewProfiler()
+		}
+		d.c.SetProfiler(d.profiler)
+		d.Println("Profiling")
+		return
+	}
+	if d.profiler == nil {
+		d.Println("Not profiling, start with 'profile on'")
+		return
+	}
+	d.profiler.SetSymbols(d.symbols)
+	top := 20
+	switch {
+	case len(ops) == 1 && ops[0] == "off":
+		d.c.SetProfiler(nil)
+		d.Println("Profiling stopped")
+		return
+	case len(ops) == 1 && ops[0] == "reset":
+		d.profiler.Reset()
+		return
+	case len(ops) == 2 && ops[0] == "save":
+		f, err := os.Create(ops[1])
+		if err != nil {
+			d.Println(err)
+			return
+		}
+		err = d.profiler.WritePprof(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			d.Println(err)
+			return
+		}
+		d.Printf("Wrote %s, view it with 'go tool pprof %s'\n", ops[1], ops[1])
+		return
+	case len(ops) == 1:
+		n, err := strconv.Atoi(ops[0])
+		if err != nil || n <= 0 {
+			d.Println(usage)
+			return
+		}
+		top = n
+	case len(ops) > 1:
+		d.Println(usage)
+		return
+	}
+	if err := d.profiler.WriteReport(d.out, d.c, top); err != nil {
+		d.Println(err)
+	}
+}
+
+func coverage(d *Debugger, ops []string) {
+	usage := fmt.Sprintf("Usage: coverage on|off|reset | coverage | coverage save <file> [%s]", strings.Join(CoverageFormats, "|"))
+	if len(ops) > 0 && ops[0] == "on" {
+		if d.coverage == nil {
+			d.coverage = NewCoverage()
+		}
+		d.c.SetCoverage(d.coverage)
+		d.Println("Recording coverage")
+		return
+	}
+	if d.coverage == nil {
+		d.Println("Not recording coverage, start with 'coverage on'")
+		return
+	}
+	d.coverage.SetSymbols(d.symbols)
+	switch {
+	case len(ops) == 0:
+		if err := d.coverage.WriteReport(d.out, d.c); err != nil {
+			d.Println(err)
+		}
+	case len(ops) == 1 && ops[0] == "off":
+		d.c.SetCoverage(nil)
+		d.Println("Coverage stopped")
+	case len(ops) == 1 && ops[0] == "reset":
+		d.coverage.Reset()
+	case (len(ops) == 2 || len(ops) == 3) && ops[0] == "save":
+		format := "text"
+		if len(ops) == 3 {
+			format = ops[2]
+		}
+		f, err := os.Create(ops[1])
+		if err != nil {
+			d.Println(err)
+			return
+		}
+		err = d.coverage.Write(f, d.c, d.sources, format)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			d.Println(err)
+			return
+		}
+		d.Printf("Wrote %s\n", ops[1])
+	default:
+		d.Println(usage)
+	}
+}
+
+// disassemble lists the whole ROM, following the code from its entry point
+// so data isn't shown as instructions.
+func disassemble(d *Debugger, ops []string) {
+	if len(ops) > 1 {
+		d.Println("Usage: dis [file]")
+		return
+	}
+	rom := append([]byte(nil), d.c.mem[0x200:0x200+d.c.romSize]...)
+	a := d.dis.Analyze(rom)
+	if len(ops) == 0 {
+		if err := a.WriteListing(d.out, ListingOptions{Raw: true}); err != nil {
+			d.Println(err)
+		}
+		return
+	}
+	f, err := os.Create(ops[0])
+	if err != nil {
+		d.Println(err)
+		return
+	}
+	err = a.WriteListing(f, ListingOptions{Raw: true})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		d.Println(err)
+		return
+	}
+	d.Printf("Wrote %s\n", ops[0])
 }
 
 func quit(d *Debugger, ops []string) {
 	d.Println("goodbye.")
+	d.stopTrace()
 	os.Exit(0)
 }
@@ -0,0 +1,214 @@
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxScriptDepth limits how deeply sourced files and macros can nest, so a
+// macro calling itself can't hang the debugger.
+const maxScriptDepth = 32
+
+// block collects the lines of a define or commands block until "end".
+type block struct {
+	lines []string
+	done  func(lines []string)
+	// nested counts the blocks opened inside this one, whose "end" lines
+	// belong to its body
+	nested int
+}
+
+// These commands run other commands, so they can't be in the commands map's
+// initializer.
+func init() {
+	commands["source"] = source
+	commands["define"] = define
+	commands["commands"] = breakCommands
+}
+
+// collect adds a line to the block being entered, finishing it on "end".
+func (d *Debugger) collect(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	b := d.block
+	switch fields := strings.Fields(line); fields[0] {
+	case "define", "commands":
+		// Only their one argument forms open a block
+		if len(fields) == 2 {
+			b.nested++
+		}
+	case "end":
+		if b.nested == 0 {
+			d.block = nil
+			b.done(b.lines)
+			return
+		}
+		b.nested--
+	}
+	b.lines = append(b.lines, line)
+}
+
+// runLines runs commands from a file or macro.
+func (d *Debugger) runLines(lines []string) {
+	if d.depth >= maxScriptDepth {
+		d.Println("Scripts nested too deeply")
+		return
+	}
+	d.depth++
+	defer func() { d.depth-- }()
+	for _, line := range lines {
+		d.Handle(line)
+	}
+}
+
+// runQueued runs the commands of breakpoints that have been hit. Commands that
+// hit more breakpoints add to the queue rather than nesting.
+func (d *Debugger) runQueued() {
+	if d.draining {
+		return
+	}
+	d.draining = true
+	defer func() { d.draining = false }()
+	for len(d.queued) > 0 {
+		line := d.queued[0]
+		d.queued = d.queued[1:]
+		d.Handle(line)
+	}
+}
+
+// Source runs the commands in a file, one per line. Blank lines and lines
+// starting with # are skipped.
+func (d *Debugger) Source(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var lines []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	d.runLines(lines)
+	if d.block != nil {
+		d.block = nil
+		return fmt.Errorf("%s: missing 'end'", filename)
+	}
+	return nil
+}
+
+// sourceInitFiles runs ~/.chip8dbgrc and then <ROM>.dbg, where they exist.
+func (d *Debugger) sourceInitFiles() {
+	var files []string
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".chip8dbgrc"))
+	}
+	files = append(files, d.rom+".dbg")
+	for _, f := range files {
+		if _, err := os.Stat(f); err != nil {
+			continue
+		}
+		if err := d.Source(f); err != nil {
+			d.Println(err)
+		}
+	}
+}
+
+func source(d *Debugger, ops []string) {
+	if len(ops) != 1 {
+		d.Println("Usage: source <file>")
+		return
+	}
+	if err := d.Source(ops[0]); err != nil {
+		d.Println(err)
+	}
+}
+
+// runMacro runs a user defined command, replacing $1 to $9 in its body with
+// the arguments it was given.
+func (d *Debugger) runMacro(body, args []string) {
+	lines := make([]string, len(body))
+	for n, line := range body {
+		for i := len(args); i > 0; i-- {
+			line = strings.Replace(line, "$"+strconv.Itoa(i), args[i-1], -1)
+		}
+		lines[n] = line
+	}
+	d.runLines(lines)
+}
+
+func define(d *Debugger, ops []string) {
+	if len(ops) == 0 {
+		if len(d.macros) == 0 {
+			d.Println(white("No user commands"))
+			return
+		}
+		names := make([]string, 0, len(d.macros))
+		for name := range d.macros {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			d.Println(white(name))
+			for _, line := range d.macros[name] {
+				d.Println("  " + line)
+			}
+		}
+		return
+	}
+	if len(ops) != 1 {
+		d.Println("Usage: define [<name>]")
+		return
+	}
+	name := ops[0]
+	if _, ok := commands[name]; ok {
+		d.Printf("Can't redefine built-in command %s\n", name)
+		return
+	}
+	d.Printf("Type commands for %s, one per line, ending with 'end'.\n", name)
+	d.block = &block{done: func(lines []string) {
+		if len(lines) == 0 {
+			delete(d.macros, name)
+			return
+		}
+		d.macros[name] = lines
+	}}
+}
+
+func breakCommands(d *Debugger, ops []string) {
+	if len(ops) != 1 {
+		d.Println("Usage: commands <addr>")
+		return
+	}
+	addr, err := d.parseAddr(ops[0])
+	if err != nil {
+		d.Println(err)
+		return
+	}
+	if _, ok := d.Breakpoint(addr, false); !ok {
+		d.Printf("No bp at 0x%04X\n", addr)
+		return
+	}
+	d.Printf("Type commands for when the bp at 0x%04X is hit, one per line, ending with 'end'.\n", addr)
+	d.block = &block{done: func(lines []string) {
+		if len(lines) == 0 {
+			delete(d.bpCommands, addr)
+			return
+		}
+		d.bpCommands[addr] = lines
+	}}
+}
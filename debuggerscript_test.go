@@ -0,0 +1,168 @@
+package chip8
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// handle passes each line to the debugger, as typed at the prompt.
+func handle(t *testing.T, d *Debugger, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if err := d.Handle(line); err != nil {
+			t.Fatalf("Handle(%q): %v", line, err)
+		}
+	}
+}
+
+func writeScript(t *testing.T, filename string, lines ...string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefine(t *testing.T) {
+	var out syncBuffer
+	d := newTestDebugger(t, testROM, &out)
+	handle(t, d,
+		"define poke",
+		"e $1 $2",
+		"end",
+		"define poke2",
+		"poke $1 $2",
+		"poke 0x301 $2",
+		"end",
+		"poke2 0x300 0x42",
+	)
+	if got := d.c.mem[0x300:0x302]; got[0] != 0x42 || got[1] != 0x42 {
+		t.Errorf("mem = % X after macros, want 42 42", got)
+	}
+
+	handle(t, d, "define x")
+	if !strings.Contains(out.String(), "Can't redefine built-in command x") {
+		t.Errorf("redefined x:\n%s", out.String())
+	}
+	if d.block != nil {
+		t.Error("redefining x started a block")
+	}
+}
+
+// TestBreakpointCommands runs a commands block, with a define nested in it,
+// when its breakpoint is hit.
+func TestBreakpointCommands(t *testing.T) {
+	var out syncBuffer
+	d := newTestDebugger(t, testROM, &out)
+	handle(t, d,
+		"b 0x20A",
+		"commands 0x20A",
+		"e 0x300 0x11",
+		"define hit",
+		"e 0x301 0x22",
+		"end",
+		"end",
+	)
+	if d.block != nil {
+		t.Fatal("commands block still open after its end")
+	}
+	if got := len(d.bpCommands[0x20A]); got != 4 {
+		t.Fatalf("bp has %d commands, want 4: %q", got, d.bpCommands[0x20A])
+	}
+	if d.c.mem[0x300] != 0 {
+		t.Fatal("commands ran before the bp was hit")
+	}
+
+	handle(t, d, "c")
+	if d.c.pc != 0x20A {
+		t.Fatalf("stopped at 0x%04X, want 0x020A", d.c.pc)
+	}
+	if d.c.mem[0x300] != 0x11 {
+		t.Errorf("mem[0x300] = 0x%02X, want 0x11 from the bp's commands", d.c.mem[0x300])
+	}
+	if d.block != nil {
+		t.Error("nested define left a block open")
+	}
+	handle(t, d, "hit")
+	if d.c.mem[0x301] != 0x22 {
+		t.Errorf("mem[0x301] = 0x%02X, want 0x22 from the macro the bp defined", d.c.mem[0x301])
+	}
+}
+
+func TestSource(t *testing.T) {
+	var out syncBuffer
+	d := newTestDebugger(t, testROM, &out)
+	script := filepath.Join(t.TempDir(), "script")
+	writeScript(t, script,
+		"# comment",
+		"",
+		"define poke",
+		"e $1 $2",
+		"end",
+		"poke 0x300 7",
+		"b 0x20A",
+	)
+	handle(t, d, "source "+script)
+	if d.c.mem[0x300] != 7 {
+		t.Errorf("mem[0x300] = 0x%02X, want 7", d.c.mem[0x300])
+	}
+	if _, ok := d.Breakpoint(0x20A, false); !ok {
+		t.Error("script didn't set the bp")
+	}
+}
+
+func TestSourceMissingEnd(t *testing.T) {
+	var out syncBuffer
+	d := newTestDebugger(t, testROM, &out)
+	script := filepath.Join(t.TempDir(), "script")
+	writeScript(t, script,
+		"b 0x20A",
+		"commands 0x20A",
+		"e 0x300 1",
+	)
+	err := d.Source(script)
+	if err == nil || !strings.Contains(err.Error(), "missing 'end'") {
+		t.Fatalf("Source = %v, want missing 'end'", err)
+	}
+	if d.block != nil {
+		t.Fatal("unterminated block left open")
+	}
+	if _, ok := d.bpCommands[0x20A]; ok {
+		t.Error("unterminated commands were kept")
+	}
+	// The prompt runs commands again
+	handle(t, d, "e 0x301 2")
+	if d.c.mem[0x301] != 2 {
+		t.Error("command after the script was swallowed")
+	}
+}
+
+// TestInitFiles runs ~/.chip8dbgrc before the ROM's .dbg, so the ROM's
+// script can use what the user's defines.
+func TestInitFiles(t *testing.T) {
+	dir := t.TempDir()
+	home := os.Getenv("HOME")
+	os.Setenv("HOME", dir)
+	defer os.Setenv("HOME", home)
+	writeScript(t, filepath.Join(dir, ".chip8dbgrc"),
+		"define poke",
+		"e $1 $2",
+		"end",
+	)
+	rom := filepath.Join(dir, "game.ch8")
+	writeScript(t, rom+".dbg", "poke 0x300 9")
+
+	var out syncBuffer
+	d := NewDebugger(rom, Quirks{})
+	d.SetOutput(&out)
+	d.SetROM(testROM)
+	if err := d.Attach(&NullDisplay{}, &NoKeypad{}); err != nil {
+		t.Fatal(err)
+	}
+	d.sourceInitFiles()
+	if d.c.mem[0x300] != 9 {
+		t.Errorf("mem[0x300] = 0x%02X, want 9:\n%s", d.c.mem[0x300], out.String())
+	}
+}
@@ -0,0 +1,468 @@
+package chip8
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Mnemonic identifies an instruction, using the names printed by
+// Disassembler. Forms that share a name, like LD V0, V1 and LD V0, 0x12, are
+// told apart by their operands.
+type Mnemonic int
+
+const (
+	// MnemonicIllegal is an opcode no mode defines
+	MnemonicIllegal Mnemonic = iota
+	MnemonicCLS
+	MnemonicRET
+	MnemonicSCD
+	MnemonicSCU
+	MnemonicSCR
+	MnemonicSCL
+	MnemonicEXIT
+	MnemonicLOW
+	MnemonicHIGH
+	MnemonicSYS
+	MnemonicJP
+	MnemonicCALL
+	MnemonicSE
+	MnemonicSNE
+	MnemonicSAVE
+	MnemonicLOAD
+	MnemonicLD
+	MnemonicADD
+	MnemonicOR
+	MnemonicAND
+	MnemonicXOR
+	MnemonicSUB
+	MnemonicSHR
+	MnemonicSUBN
+	MnemonicSHL
+	MnemonicRND
+	MnemonicDRW
+	MnemonicSKP
+	MnemonicSKNP
+	MnemonicPLANE
+	MnemonicAUDIO
+	MnemonicPITCH
+)
+
+var mnemonicNames = [...]string{
+	MnemonicIllegal: "<ILL>",
+	MnemonicCLS:     "CLS",
+	MnemonicRET:     "RET",
+	MnemonicSCD:     "SCD",
+	MnemonicSCU:     "SCU",
+	MnemonicSCR:     "SCR",
+	MnemonicSCL:     "SCL",
+	MnemonicEXIT:    "EXIT",
+	MnemonicLOW:     "LOW",
+	MnemonicHIGH:    "HIGH",
+	MnemonicSYS:     "SYS",
+	MnemonicJP:      "JP",
+	MnemonicCALL:    "CALL",
+	MnemonicSE:      "SE",
+	MnemonicSNE:     "SNE",
+	MnemonicSAVE:    "SAVE",
+	MnemonicLOAD:    "LOAD",
+	MnemonicLD:      "LD",
+	MnemonicADD:     "ADD",
+	MnemonicOR:      "OR",
+	MnemonicAND:     "AND",
+	MnemonicXOR:     "XOR",
+	MnemonicSUB:     "SUB",
+	MnemonicSHR:     "SHR",
+	MnemonicSUBN:    "SUBN",
+	MnemonicSHL:     "SHL",
+	MnemonicRND:     "RND",
+	MnemonicDRW:     "DRW",
+	MnemonicSKP:     "SKP",
+	MnemonicSKNP:    "SKNP",
+	MnemonicPLANE:   "PLANE",
+	MnemonicAUDIO:   "AUDIO",
+	MnemonicPITCH:   "PITCH",
+}
+
+func (m Mnemonic) String() string {
+	if m < 0 || int(m) >= len(mnemonicNames) {
+		return fmt.Sprintf("Mnemonic(%d)", int(m))
+	}
+	return mnemonicNames[m]
+}
+
+// OperandKind is what an operand refers to.
+type OperandKind int
+
+const (
+	// OperandRegister is V0-VF, with the number in Value
+	OperandRegister OperandKind = iota
+	// OperandImmediate is a byte
+	OperandImmediate
+	// OperandAddress is a 12 bit address, or 16 bits for the long LD I
+	OperandAddress
+	// OperandNibble is a 4 bit value, like a sprite height or plane mask
+	OperandNibble
+	// The rest are the other registers and things instructions name
+	OperandI
+	OperandDT
+	OperandST
+	OperandK
+	OperandF
+	OperandHF
+	OperandB
+	// OperandIndirect is memory at I, [I]
+	OperandIndirect
+	// OperandRPL is the SUPER-CHIP flag registers, R
+	OperandRPL
+)
+
+var operandKindNames = [...]string{
+	OperandRegister:  "register",
+	OperandImmediate: "immediate",
+	OperandAddress:   "address",
+	OperandNibble:    "nibble",
+	OperandI:         "i",
+	OperandDT:        "dt",
+	OperandST:        "st",
+	OperandK:         "k",
+	OperandF:         "f",
+	OperandHF:        "hf",
+	OperandB:         "b",
+	OperandIndirect:  "indirect",
+	OperandRPL:       "rpl",
+}
+
+func (k OperandKind) String() string {
+	if k < 0 || int(k) >= len(operandKindNames) {
+		return fmt.Sprintf("OperandKind(%d)", int(k))
+	}
+	return operandKindNames[k]
+}
+
+// Operand is an argument to an instruction.
+type Operand struct {
+	Kind  OperandKind
+	Value uint16
+}
+
+// fixedOperands are how Disassembler writes the operands without a value.
+var fixedOperands = map[OperandKind]string{
+	OperandI:        "I",
+	OperandDT:       "DT",
+	OperandST:       "ST",
+	OperandK:        "K",
+	OperandF:        "F",
+	OperandHF:       "HF",
+	OperandB:        "B",
+	OperandIndirect: "[I]",
+	OperandRPL:      "R",
+}
+
+func (o Operand) String() string {
+	switch o.Kind {
+	case OperandRegister:
+		return fmt.Sprintf("V%X", o.Value)
+	case OperandImmediate:
+		return fmt.Sprintf("0x%02X", o.Value)
+	case OperandAddress:
+		if o.Value > 0xFFF {
+			return fmt.Sprintf("0x%04X", o.Value)
+		}
+		return fmt.Sprintf("0x%03X", o.Value)
+	case OperandNibble:
+		return fmt.Sprintf("0x%X", o.Value)
+	}
+	return fixedOperands[o.Kind]
+}
+
+// Flow is how an instruction affects what runs next.
+type Flow int
+
+const (
+	// FlowNext carries on with the following instruction
+	FlowNext Flow = iota
+	// FlowJump goes to Target
+	FlowJump
+	// FlowJumpIndirect goes to Target plus V0, so can't be followed statically
+	FlowJumpIndirect
+	// FlowCall calls Target, coming back to the following instruction
+	FlowCall
+	// FlowReturn returns from a subroutine
+	FlowReturn
+	// FlowSkip may skip the following instruction
+	FlowSkip
+	// FlowStop ends execution, for EXIT and illegal instructions
+	FlowStop
+)
+
+var flowNames = [...]string{
+	FlowNext:         "next",
+	FlowJump:         "jump",
+	FlowJumpIndirect: "jump-indirect",
+	FlowCall:         "call",
+	FlowReturn:       "return",
+	FlowSkip:         "skip",
+	FlowStop:         "stop",
+}
+
+func (f Flow) String() string {
+	if f < 0 || int(f) >= len(flowNames) {
+		return fmt.Sprintf("Flow(%d)", int(f))
+	}
+	return flowNames[f]
+}
+
+// Instruction is a decoded instruction.
+type Instruction struct {
+	Op       uint16
+	Mnemonic Mnemonic
+	Operands []Operand
+	// Length is the size in bytes, 4 for the XO-CHIP long LD I and 2 for
+	// everything else
+	Length int
+	Flow   Flow
+	// Target is the address jumped to or called, and the base of JP V0
+	Target uint16
+}
+
+// IsBranch reports whether the instruction jumps.
+func (i Instruction) IsBranch() bool {
+	return i.Flow == FlowJump || i.Flow == FlowJumpIndirect
+}
+
+// IsCall reports whether the instruction calls a subroutine.
+func (i Instruction) IsCall() bool {
+	return i.Flow == FlowCall
+}
+
+// IsReturn reports whether the instruction returns from a subroutine.
+func (i Instruction) IsReturn() bool {
+	return i.Flow == FlowReturn
+}
+
+// IsSkip reports whether the instruction may skip the next one.
+func (i Instruction) IsSkip() bool {
+	return i.Flow == FlowSkip
+}
+
+// Successors returns where execution can go after the instruction at addr:
+// both outcomes of a skip, the target and the return address of a call, and
+// nothing after a return, an indirect jump or an instruction that stops. A
+// skip is assumed to skip a two byte instruction. Where the next one may be
+// an XO-CHIP long LD I, which the CPU skips whole, use SuccessorsWith.
+func (i Instruction) Successors(addr uint16) []uint16 {
+	return i.SuccessorsWith(addr, Instruction{Length: 2})
+}
+
+// SuccessorsWith is like Successors for an instruction followed by next, so
+// a skip lands after next whatever its length, as the CPU's does.
+func (i Instruction) SuccessorsWith(addr uint16, next Instruction) []uint16 {
+	after := addr + uint16(i.Length)
+	switch i.Flow {
+	case FlowNext:
+		return []uint16{after}
+	case FlowJump:
+		return []uint16{i.Target}
+	case FlowCall:
+		return []uint16{i.Target, after}
+	case FlowSkip:
+		return []uint16{after, after + uint16(next.Length)}
+	}
+	return nil
+}
+
+// String writes the instruction as the assembler reads it.
+func (i Instruction) String() string {
+	return i.format(func(uint16) (string, bool) { return "", false })
+}
+
+// format writes the instruction as the assembler reads it, naming the
+// addresses it refers to with label where it can. Long loads are written with
+// LONG, so they assemble to the same bytes whatever the address, and PLANE's
+// mask in decimal.
+func (i Instruction) format(label func(addr uint16) (string, bool)) string {
+	if len(i.Operands) == 0 {
+		return i.Mnemonic.String()
+	}
+	args := make([]string, len(i.Operands))
+	for n, o := range i.Operands {
+		name, ok := "", false
+		if o.Kind == OperandAddress && i.Mnemonic != MnemonicSYS {
+			name, ok = label(o.Value)
+		}
+		switch {
+		case o.Kind == OperandAddress && i.Length == 4 && ok:
+			args[n] = "LONG " + name
+		case o.Kind == OperandAddress && i.Length == 4:
+			args[n] = fmt.Sprintf("LONG 0x%04X", o.Value)
+		case ok:
+			args[n] = name
+		case i.Mnemonic == MnemonicPLANE:
+			args[n] = fmt.Sprintf("%d", o.Value)
+		default:
+			args[n] = o.String()
+		}
+	}
+	return i.Mnemonic.String() + " " + strings.Join(args, ", ")
+}
+
+// aluMnemonics are the 8XYN instructions by N.
+var aluMnemonics = map[uint16]Mnemonic{
+	0x0: MnemonicLD, 0x1: MnemonicOR, 0x2: MnemonicAND, 0x3: MnemonicXOR,
+	0x4: MnemonicADD, 0x5: MnemonicSUB, 0x6: MnemonicSHR, 0x7: MnemonicSUBN,
+	0xE: MnemonicSHL,
+}
+
+// Decode decodes an opcode for any mode; whether the machine runs it depends
+// on its quirks. The long LD I, F000, has its address in the next word, which
+// Decode can't see, so its address is 0. DecodeBytes fills it in.
+func Decode(op uint16) Instruction {
+	reg := func(r uint8) Operand { return Operand{OperandRegister, uint16(r)} }
+	x, y := reg(ArgX(op)), reg(ArgY(op))
+	nn := Operand{OperandImmediate, uint16(ArgNN(op))}
+	n := Operand{OperandNibble, uint16(ArgN(op))}
+	nnn := Operand{OperandAddress, ArgNNN(op)}
+	fixed := func(k OperandKind) Operand { return Operand{Kind: k} }
+
+	i := Instruction{Op: op, Length: 2}
+	set := func(m Mnemonic, ops ...Operand) Instruction {
+		i.Mnemonic, i.Operands = m, ops
+		return i
+	}
+	switch op >> 12 {
+	case 0x0:
+		switch {
+		case op == 0x00E0:
+			return set(MnemonicCLS)
+		case op == 0x00EE:
+			i.Flow = FlowReturn
+			return set(MnemonicRET)
+		case op&0xFFF0 == 0x00C0:
+			return set(MnemonicSCD, n)
+		case op&0xFFF0 == 0x00D0:
+			return set(MnemonicSCU, n)
+		case op == 0x00FB:
+			return set(MnemonicSCR)
+		case op == 0x00FC:
+			return set(MnemonicSCL)
+		case op == 0x00FD:
+			i.Flow = FlowStop
+			return set(MnemonicEXIT)
+		case op == 0x00FE:
+			return set(MnemonicLOW)
+		case op == 0x00FF:
+			return set(MnemonicHIGH)
+		}
+		return set(MnemonicSYS, nnn)
+	case 0x1:
+		i.Flow, i.Target = FlowJump, ArgNNN(op)
+		return set(MnemonicJP, nnn)
+	case 0x2:
+		i.Flow, i.Target = FlowCall, ArgNNN(op)
+		return set(MnemonicCALL, nnn)
+	case 0x3:
+		i.Flow = FlowSkip
+		return set(MnemonicSE, x, nn)
+	case 0x4:
+		i.Flow = FlowSkip
+		return set(MnemonicSNE, x, nn)
+	case 0x5:
+		switch op & 0xF {
+		case 0x0:
+			i.Flow = FlowSkip
+			return set(MnemonicSE, x, y)
+		case 0x2:
+			return set(MnemonicSAVE, x, y)
+		case 0x3:
+			return set(MnemonicLOAD, x, y)
+		}
+	case 0x6:
+		return set(MnemonicLD, x, nn)
+	case 0x7:
+		return set(MnemonicADD, x, nn)
+	case 0x8:
+		if m, ok := aluMnemonics[op&0xF]; ok {
+			return set(m, x, y)
+		}
+	case 0x9:
+		if op&0xF == 0 {
+			i.Flow = FlowSkip
+			return set(MnemonicSNE, x, y)
+		}
+	case 0xA:
+		return set(MnemonicLD, fixed(OperandI), nnn)
+	case 0xB:
+		i.Flow, i.Target = FlowJumpIndirect, ArgNNN(op)
+		return set(MnemonicJP, reg(0), nnn)
+	case 0xC:
+		return set(MnemonicRND, x, nn)
+	case 0xD:
+		return set(MnemonicDRW, x, y, n)
+	case 0xE:
+		switch op & 0xFF {
+		case 0x9E:
+			i.Flow = FlowSkip
+			return set(MnemonicSKP, x)
+		case 0xA1:
+			i.Flow = FlowSkip
+			return set(MnemonicSKNP, x)
+		}
+	case 0xF:
+		switch op & 0xFF {
+		case 0x00:
+			if op == 0xF000 {
+				i.Length = 4
+				return set(MnemonicLD, fixed(OperandI), Operand{OperandAddress, 0})
+			}
+		case 0x01:
+			return set(MnemonicPLANE, Operand{OperandNibble, uint16(ArgX(op))})
+		case 0x02:
+			if op == 0xF002 {
+				return set(MnemonicAUDIO)
+			}
+		case 0x07:
+			return set(MnemonicLD, x, fixed(OperandDT))
+		case 0x0A:
+			return set(MnemonicLD, x, fixed(OperandK))
+		case 0x15:
+			return set(MnemonicLD, fixed(OperandDT), x)
+		case 0x18:
+			return set(MnemonicLD, fixed(OperandST), x)
+		case 0x1E:
+			return set(MnemonicADD, fixed(OperandI), x)
+		case 0x29:
+			return set(MnemonicLD, fixed(OperandF), x)
+		case 0x30:
+			return set(MnemonicLD, fixed(OperandHF), x)
+		case 0x33:
+			return set(MnemonicLD, fixed(OperandB), x)
+		case 0x3A:
+			return set(MnemonicPITCH, x)
+		case 0x55:
+			return set(MnemonicLD, fixed(OperandIndirect), x)
+		case 0x65:
+			return set(MnemonicLD, x, fixed(OperandIndirect))
+		case 0x75:
+			return set(MnemonicLD, fixed(OperandRPL), x)
+		case 0x85:
+			return set(MnemonicLD, x, fixed(OperandRPL))
+		}
+	}
+	i.Flow = FlowStop
+	return set(MnemonicIllegal)
+}
+
+// DecodeBytes decodes the instruction at the start of code, reading the
+// address of a long LD I from the next word. A long LD I cut off by the end of
+// code is illegal.
+func DecodeBytes(code []byte) Instruction {
+	i := Decode(binary.BigEndian.Uint16(code))
+	if i.Length == 4 {
+		if len(code) < 4 {
+			return Instruction{Op: i.Op, Mnemonic: MnemonicIllegal, Length: 2, Flow: FlowStop}
+		}
+		i.Operands[1].Value = binary.BigEndian.Uint16(code[2:])
+	}
+	return i
+}
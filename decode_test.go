@@ -0,0 +1,104 @@
+package chip8
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		op     uint16
+		want   string
+		flow   Flow
+		target uint16
+	}{
+		{0x00E0, "CLS", FlowNext, 0},
+		{0x00EE, "RET", FlowReturn, 0},
+		{0x00C3, "SCD 0x3", FlowNext, 0},
+		{0x00FD, "EXIT", FlowStop, 0},
+		{0x0123, "SYS 0x123", FlowNext, 0},
+		{0x12A4, "JP 0x2A4", FlowJump, 0x2A4},
+		{0x2300, "CALL 0x300", FlowCall, 0x300},
+		{0x3A12, "SE VA, 0x12", FlowSkip, 0},
+		{0x4A12, "SNE VA, 0x12", FlowSkip, 0},
+		{0x5120, "SE V1, V2", FlowSkip, 0},
+		{0x5122, "SAVE V1, V2", FlowNext, 0},
+		{0x5121, "<ILL>", FlowStop, 0},
+		{0x8127, "SUBN V1, V2", FlowNext, 0},
+		{0x8128, "<ILL>", FlowStop, 0},
+		{0x9120, "SNE V1, V2", FlowSkip, 0},
+		{0xA2F0, "LD I, 0x2F0", FlowNext, 0},
+		{0xB300, "JP V0, 0x300", FlowJumpIndirect, 0x300},
+		{0xD015, "DRW V0, V1, 0x5", FlowNext, 0},
+		{0xE29E, "SKP V2", FlowSkip, 0},
+		{0xE2A1, "SKNP V2", FlowSkip, 0},
+		{0xF301, "PLANE 3", FlowNext, 0},
+		{0xF002, "AUDIO", FlowNext, 0},
+		{0xF102, "<ILL>", FlowStop, 0},
+		{0xF165, "LD V1, [I]", FlowNext, 0},
+		{0xF175, "LD R, V1", FlowNext, 0},
+	}
+	for _, tt := range tests {
+		i := Decode(tt.op)
+		if i.String() != tt.want || i.Flow != tt.flow || i.Target != tt.target || i.Length != 2 {
+			t.Errorf("%04X: got %q %v 0x%X length %d, want %q %v 0x%X length 2",
+				tt.op, i, i.Flow, i.Target, i.Length, tt.want, tt.flow, tt.target)
+		}
+	}
+}
+
+func TestDecodeBytes(t *testing.T) {
+	i := DecodeBytes([]byte{0xF0, 0x00, 0x12, 0x34})
+	if i.Length != 4 || i.Operands[1].Value != 0x1234 || i.String() != "LD I, LONG 0x1234" {
+		t.Errorf("long LD I: got %q length %d", i, i.Length)
+	}
+	i = DecodeBytes([]byte{0xF0, 0x00})
+	if i.Mnemonic != MnemonicIllegal || i.Length != 2 {
+		t.Errorf("truncated long LD I: got %q length %d", i, i.Length)
+	}
+}
+
+func TestSuccessors(t *testing.T) {
+	tests := []struct {
+		op   uint16
+		want []uint16
+	}{
+		{0x00E0, []uint16{0x202}},
+		{0x1300, []uint16{0x300}},
+		{0x2300, []uint16{0x300, 0x202}},
+		{0x3000, []uint16{0x202, 0x204}},
+		{0x00EE, nil},
+		{0xB300, nil},
+	}
+	for _, tt := range tests {
+		if got := Decode(tt.op).Successors(0x200); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%04X: got %X, want %X", tt.op, got, tt.want)
+		}
+	}
+}
+
+// TestSuccessorsWithLongLoad checks a skip over a long LD I lands where the
+// XO-CHIP CPU does.
+func TestSuccessorsWithLongLoad(t *testing.T) {
+	rom := []byte{
+		0x30, 0x00, // SE V0, 0x00, which skips
+		0xF0, 0x00, 0x03, 0x00, // LD I, LONG 0x0300
+		0x00, 0xE0, // CLS
+	}
+	c := NewChip8(&NullDisplay{}, &NoKeypad{}, Quirks{XOChip: true})
+	c.Reset()
+	if err := c.LoadROM(rom); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RunOne(); err != nil {
+		t.Fatal(err)
+	}
+	skip := DecodeBytes(rom)
+	succ := skip.SuccessorsWith(0x200, DecodeBytes(rom[2:]))
+	if succ[1] != c.pc {
+		t.Errorf("skip lands at 0x%X, but the CPU is at 0x%X", succ[1], c.pc)
+	}
+	if succ := skip.Successors(0x200); succ[1] != 0x204 {
+		t.Errorf("Successors assumes a two byte instruction, got 0x%X", succ[1])
+	}
+}
@@ -0,0 +1,41 @@
+package chip8
+
+import "testing"
+
+func TestDis(t *testing.T) {
+	syms := NewSymbols()
+	syms.Add("table", 0x1234)
+	syms.Add("start", 0x200)
+	tests := []struct {
+		code []byte
+		syms *Symbols
+		want string
+	}{
+		{[]byte{0x00, 0xE0}, nil, "CLS"},
+		{[]byte{0xA3, 0x00}, nil, "LD I, 0x300"},
+		{[]byte{0x12, 0x00}, syms, "JP start"},
+		{[]byte{0xF0, 0x00, 0x12, 0x34}, nil, "LD I, LONG 0x1234"},
+		{[]byte{0xF0, 0x00, 0x12, 0x34}, syms, "LD I, LONG table"},
+		{[]byte{0xF0, 0x00, 0x03, 0x00}, nil, "LD I, LONG 0x0300"},
+		{[]byte{0xF3, 0x01}, nil, "PLANE 3"},
+	}
+	for _, tt := range tests {
+		d := &Disassembler{}
+		d.SetSymbols(tt.syms)
+		if got := d.dis(tt.code).String(); got != tt.want {
+			t.Errorf("% X: got %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+// TestDisMatchesString checks the disassembler writes instructions without
+// symbols as Instruction.String does.
+func TestDisMatchesString(t *testing.T) {
+	var d Disassembler
+	for op := 0; op <= 0xFFFF; op++ {
+		code := []byte{byte(op >> 8), byte(op), 0x12, 0x34}
+		if got, want := d.dis(code).String(), DecodeBytes(code).String(); got != want {
+			t.Errorf("%04X: disassembled %q, String gives %q", op, got, want)
+		}
+	}
+}
@@ -1,8 +1,13 @@
 package chip8
 
+import "strings"
+
 const (
 	SCREEN_WIDTH  = 64
 	SCREEN_HEIGHT = 32
+
+	HIRES_SCREEN_WIDTH  = 128
+	HIRES_SCREEN_HEIGHT = 64
 )
 
 func ClearPixel(x, y int, i WriteableImage) {
@@ -15,53 +20,180 @@ type WriteableImage interface {
 	Toggle(x, y int, color byte) (collision bool)
 }
 
+// IterableImage is the screen handed to renderers. At returns a bitmask of
+// the XO-CHIP planes set at a pixel, so 0-3, while AtPlane reports a single
+// plane.
 type IterableImage interface {
 	WriteableImage
+	AtPlane(x, y int, plane byte) byte
+	Width() int
+	Height() int
 	OnEachPixel(func(x, y int, i WriteableImage))
 }
 
 type myScreen struct {
-	buffer [SCREEN_WIDTH * SCREEN_HEIGHT]byte
+	buffer [HIRES_SCREEN_WIDTH * HIRES_SCREEN_HEIGHT]byte
+	hires  bool
+}
+
+func (i *myScreen) Width() int {
+	if i.hires {
+		return HIRES_SCREEN_WIDTH
+	}
+	return SCREEN_WIDTH
+}
+
+func (i *myScreen) Height() int {
+	if i.hires {
+		return HIRES_SCREEN_HEIGHT
+	}
+	return SCREEN_HEIGHT
+}
+
+// SetHires switches between the 64x32 and 128x64 modes, clearing the screen.
+func (i *myScreen) SetHires(hires bool) {
+	i.hires = hires
+	i.OnEachPixel(ClearPixel)
 }
 
 func (i *myScreen) At(x, y int) byte {
-	return i.buffer[y*SCREEN_WIDTH+x]
+	return i.buffer[y*i.Width()+x]
+}
+
+// AtPlane returns 1 if the pixel is set in plane, where plane is a single bit
+// of the mask returned by At.
+func (i *myScreen) AtPlane(x, y int, plane byte) byte {
+	if i.At(x, y)&plane != 0 {
+		return 1
+	}
+	return 0
 }
 
 func (i *myScreen) Set(x, y int, color byte) {
-	i.buffer[y*SCREEN_WIDTH+x] = color
+	i.buffer[y*i.Width()+x] = color
 }
 
-// Toggle will toggle a pixel if color is not zero and return true if the pixel
-// was already set, otherwise do nothing.
+// Toggle will toggle the planes of a pixel set in color and return true if any
+// of them were already set.
 func (i *myScreen) Toggle(x, y int, color byte) bool {
+	w, h := i.Width(), i.Height()
 	// Loop around
-	for ; x >= 64; x -= 64 {
+	for ; x >= w; x -= w {
 	}
-	for ; x < 0; x += 64 {
+	for ; x < 0; x += w {
 	}
-	for ; y >= 32; y -= 32 {
+	for ; y >= h; y -= h {
 	}
-	for ; y < 0; y += 32 {
+	for ; y < 0; y += h {
 	}
-	a := y*SCREEN_WIDTH + x
+	a := y*w + x
 	c := i.buffer[a]
 	i.buffer[a] ^= color
 	// We only check for collisions against set pixels
-	if c != 0 && color != 0 {
+	if c&color != 0 {
 		return true
 	}
 	return false
 }
 
+// scrollFrom replaces the planes of every pixel with those of the pixel dx, dy
+// away, blanking pixels whose source is off screen. The walk order is chosen so
+// sources are read before they are overwritten.
+func (i *myScreen) scrollFrom(dx, dy int, planes byte) {
+	w, h := i.Width(), i.Height()
+	for yy := 0; yy < h; yy++ {
+		y := yy
+		if dy < 0 {
+			y = h - 1 - yy
+		}
+		for xx := 0; xx < w; xx++ {
+			x := xx
+			if dx < 0 {
+				x = w - 1 - xx
+			}
+			src := byte(0)
+			if sx, sy := x+dx, y+dy; sx >= 0 && sx < w && sy >= 0 && sy < h {
+				src = i.At(sx, sy)
+			}
+			i.Set(x, y, i.At(x, y)&^planes|src&planes)
+		}
+	}
+}
+
+// ScrollDown moves the selected planes down n rows, blanking the rows uncovered
+// at the top.
+func (i *myScreen) ScrollDown(n int, planes byte) {
+	i.scrollFrom(0, -n, planes)
+}
+
+// ScrollUp moves the selected planes up n rows, blanking the rows uncovered at
+// the bottom.
+func (i *myScreen) ScrollUp(n int, planes byte) {
+	i.scrollFrom(0, n, planes)
+}
+
+// ScrollRight moves the selected planes right n columns, blanking the columns
+// uncovered on the left.
+func (i *myScreen) ScrollRight(n int, planes byte) {
+	i.scrollFrom(-n, 0, planes)
+}
+
+// ScrollLeft moves the selected planes left n columns, blanking the columns
+// uncovered on the right.
+func (i *myScreen) ScrollLeft(n int, planes byte) {
+	i.scrollFrom(n, 0, planes)
+}
+
 func (i *myScreen) OnEachPixel(cb func(x, y int, i WriteableImage)) {
-	for y := 0; y < SCREEN_HEIGHT; y++ {
-		for x := 0; x < SCREEN_WIDTH; x++ {
+	w, h := i.Width(), i.Height()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
 			cb(x, y, i)
 		}
 	}
 }
 
+// halfBlock returns the character that draws two vertically stacked pixels in
+// one terminal cell, used by the text renderers in hi-res mode, along with the
+// pixel values to paint its foreground and background with.
+func halfBlock(top, bottom byte) (r rune, fg, bg byte) {
+	switch {
+	case top == bottom && top != 0:
+		return '█', top, 0
+	case top != 0:
+		return '▀', top, bottom
+	case bottom != 0:
+		return '▄', bottom, 0
+	default:
+		return ' ', 0, 0
+	}
+}
+
+// CellSize returns how many terminal cells the text renderers need to draw i.
+// Hi-res images pack two rows into each cell so they fit the same height.
+func CellSize(i IterableImage) (w, h int) {
+	if i.Height() > SCREEN_HEIGHT {
+		return i.Width(), i.Height() / 2
+	}
+	return i.Width(), i.Height()
+}
+
+// pixelChars are used by ImageString for each of the four pixel values.
+var pixelChars = []byte(".#+*")
+
+// ImageString draws i as text, one character per pixel, so screens can be
+// compared in tests.
+func ImageString(i IterableImage) string {
+	var b strings.Builder
+	for y := 0; y < i.Height(); y++ {
+		for x := 0; x < i.Width(); x++ {
+			b.WriteByte(pixelChars[i.At(x, y)&3])
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
 type Renderer interface {
 	Render(IterableImage)
 }
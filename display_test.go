@@ -0,0 +1,48 @@
+package chip8
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImageString(t *testing.T) {
+	rom := []byte{
+		0x60, 0x00, // LD V0, 0
+		0xF0, 0x29, // LD F, V0
+		0xD0, 0x05, // DRW V0, V0, 5
+		0x61, 0x3E, // LD V1, 62
+		0x62, 0x08, // LD V2, 8
+		0xD1, 0x25, // DRW V1, V2, 5
+	}
+	c := NewChip8(&NullDisplay{}, &NoKeypad{}, Quirks{})
+	c.Reset()
+	if err := c.LoadROM(rom); err != nil {
+		t.Fatal(err)
+	}
+	for n := 0; n < len(rom)/2; n++ {
+		if err := c.RunOne(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows := strings.Split(ImageString(c.Screen()), "\n")
+	if len(rows) != SCREEN_HEIGHT+1 || len(rows[0]) != SCREEN_WIDTH {
+		t.Fatalf("got %d rows of %d, want %d of %d", len(rows)-1, len(rows[0]), SCREEN_HEIGHT, SCREEN_WIDTH)
+	}
+	blank := strings.Repeat(".", SCREEN_WIDTH-8)
+	mid := strings.Repeat(".", SCREEN_WIDTH-4)
+	want := map[int]string{
+		0:  "####...." + blank,
+		1:  "#..#...." + blank,
+		4:  "####...." + blank,
+		5:  "........" + blank,
+		8:  "##" + mid + "##",
+		9:  ".#" + mid + "#.",
+		12: "##" + mid + "##",
+	}
+	for y, row := range want {
+		if rows[y] != row {
+			t.Errorf("row %d is\n%s, want\n%s", y, rows[y], row)
+		}
+	}
+}
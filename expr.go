@@ -0,0 +1,273 @@
+package chip8
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// exprEnv is what debugger expressions can see.
+type exprEnv struct {
+	c *Chip8
+	// hits is how many times the breakpoint being checked has been hit
+	hits int
+}
+
+// Expr is a compiled debugger expression such as `V3 == 0x10 && [I] > 2`.
+type Expr struct {
+	text string
+	eval func(env exprEnv) int
+}
+
+func (e *Expr) String() string {
+	return e.text
+}
+
+// Eval evaluates the expression against c. hits is the value of HITS.
+func (e *Expr) Eval(c *Chip8, hits int) int {
+	return e.eval(exprEnv{c, hits})
+}
+
+// ParseExpr compiles an expression. It understands V0-VF, I, PC, SP, DT, ST,
+// HITS, [addr] memory reads, numbers in any base strconv does, and C operators
+// with C precedence. Comparisons and logic evaluate to 0 or 1.
+func ParseExpr(s string) (*Expr, error) {
+	p := &exprParser{s: s}
+	p.next()
+	f, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, fmt.Errorf("unexpected %q at column %d", p.tok, p.tokPos+1)
+	}
+	return &Expr{strings.TrimSpace(s), f}, nil
+}
+
+type exprParser struct {
+	s      string
+	pos    int
+	tok    string
+	tokPos int
+}
+
+// Operators longest first so the tokenizer can match greedily.
+var exprOps = []string{
+	"&&", "||", "==", "!=", "<=", ">=", "<<", ">>",
+	"+", "-", "*", "/", "%", "&", "|", "^", "<", ">", "!", "~", "(", ")", "[", "]",
+}
+
+func (p *exprParser) next() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+	p.tokPos = p.pos
+	if p.pos >= len(p.s) {
+		p.tok = ""
+		return
+	}
+	r := rune(p.s[p.pos])
+	if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+		start := p.pos
+		for p.pos < len(p.s) {
+			r := rune(p.s[p.pos])
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+				break
+			}
+			p.pos++
+		}
+		p.tok = p.s[start:p.pos]
+		return
+	}
+	for _, op := range exprOps {
+		if strings.HasPrefix(p.s[p.pos:], op) {
+			p.tok = op
+			p.pos += len(op)
+			return
+		}
+	}
+	p.tok = string(r)
+	p.pos++
+}
+
+// Binary operators and their precedence, higher binds tighter.
+var exprPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"|":  3,
+	"^":  4,
+	"&":  5,
+	"==": 6, "!=": 6,
+	"<": 7, "<=": 7, ">": 7, ">=": 7,
+	"<<": 8, ">>": 8,
+	"+": 9, "-": 9,
+	"*": 10, "/": 10, "%": 10,
+}
+
+func exprBool(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *exprParser) parseBinary(minPrec int) (func(exprEnv) int, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.tok
+		prec, ok := exprPrecedence[op]
+		if !ok || prec <= minPrec {
+			return lhs, nil
+		}
+		p.next()
+		rhs, err := p.parseBinary(prec)
+		if err != nil {
+			return nil, err
+		}
+		l, r := lhs, rhs
+		switch op {
+		case "||":
+			lhs = func(e exprEnv) int { return exprBool(l(e) != 0 || r(e) != 0) }
+		case "&&":
+			lhs = func(e exprEnv) int { return exprBool(l(e) != 0 && r(e) != 0) }
+		case "|":
+			lhs = func(e exprEnv) int { return l(e) | r(e) }
+		case "^":
+			lhs = func(e exprEnv) int { return l(e) ^ r(e) }
+		case "&":
+			lhs = func(e exprEnv) int { return l(e) & r(e) }
+		case "==":
+			lhs = func(e exprEnv) int { return exprBool(l(e) == r(e)) }
+		case "!=":
+			lhs = func(e exprEnv) int { return exprBool(l(e) != r(e)) }
+		case "<":
+			lhs = func(e exprEnv) int { return exprBool(l(e) < r(e)) }
+		case "<=":
+			lhs = func(e exprEnv) int { return exprBool(l(e) <= r(e)) }
+		case ">":
+			lhs = func(e exprEnv) int { return exprBool(l(e) > r(e)) }
+		case ">=":
+			lhs = func(e exprEnv) int { return exprBool(l(e) >= r(e)) }
+		case "<<":
+			lhs = func(e exprEnv) int { return l(e) << uint(r(e)&63) }
+		case ">>":
+			lhs = func(e exprEnv) int { return l(e) >> uint(r(e)&63) }
+		case "+":
+			lhs = func(e exprEnv) int { return l(e) + r(e) }
+		case "-":
+			lhs = func(e exprEnv) int { return l(e) - r(e) }
+		case "*":
+			lhs = func(e exprEnv) int { return l(e) * r(e) }
+		case "/":
+			lhs = func(e exprEnv) int {
+				if d := r(e); d != 0 {
+					return l(e) / d
+				}
+				return 0
+			}
+		case "%":
+			lhs = func(e exprEnv) int {
+				if d := r(e); d != 0 {
+					return l(e) % d
+				}
+				return 0
+			}
+		}
+	}
+}
+
+func (p *exprParser) parseUnary() (func(exprEnv) int, error) {
+	switch p.tok {
+	case "-", "!", "~":
+		op := p.tok
+		p.next()
+		f, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "-":
+			return func(e exprEnv) int { return -f(e) }, nil
+		case "!":
+			return func(e exprEnv) int { return exprBool(f(e) == 0) }, nil
+		default:
+			return func(e exprEnv) int { return ^f(e) }, nil
+		}
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) expect(tok string) error {
+	if p.tok != tok {
+		if p.tok == "" {
+			return fmt.Errorf("expected %q at end of expression", tok)
+		}
+		return fmt.Errorf("expected %q at column %d, got %q", tok, p.tokPos+1, p.tok)
+	}
+	p.next()
+	return nil
+}
+
+func (p *exprParser) parsePrimary() (func(exprEnv) int, error) {
+	tok, pos := p.tok, p.tokPos
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case "(":
+		p.next()
+		f, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(")")
+	case "[":
+		p.next()
+		f, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return func(e exprEnv) int {
+			return int(e.c.mem[f(e)&(e.c.MemSize()-1)])
+		}, nil
+	}
+	p.next()
+	if v, ok := exprVariable(tok); ok {
+		return v, nil
+	}
+	n, err := strconv.ParseInt(tok, 0, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unknown value %q at column %d", tok, pos+1)
+	}
+	return func(exprEnv) int { return int(n) }, nil
+}
+
+func exprVariable(name string) (func(exprEnv) int, bool) {
+	switch strings.ToUpper(name) {
+	case "I":
+		return func(e exprEnv) int { return int(e.c.i) }, true
+	case "PC":
+		return func(e exprEnv) int { return int(e.c.pc) }, true
+	case "SP":
+		return func(e exprEnv) int { return e.c.sp }, true
+	case "DT":
+		return func(e exprEnv) int { return int(e.c.delay) }, true
+	case "ST":
+		return func(e exprEnv) int { return int(e.c.sound) }, true
+	case "HITS":
+		return func(e exprEnv) int { return e.hits }, true
+	}
+	up := strings.ToUpper(name)
+	if len(up) == 2 && up[0] == 'V' {
+		if r, err := strconv.ParseUint(up[1:], 16, 8); err == nil {
+			return func(e exprEnv) int { return int(e.c.v[r]) }, true
+		}
+	}
+	return nil, false
+}
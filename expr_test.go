@@ -0,0 +1,65 @@
+package chip8
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	c := newTestChip8(t, Quirks{})
+	c.v[3] = 0x10
+	c.v[0xA] = 7
+	c.i = 0x200
+	tests := []struct {
+		expr string
+		want int
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"16 / 4 / 2", 2},
+		{"1 << 2 + 1", 8},
+		{"1 | 2 ^ 3 & 4", 3},
+		{"1 < 2 == 1", 1},
+		{"0 || 1 && 0", 0},
+		{"-2 * -3", 6},
+		{"!0 + ~0", 0},
+		{"7 % 0 + 7 / 0", 0},
+		{"V3 == 0x10 && va > 6", 1},
+		{"[I] + [I + 1]", 0x60},
+		{"PC + SP + HITS", 0x200 + 48 + 5},
+		{"0b101 + 0o17", 20},
+	}
+	for _, tt := range tests {
+		e, err := ParseExpr(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if got := e.Eval(c, 5); got != tt.want {
+			t.Errorf("%s = %d, want %d", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"", "unexpected end of expression"},
+		{"1 +", "unexpected end of expression"},
+		{"(1 + 2", `expected ")" at end of expression`},
+		{"[I", `expected "]" at end of expression`},
+		{"(1]", `expected ")" at column 3, got "]"`},
+		{"1 2", `unexpected "2" at column 3`},
+		{"VG", `unknown value "VG" at column 1`},
+		{"1 + $", `unknown value "$" at column 5`},
+	}
+	for _, tt := range tests {
+		_, err := ParseExpr(tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: got error %v, want %q", tt.expr, err, tt.want)
+		}
+	}
+}
@@ -1,5 +1,11 @@
 package chip8
 
+// FONT_OFFSET and BIG_FONT_OFFSET are where the two fonts live in memory.
+const (
+	FONT_OFFSET     = 0x00
+	BIG_FONT_OFFSET = 0x50
+)
+
 var font = []byte{
 	0xF0, // ****
 	0x90, // *  *
@@ -97,3 +103,182 @@ var font = []byte{
 	0x80, // *
 	0x80, // *
 }
+
+// bigFont holds the SUPER-CHIP 8x10 digits, loaded right after font.
+var bigFont = []byte{
+	0xFF, // ********
+	0xFF, // ********
+	0xC3, // **    **
+	0xC3, // **    **
+	0xC3, // **    **
+	0xC3, // **    **
+	0xC3, // **    **
+	0xC3, // **    **
+	0xFF, // ********
+	0xFF, // ********
+
+	0x18, //    **
+	0x78, //  ****
+	0x78, //  ****
+	0x18, //    **
+	0x18, //    **
+	0x18, //    **
+	0x18, //    **
+	0x18, //    **
+	0xFF, // ********
+	0xFF, // ********
+
+	0xFF, // ********
+	0xFF, // ********
+	0x03, //       **
+	0x03, //       **
+	0xFF, // ********
+	0xFF, // ********
+	0xC0, // **
+	0xC0, // **
+	0xFF, // ********
+	0xFF, // ********
+
+	0xFF, // ********
+	0xFF, // ********
+	0x03, //       **
+	0x03, //       **
+	0xFF, // ********
+	0xFF, // ********
+	0x03, //       **
+	0x03, //       **
+	0xFF, // ********
+	0xFF, // ********
+
+	0xC3, // **    **
+	0xC3, // **    **
+	0xC3, // **    **
+	0xC3, // **    **
+	0xFF, // ********
+	0xFF, // ********
+	0x03, //       **
+	0x03, //       **
+	0x03, //       **
+	0x03, //       **
+
+	0xFF, // ********
+	0xFF, // ********
+	0xC0, // **
+	0xC0, // **
+	0xFF, // ********
+	0xFF, // ********
+	0x03, //       **
+	0x03, //       **
+	0xFF, // ********
+	0xFF, // ********
+
+	0xFF, // ********
+	0xFF, // ********
+	0xC0, // **
+	0xC0, // **
+	0xFF, // ********
+	0xFF, // ********
+	0xC3, // **    **
+	0xC3, // **    **
+	0xFF, // ********
+	0xFF, // ********
+
+	0xFF, // ********
+	0xFF, // ********
+	0x03, //       **
+	0x03, //       **
+	0x06, //      **
+	0x0C, //     **
+	0x18, //    **
+	0x18, //    **
+	0x18, //    **
+	0x18, //    **
+
+	0xFF, // ********
+	0xFF, // ********
+	0xC3, // **    **
+	0xC3, // **    **
+	0xFF, // ********
+	0xFF, // ********
+	0xC3, // **    **
+	0xC3, // **    **
+	0xFF, // ********
+	0xFF, // ********
+
+	0xFF, // ********
+	0xFF, // ********
+	0xC3, // **    **
+	0xC3, // **    **
+	0xFF, // ********
+	0xFF, // ********
+	0x03, //       **
+	0x03, //       **
+	0xFF, // ********
+	0xFF, // ********
+
+	0x7E, //  ******
+	0xFF, // ********
+	0xC3, // **    **
+	0xC3, // **    **
+	0xC3, // **    **
+	0xFF, // ********
+	0xFF, // ********
+	0xC3, // **    **
+	0xC3, // **    **
+	0xC3, // **    **
+
+	0xFC, // ******
+	0xFC, // ******
+	0xC3, // **    **
+	0xC3, // **    **
+	0xFC, // ******
+	0xFC, // ******
+	0xC3, // **    **
+	0xC3, // **    **
+	0xFC, // ******
+	0xFC, // ******
+
+	0x3C, //   ****
+	0xFF, // ********
+	0xC3, // **    **
+	0xC0, // **
+	0xC0, // **
+	0xC0, // **
+	0xC0, // **
+	0xC3, // **    **
+	0xFF, // ********
+	0x3C, //   ****
+
+	0xFC, // ******
+	0xFE, // *******
+	0xC3, // **    **
+	0xC3, // **    **
+	0xC3, // **    **
+	0xC3, // **    **
+	0xC3, // **    **
+	0xC3, // **    **
+	0xFE, // *******
+	0xFC, // ******
+
+	0xFF, // ********
+	0xFF, // ********
+	0xC0, // **
+	0xC0, // **
+	0xFF, // ********
+	0xFF, // ********
+	0xC0, // **
+	0xC0, // **
+	0xFF, // ********
+	0xFF, // ********
+
+	0xFF, // ********
+	0xFF, // ********
+	0xC0, // **
+	0xC0, // **
+	0xFF, // ********
+	0xFF, // ********
+	0xC0, // **
+	0xC0, // **
+	0xC0, // **
+	0xC0, // **
+}
@@ -0,0 +1,464 @@
+package chip8
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gdbTargetXML describes the registers in the order 'g' sends them. Multi-byte
+// registers are big endian, like everything else on the CHIP-8.
+const gdbTargetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.chip8.core">
+    <reg name="v0" bitsize="8" type="uint8" regnum="0"/>
+    <reg name="v1" bitsize="8" type="uint8"/>
+    <reg name="v2" bitsize="8" type="uint8"/>
+    <reg name="v3" bitsize="8" type="uint8"/>
+    <reg name="v4" bitsize="8" type="uint8"/>
+    <reg name="v5" bitsize="8" type="uint8"/>
+    <reg name="v6" bitsize="8" type="uint8"/>
+    <reg name="v7" bitsize="8" type="uint8"/>
+    <reg name="v8" bitsize="8" type="uint8"/>
+    <reg name="v9" bitsize="8" type="uint8"/>
+    <reg name="va" bitsize="8" type="uint8"/>
+    <reg name="vb" bitsize="8" type="uint8"/>
+    <reg name="vc" bitsize="8" type="uint8"/>
+    <reg name="vd" bitsize="8" type="uint8"/>
+    <reg name="ve" bitsize="8" type="uint8"/>
+    <reg name="vf" bitsize="8" type="uint8"/>
+    <reg name="i" bitsize="16" type="data_ptr"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+    <reg name="sp" bitsize="16" type="uint16"/>
+    <reg name="dt" bitsize="8" type="uint8"/>
+    <reg name="st" bitsize="8" type="uint8"/>
+  </feature>
+</target>
+`
+
+// gdbRegSizes is the size in bytes of each register in gdbTargetXML.
+var gdbRegSizes = []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1}
+
+// Signals used in stop replies.
+const (
+	gdbSigInt  = 2
+	gdbSigIll  = 4
+	gdbSigTrap = 5
+)
+
+// gdbStub serves one GDB remote serial protocol connection.
+type gdbStub struct {
+	d       *Debugger
+	conn    net.Conn
+	w       *bufio.Writer
+	packets chan string
+	// done is closed when the stub stops serving, so the reader stops
+	// waiting to hand it packets
+	done  chan struct{}
+	noAck bool
+}
+
+// gdbInterrupt is sent on the packet channel when the client sends ^C.
+const gdbInterrupt = "\x03"
+
+// ServeGDB loads the ROM and serves the GDB remote serial protocol on addr,
+// one client at a time, drawing and reading keys with r and k. Breakpoints and
+// watchpoints set from GDB are the debugger's own.
+func (d *Debugger) ServeGDB(addr string, r Renderer, k Keypad) error {
+	if err := d.Attach(r, k); err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		newGDBStub(d, conn).serveConn()
+	}
+}
+
+func newGDBStub(d *Debugger, conn net.Conn) *gdbStub {
+	return &gdbStub{
+		d:       d,
+		conn:    conn,
+		w:       bufio.NewWriter(conn),
+		packets: make(chan string),
+		done:    make(chan struct{}),
+	}
+}
+
+// serveConn serves the client until it kills or detaches from the target,
+// or disconnects, and then closes the connection.
+func (s *gdbStub) serveConn() {
+	go s.readPackets()
+	s.serve()
+	close(s.done)
+	s.conn.Close()
+}
+
+// deliver hands a packet to serve, reporting false if it has stopped.
+func (s *gdbStub) deliver(pkt string) bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	select {
+	case s.packets <- pkt:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// readPackets decodes packets from the client, acknowledging them, until the
+// connection closes.
+func (s *gdbStub) readPackets() {
+	defer close(s.packets)
+	r := bufio.NewReader(s.conn)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case 0x03:
+			if !s.deliver(gdbInterrupt) {
+				return
+			}
+			continue
+		case '$':
+		default:
+			// Acks, nacks and noise
+			continue
+		}
+		data, err := r.ReadString('#')
+		if err != nil {
+			return
+		}
+		data = data[:len(data)-1]
+		var sum [2]byte
+		if _, err := r.Read(sum[:1]); err != nil {
+			return
+		}
+		if _, err := r.Read(sum[1:]); err != nil {
+			return
+		}
+		want, _ := strconv.ParseUint(string(sum[:]), 16, 8)
+		if !s.noAck {
+			if byte(want) != gdbChecksum(data) {
+				s.conn.Write([]byte("-"))
+				continue
+			}
+			s.conn.Write([]byte("+"))
+		}
+		if !s.deliver(data) {
+			return
+		}
+	}
+}
+
+func gdbChecksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+func (s *gdbStub) send(data string) {
+	fmt.Fprintf(s.w, "$%s#%02x", data, gdbChecksum(data))
+	s.w.Flush()
+}
+
+func (s *gdbStub) serve() {
+	for pkt := range s.packets {
+		if pkt == gdbInterrupt {
+			s.send(s.stopReply(gdbSigInt))
+			continue
+		}
+		reply, quit := s.handle(pkt)
+		s.send(reply)
+		if quit {
+			return
+		}
+	}
+}
+
+func (s *gdbStub) stopReply(sig int) string {
+	return fmt.Sprintf("S%02x", sig)
+}
+
+// handle answers a single packet. Unsupported packets get an empty reply.
+func (s *gdbStub) handle(pkt string) (reply string, quit bool) {
+	if pkt == "" {
+		return "", false
+	}
+	c := s.d.c
+	switch pkt[0] {
+	case '?':
+		return s.stopReply(gdbSigTrap), false
+	case 'g':
+		return hex.EncodeToString(s.registers()), false
+	case 'G':
+		regs, err := hex.DecodeString(pkt[1:])
+		if err != nil || len(regs) != len(s.registers()) || s.setRegisters(regs) != nil {
+			return "E01", false
+		}
+		return "OK", false
+	case 'p':
+		n, err := strconv.ParseUint(pkt[1:], 16, 8)
+		if err != nil || int(n) >= len(gdbRegSizes) {
+			return "E01", false
+		}
+		off := gdbRegOffset(int(n))
+		return hex.EncodeToString(s.registers()[off : off+gdbRegSizes[n]]), false
+	case 'P':
+		parts := strings.SplitN(pkt[1:], "=", 2)
+		if len(parts) != 2 {
+			return "E01", false
+		}
+		n, err := strconv.ParseUint(parts[0], 16, 8)
+		val, err2 := hex.DecodeString(parts[1])
+		if err != nil || err2 != nil || int(n) >= len(gdbRegSizes) || len(val) != gdbRegSizes[n] {
+			return "E01", false
+		}
+		regs := s.registers()
+		copy(regs[gdbRegOffset(int(n)):], val)
+		if s.setRegisters(regs) != nil {
+			return "E01", false
+		}
+		return "OK", false
+	case 'm':
+		addr, length, ok := s.parseAddrLen(pkt[1:])
+		if !ok {
+			return "E01", false
+		}
+		return hex.EncodeToString(c.mem[addr : addr+length]), false
+	case 'M':
+		parts := strings.SplitN(pkt[1:], ":", 2)
+		if len(parts) != 2 {
+			return "E01", false
+		}
+		addr, length, ok := s.parseAddrLen(parts[0])
+		data, err := hex.DecodeString(parts[1])
+		if !ok || err != nil || len(data) != length {
+			return "E01", false
+		}
+		copy(c.mem[addr:], data)
+		return "OK", false
+	case 's':
+		return s.step(), false
+	case 'c':
+		return s.cont(), false
+	case 'Z', 'z':
+		return s.breakpoint(pkt[0] == 'Z', pkt[1:]), false
+	case 'k':
+		return "", true
+	case 'D':
+		return "OK", true
+	case 'H':
+		return "OK", false
+	case 'T':
+		return "OK", false
+	case 'q':
+		return s.query(pkt), false
+	case 'Q':
+		if pkt == "QStartNoAckMode" {
+			s.noAck = true
+			return "OK", false
+		}
+	}
+	return "", false
+}
+
+func (s *gdbStub) query(pkt string) string {
+	switch {
+	case strings.HasPrefix(pkt, "qSupported"):
+		return "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+;swbreak+"
+	case pkt == "qAttached":
+		return "1"
+	case pkt == "qC":
+		return "QC1"
+	case pkt == "qfThreadInfo":
+		return "m1"
+	case pkt == "qsThreadInfo":
+		return "l"
+	case strings.HasPrefix(pkt, "qXfer:features:read:target.xml:"):
+		var off, length int
+		if _, err := fmt.Sscanf(pkt[len("qXfer:features:read:target.xml:"):], "%x,%x", &off, &length); err != nil {
+			return "E01"
+		}
+		if off >= len(gdbTargetXML) {
+			return "l"
+		}
+		end := off + length
+		if end >= len(gdbTargetXML) {
+			return "l" + gdbTargetXML[off:]
+		}
+		return "m" + gdbTargetXML[off:end]
+	}
+	return ""
+}
+
+func gdbRegOffset(n int) int {
+	off := 0
+	for _, size := range gdbRegSizes[:n] {
+		off += size
+	}
+	return off
+}
+
+func (s *gdbStub) registers() []byte {
+	c := s.d.c
+	regs := make([]byte, 0, gdbRegOffset(len(gdbRegSizes)))
+	regs = append(regs, c.v[:]...)
+	regs = append(regs, byte(c.i>>8), byte(c.i))
+	regs = append(regs, byte(c.pc>>8), byte(c.pc))
+	regs = append(regs, byte(c.sp>>8), byte(c.sp))
+	regs = append(regs, c.delay, c.sound)
+	return regs
+}
+
+// setRegisters sets the registers from a 'G' packet's data, leaving them
+// alone if SP or PC are out of range.
+func (s *gdbStub) setRegisters(regs []byte) error {
+	c := s.d.c
+	pc := uint16(regs[18])<<8 | uint16(regs[19])
+	sp := int(regs[20])<<8 | int(regs[21])
+	if sp > 2*len(c.stack) || sp%2 != 0 {
+		return fmt.Errorf("SP must be even and from 0 to %d", 2*len(c.stack))
+	}
+	if int(pc) >= c.MemSize() {
+		return fmt.Errorf("PC 0x%04X is outside memory", pc)
+	}
+	copy(c.v[:], regs[:16])
+	c.i = uint16(regs[16])<<8 | uint16(regs[17])
+	c.pc = pc
+	c.sp = sp
+	c.delay = regs[22]
+	c.sound = regs[23]
+	return nil
+}
+
+// parseAddrLen parses "ADDR,LEN", clamping the range to memory.
+func (s *gdbStub) parseAddrLen(arg string) (int, int, bool) {
+	var addr, length int
+	if _, err := fmt.Sscanf(arg, "%x,%x", &addr, &length); err != nil {
+		return 0, 0, false
+	}
+	size := s.d.c.MemSize()
+	if addr < 0 || addr >= size || length < 0 {
+		return 0, 0, false
+	}
+	if addr+length > size {
+		length = size - addr
+	}
+	return addr, length, true
+}
+
+// breakpoint handles Z and z packets, mapping them onto the debugger's
+// breakpoints and watchpoints.
+func (s *gdbStub) breakpoint(insert bool, arg string) string {
+	d := s.d
+	var kind, addr, length int
+	if _, err := fmt.Sscanf(arg, "%d,%x,%x", &kind, &addr, &length); err != nil {
+		return "E01"
+	}
+	if addr >= d.c.MemSize() {
+		return "E01"
+	}
+	var access MemAccessKind
+	switch kind {
+	case 0, 1:
+		if insert {
+			d.AddBreakpoint(uint16(addr), nil, false)
+		} else {
+			d.RemoveBreakpoint(uint16(addr), false)
+		}
+		return "OK"
+	case 2:
+		access = MemWrite
+	case 3:
+		access = MemRead | MemFetch
+	case 4:
+		access = MemRead | MemWrite | MemFetch
+	default:
+		return ""
+	}
+	if length < 1 {
+		length = 1
+	}
+	if insert {
+		d.AddWatchpoint(addr, addr+length, access)
+		return "OK"
+	}
+	for _, w := range d.Watchpoints() {
+		if w.Start == addr && w.End == addr+length && w.Kind == access {
+			d.RemoveWatchpoint(w.ID)
+			return "OK"
+		}
+	}
+	return "E01"
+}
+
+// stopReplyFor describes why execution stopped.
+func (s *gdbStub) stopReplyFor(stop Stop) string {
+	switch stop.Reason {
+	case StopExited:
+		return "W00"
+	case StopError:
+		return s.stopReply(gdbSigIll)
+	case StopBreakpoint, StopTempBreakpoint:
+		return fmt.Sprintf("T%02xswbreak:;", gdbSigTrap)
+	case StopWatchpoint:
+		kind := "awatch"
+		switch s.d.wps[stop.Watchpoint].Kind {
+		case MemWrite:
+			kind = "watch"
+		case MemRead | MemFetch:
+			kind = "rwatch"
+		}
+		return fmt.Sprintf("T%02x%s:%x;", gdbSigTrap, kind, stop.Access.Addr)
+	}
+	return s.stopReply(gdbSigTrap)
+}
+
+func (s *gdbStub) step() string {
+	stop, _ := s.d.step()
+	return s.stopReplyFor(stop)
+}
+
+// cont runs at the same pace as the debugger until a breakpoint, watchpoint or
+// interrupt from the client.
+func (s *gdbStub) cont() string {
+	tick := time.NewTicker(2 * time.Millisecond)
+	defer tick.Stop()
+	leaving := true // So we can continue through a breakpoint
+	for {
+		select {
+		case pkt, ok := <-s.packets:
+			if !ok || pkt == gdbInterrupt {
+				return s.stopReply(gdbSigInt)
+			}
+			// Nothing else is valid while running
+			continue
+		case <-tick.C:
+		}
+		if stop, ok := s.d.advance(leaving); ok {
+			return s.stopReplyFor(stop)
+		}
+		leaving = false
+	}
+}
@@ -0,0 +1,127 @@
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gdbClient speaks the remote serial protocol to a stub over a pipe.
+type gdbClient struct {
+	t       *testing.T
+	conn    net.Conn
+	replies chan string
+}
+
+func newGDBClient(t *testing.T, conn net.Conn) *gdbClient {
+	c := &gdbClient{t, conn, make(chan string, 16)}
+	go func() {
+		defer close(c.replies)
+		r := bufio.NewReader(conn)
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			if b != '$' {
+				// Acks
+				continue
+			}
+			data, err := r.ReadString('#')
+			if err != nil {
+				return
+			}
+			r.ReadByte()
+			r.ReadByte()
+			c.replies <- data[:len(data)-1]
+		}
+	}()
+	return c
+}
+
+func (c *gdbClient) write(pkts ...string) {
+	var b strings.Builder
+	for _, pkt := range pkts {
+		fmt.Fprintf(&b, "$%s#%02x", pkt, gdbChecksum(pkt))
+	}
+	if _, err := c.conn.Write([]byte(b.String())); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *gdbClient) reply() string {
+	select {
+	case r := <-c.replies:
+		return r
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("no reply")
+	}
+	return ""
+}
+
+func TestGDBPackets(t *testing.T) {
+	d := newTestDebugger(t, testROM, &syncBuffer{})
+	server, client := net.Pipe()
+	s := newGDBStub(d, server)
+	served := make(chan bool)
+	go func() {
+		s.serveConn()
+		close(served)
+	}()
+	c := newGDBClient(t, client)
+
+	regs := strings.Repeat("00", 16) + "0000" + "0200" + "0030" + "0000"
+	tests := []struct {
+		pkt, want string
+	}{
+		{"?", "S05"},
+		{"g", regs},
+		{"P00=2a", "OK"},
+		{"p0", "2a"},
+		// SP past the stack, odd, and PC outside memory
+		{"P12=0032", "E01"},
+		{"P12=0003", "E01"},
+		{"P11=1000", "E01"},
+		{"G" + strings.Repeat("00", 16) + "0000" + "0200" + "0032" + "0000", "E01"},
+		{"p12", "0030"},
+		{"G01" + regs[2:], "OK"},
+		{"g", "01" + regs[2:]},
+		{"M300,2:abcd", "OK"},
+		{"m300,2", "abcd"},
+		{"m1000,1", "E01"},
+		{"Z0,206,2", "OK"},
+		{"c", "T05swbreak:;"},
+		{"p11", "0206"},
+		{"s", "S05"},
+		{"p11", "0208"},
+		{"z0,206,2", "OK"},
+		{"Z2,300,1", "OK"},
+		{"z2,300,1", "OK"},
+		{"z2,300,1", "E01"},
+	}
+	for _, tt := range tests {
+		c.write(tt.pkt)
+		if got := c.reply(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.pkt, got, tt.want)
+		}
+	}
+	if _, ok := d.Breakpoint(0x206, false); ok {
+		t.Error("z0 left the breakpoint")
+	}
+
+	// A packet after the kill mustn't leave the reader waiting to hand it
+	// over
+	c.write("k", "?")
+	<-served
+	select {
+	case _, ok := <-s.packets:
+		if ok {
+			t.Error("packet delivered after the kill")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the reader didn't stop after the kill")
+	}
+}
@@ -1,6 +1,8 @@
 package chip8
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jroimartin/gocui"
@@ -78,16 +80,36 @@ func NewGocuiRenderer(view *gocui.View) *gocuiRenderer {
 	return &gocuiRenderer{view}
 }
 
-func (d *gocuiRenderer) Render(i IterableImage) {
-	i.OnEachPixel(func(x, y int, i WriteableImage) {
-		d.SetCursor(x, y)
-		d.EditDelete(false)
+// ANSI colors for each of the four XO-CHIP pixel values.
+var (
+	gocuiFgColors = [4]int{39, 37, 33, 31}
+	gocuiBgColors = [4]int{49, 47, 43, 41}
+)
 
-		c := i.At(x, y)
-		if c != 0 {
-			d.EditWrite('\u2588')
-		} else {
-			d.EditWrite(' ')
+// Render redraws the whole view. The view is resized by the layout, so we
+// write whole lines rather than placing the cursor on each pixel.
+func (d *gocuiRenderer) Render(i IterableImage) {
+	w, h := CellSize(i)
+	hires := h != i.Height()
+	var b strings.Builder
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var r rune
+			var fg, bg byte
+			if hires {
+				r, fg, bg = halfBlock(i.At(x, 2*y), i.At(x, 2*y+1))
+			} else {
+				r, fg, bg = halfBlock(i.At(x, y), i.At(x, y))
+			}
+			// Only plane one on a blank background is drawn uncolored
+			if fg <= 1 && bg == 0 {
+				b.WriteRune(r)
+			} else {
+				fmt.Fprintf(&b, "\x1b[%d;%dm%c\x1b[0m", gocuiFgColors[fg], gocuiBgColors[bg], r)
+			}
 		}
-	})
+		b.WriteRune('\n')
+	}
+	d.Clear()
+	d.Write([]byte(b.String()))
 }
@@ -1,17 +1,58 @@
 package chip8
 
-import (
-	"math/rand"
-)
+import "encoding/binary"
 
 // Opcode0NNN is an ignored opcode.
 func (c *Chip8) Opcode0NNN(ins uint16) {
 	panic("'CALL RCA1802' unimplemented")
 }
 
-// Opcode00E0 clears the screen.
+// Opcode00E0 clears the selected planes of the screen.
 func (c *Chip8) Opcode00E0(ins uint16) {
-	c.screen.OnEachPixel(ClearPixel)
+	c.screen.OnEachPixel(func(x, y int, i WriteableImage) {
+		i.Set(x, y, i.At(x, y)&^c.planes)
+	})
+	c.RenderFlag = true
+}
+
+// Opcode00CN scrolls the screen down N rows.
+func (c *Chip8) Opcode00CN(ins uint16) {
+	c.screen.ScrollDown(int(ArgN(ins)), c.planes)
+	c.RenderFlag = true
+}
+
+// Opcode00DN scrolls the screen up N rows.
+func (c *Chip8) Opcode00DN(ins uint16) {
+	c.screen.ScrollUp(int(ArgN(ins)), c.planes)
+	c.RenderFlag = true
+}
+
+// Opcode00FB scrolls the screen right 4 columns.
+func (c *Chip8) Opcode00FB(ins uint16) {
+	c.screen.ScrollRight(4, c.planes)
+	c.RenderFlag = true
+}
+
+// Opcode00FC scrolls the screen left 4 columns.
+func (c *Chip8) Opcode00FC(ins uint16) {
+	c.screen.ScrollLeft(4, c.planes)
+	c.RenderFlag = true
+}
+
+// Opcode00FD exits the interpreter.
+func (c *Chip8) Opcode00FD(ins uint16) {
+	c.exited = true
+}
+
+// Opcode00FE switches to the 64x32 low resolution mode.
+func (c *Chip8) Opcode00FE(ins uint16) {
+	c.screen.SetHires(false)
+	c.RenderFlag = true
+}
+
+// Opcode00FF switches to the 128x64 high resolution mode.
+func (c *Chip8) Opcode00FF(ins uint16) {
+	c.screen.SetHires(true)
 	c.RenderFlag = true
 }
 
@@ -39,21 +80,53 @@ func (c *Chip8) Opcode2NNN(ins uint16) {
 // Opcode3XNN skips the next instruction if Vx equals NN.
 func (c *Chip8) Opcode3XNN(ins uint16) {
 	if c.v[ArgX(ins)] == ArgNN(ins) {
-		c.pc += 2
+		c.skip()
 	}
 }
 
 // Opcode3XNN skips the next instruction if Vx does not equal NN.
 func (c *Chip8) Opcode4XNN(ins uint16) {
 	if c.v[ArgX(ins)] != ArgNN(ins) {
-		c.pc += 2
+		c.skip()
 	}
 }
 
 // Opcode3XNN skips the next instruction if Vx equals Vy.
 func (c *Chip8) Opcode5XY0(ins uint16) {
 	if c.v[ArgX(ins)] == c.v[ArgY(ins)] {
-		c.pc += 2
+		c.skip()
+	}
+}
+
+// Opcode5XY2 stores Vx to Vy inclusive in memory starting at address I. The
+// range may run backwards and I is left untouched.
+func (c *Chip8) Opcode5XY2(ins uint16) {
+	x, y := int(ArgX(ins)), int(ArgY(ins))
+	step := 1
+	if x > y {
+		step = -1
+	}
+	for r, n := x, 0; ; r, n = r+step, n+1 {
+		c.write(c.i+uint16(n), c.v[r])
+		if r == y {
+			break
+		}
+	}
+}
+
+// Opcode5XY3 loads Vx to Vy inclusive from memory starting at address I. The
+// range may run backwards and I is left untouched.
+func (c *Chip8) Opcode5XY3(ins uint16) {
+	x, y := int(ArgX(ins)), int(ArgY(ins))
+	step := 1
+	if x > y {
+		step = -1
+	}
+	for r, n := x, 0; ; r, n = r+step, n+1 {
+		c.v[r] = c.read(c.i + uint16(n))
+		if r == y {
+			break
+		}
 	}
 }
 
@@ -75,16 +148,25 @@ func (c *Chip8) Opcode8XY0(ins uint16) {
 // Opcode8XY1 sets Vx to Vx | Vy.
 func (c *Chip8) Opcode8XY1(ins uint16) {
 	c.v[ArgX(ins)] |= c.v[ArgY(ins)]
+	if c.quirks.VFReset {
+		c.v[VF] = 0
+	}
 }
 
 // Opcode8XY2 sets Vx to Vx & Vy.
 func (c *Chip8) Opcode8XY2(ins uint16) {
 	c.v[ArgX(ins)] &= c.v[ArgY(ins)]
+	if c.quirks.VFReset {
+		c.v[VF] = 0
+	}
 }
 
 // Opcode8XY3 sets Vx to Vx ^ Vy.
 func (c *Chip8) Opcode8XY3(ins uint16) {
 	c.v[ArgX(ins)] ^= c.v[ArgY(ins)]
+	if c.quirks.VFReset {
+		c.v[VF] = 0
+	}
 }
 
 // Opcode8XY4 adds Vy to Vx and sets VF to 1 when there's a carry.
@@ -111,40 +193,47 @@ func (c *Chip8) Opcode8XY5(ins uint16) {
 	}
 }
 
-// Opcode8XY6 shifts Vx right by one and stores the result. VF is set to the
-// value of the least significant bit of Vy before the shift.
+// Opcode8XY6 shifts Vy right by one and stores the result in Vx. VF is set to
+// the value of the least significant bit before the shift. With the ShiftVx
+// quirk Vx is shifted in place instead.
 func (c *Chip8) Opcode8XY6(ins uint16) {
-	lsb := c.v[ArgX(ins)] & 1
-	c.v[ArgX(ins)] = c.v[ArgY(ins)] >> 1
-	c.v[VF] = lsb
+	src := c.v[ArgY(ins)]
+	if c.quirks.ShiftVx {
+		src = c.v[ArgX(ins)]
+	}
+	c.v[ArgX(ins)] = src >> 1
+	c.v[VF] = src & 1
 }
 
-// Opcode8XY7 sets Vx to Vy minus Vx and sets VF when there's a borrow.
+// Opcode8XY7 sets Vx to Vy minus Vx and clears VF when there's a borrow.
 func (c *Chip8) Opcode8XY7(ins uint16) {
 	y := c.v[ArgY(ins)]
 	x := c.v[ArgX(ins)]
-	c.v[ArgX(ins)] = x - y
+	c.v[ArgX(ins)] = y - x
 	// Borrow
-	if y > x {
-		c.v[VF] = 1
-	} else {
+	if x > y {
 		c.v[VF] = 0
+	} else {
+		c.v[VF] = 1
 	}
 }
 
-// Opcode8XYE shifts Vx left by one and stores the result. VF is set to the
-// value of the most significant bit of Vy before the shift.
+// Opcode8XYE shifts Vy left by one and stores the result in Vx. VF is set to
+// the value of the most significant bit before the shift. With the ShiftVx
+// quirk Vx is shifted in place instead.
 func (c *Chip8) Opcode8XYE(ins uint16) {
-	msb := (c.v[ArgX(ins)] >> 7) & 1
-	c.v[ArgX(ins)] = c.v[ArgX(ins)] << 1
-
-	c.v[VF] = msb
+	src := c.v[ArgY(ins)]
+	if c.quirks.ShiftVx {
+		src = c.v[ArgX(ins)]
+	}
+	c.v[ArgX(ins)] = src << 1
+	c.v[VF] = (src >> 7) & 1
 }
 
 // Opcode9XY0 skips the next instruction if Vx doesn't equal Vy.
 func (c *Chip8) Opcode9XY0(ins uint16) {
 	if c.v[ArgX(ins)] != c.v[ArgY(ins)] {
-		c.pc += 2
+		c.skip()
 	}
 }
 
@@ -153,34 +242,72 @@ func (c *Chip8) OpcodeANNN(ins uint16) {
 	c.i = ArgNNN(ins)
 }
 
-// OpcodeBNNN Jumps to dhe address NNN  plus V0.
+// OpcodeBNNN Jumps to dhe address NNN  plus V0. With the JumpVx quirk it
+// jumps to XNN plus Vx instead.
 func (c *Chip8) OpcodeBNNN(ins uint16) {
+	offset := c.v[V0]
+	if c.quirks.JumpVx {
+		offset = c.v[ArgX(ins)]
+	}
 	// TODO: Want to skip the +=2 at the end of the loop
-	c.pc = (uint16(c.v[V0]) + ArgNNN(ins) - 2) & 0xFFF
+	c.pc = (uint16(offset) + ArgNNN(ins) - 2) & 0xFFF
 }
 
 // OpcodeCXNN sets Vx to the result of rand()&NN.
 func (c *Chip8) OpcodeCXNN(ins uint16) {
-	c.v[ArgX(ins)] = uint8(rand.Uint32()) & ArgNN(ins)
+	c.v[ArgX(ins)] = uint8(c.r.Uint32()) & ArgNN(ins)
 }
 
-// OpcodeDXYN draws a sprite I to Vx, Vy with width 8 height N.
+// OpcodeDXYN draws a sprite I to Vx, Vy with width 8 height N. The starting
+// position always wraps, while the sprite itself wraps or clips at the edges
+// depending on the Clip quirk.
 func (c *Chip8) OpcodeDXYN(ins uint16) {
-	x := c.v[ArgX(ins)]
-	y := c.v[ArgY(ins)]
-	height := ArgN(ins)
+	c.drawSprite(c.v[ArgX(ins)], c.v[ArgY(ins)], 8, int(ArgN(ins)))
+}
+
+// OpcodeDXY0 draws a 16x16 sprite I to Vx, Vy, two bytes per row.
+func (c *Chip8) OpcodeDXY0(ins uint16) {
+	c.drawSprite(c.v[ArgX(ins)], c.v[ArgY(ins)], 16, 16)
+}
+
+// drawSprite XORs a sprite from I onto each selected plane, most significant
+// bit leftmost, and sets VF on collision. When both planes are selected the
+// data for the second plane follows that of the first.
+func (c *Chip8) drawSprite(vx, vy uint8, width, height int) {
+	w, h := c.screen.Width(), c.screen.Height()
+	x := int(vx) % w
+	y := int(vy) % h
+	addr := c.i
 	collision := false
-	for j := uint8(0); j < height; j++ {
-		row := c.mem[c.i+uint16(j)]
-		for i := uint8(0); i < 8; i++ {
-			color := byte(0)
-			if (row & 0x80) == 0x80 {
-				color = 1
+	for plane := byte(1); plane <= 2; plane <<= 1 {
+		if c.planes&plane == 0 {
+			continue
+		}
+		for j := 0; j < height; j++ {
+			var row uint16
+			if width == 16 {
+				row = c.read16(addr)
+				addr += 2
+			} else {
+				row = uint16(c.read(addr)) << 8
+				addr++
 			}
-			if c.screen.Toggle(int(x+i), int(y+j), color) {
-				collision = true
+			if c.quirks.Clip && y+j >= h {
+				continue
+			}
+			for i := 0; i < width; i++ {
+				if c.quirks.Clip && x+i >= w {
+					break
+				}
+				color := byte(0)
+				if (row & 0x8000) == 0x8000 {
+					color = plane
+				}
+				if c.screen.Toggle(x+i, y+j, color) {
+					collision = true
+				}
+				row <<= 1
 			}
-			row <<= 1
 		}
 	}
 	if collision {
@@ -194,14 +321,33 @@ func (c *Chip8) OpcodeDXYN(ins uint16) {
 // OpcodeEX9E skips the next instruction if key Vx is pressed.
 func (c *Chip8) OpcodeEX9E(ins uint16) {
 	if c.keypad.Pressed(c.v[ArgX(ins)]) {
-		c.pc += 2
+		c.skip()
 	}
 }
 
 // OpcodeEXA1 skips the next instruction if key Vx is not pressed.
 func (c *Chip8) OpcodeEXA1(ins uint16) {
 	if !c.keypad.Pressed(c.v[ArgX(ins)]) {
-		c.pc += 2
+		c.skip()
+	}
+}
+
+// OpcodeF000 sets I to the 16 bit address in the following word.
+func (c *Chip8) OpcodeF000(ins uint16) {
+	c.i = c.fetch(c.pc + 2)
+	c.pc += 2
+}
+
+// OpcodeFN01 selects the bitplanes N that drawing, clearing and scrolling
+// affect.
+func (c *Chip8) OpcodeFN01(ins uint16) {
+	c.planes = ArgX(ins) & 0x3
+}
+
+// OpcodeF002 loads the 16 byte audio pattern buffer from memory at I.
+func (c *Chip8) OpcodeF002(ins uint16) {
+	for n := range c.pattern {
+		c.pattern[n] = c.read(c.i + uint16(n))
 	}
 }
 
@@ -227,38 +373,83 @@ func (c *Chip8) OpcodeFX18(ins uint16) {
 
 // OpcodeFX1E adds Vx to I.
 func (c *Chip8) OpcodeFX1E(ins uint16) {
-	c.i = (c.i + uint16(c.v[ArgX(ins)])) & 0xFFF
+	c.i = (c.i + uint16(c.v[ArgX(ins)])) & c.addrMask()
 }
 
 // OpcodeFX29 sets I to point to sprite for digit Vx.
 func (c *Chip8) OpcodeFX29(ins uint16) {
-	c.i = 5 * uint16(c.v[ArgX(ins)])
+	c.i = FONT_OFFSET + 5*uint16(c.v[ArgX(ins)]&0xF)
+}
+
+// OpcodeFX30 sets I to point to the 8x10 sprite for digit Vx.
+func (c *Chip8) OpcodeFX30(ins uint16) {
+	c.i = BIG_FONT_OFFSET + 10*uint16(c.v[ArgX(ins)]&0xF)
+}
+
+// OpcodeFX3A sets the audio pattern playback pitch to Vx.
+func (c *Chip8) OpcodeFX3A(ins uint16) {
+	c.pitch = c.v[ArgX(ins)]
 }
 
 // OpcodeFX33 stores the BCD representation of Vx into memory at I.
 func (c *Chip8) OpcodeFX33(ins uint16) {
 	v := c.v[ArgX(ins)]
-	c.mem[c.i+2] = v % 10
+	c.write(c.i+2, v%10)
 	v /= 10
-	c.mem[c.i+1] = v % 10
+	c.write(c.i+1, v%10)
 	v /= 10
-	c.mem[c.i] = v % 10
+	c.write(c.i, v%10)
 }
 
 // OpcodeFX55 Stores V[0-X] inclusive in memory starting at address I.
 func (c *Chip8) OpcodeFX55(ins uint16) {
 	x := ArgX(ins)
 	for r := uint8(0); r <= x; r++ {
-		c.mem[c.i+uint16(r)] = c.v[r]
+		c.write(c.i+uint16(r), c.v[r])
 	}
+	c.incrementLoadStore(x)
 }
 
 // OpcodeFX65 Loads V[0-X] inclusive from memory starting at address I.
 func (c *Chip8) OpcodeFX65(ins uint16) {
 	x := ArgX(ins)
 	for r := uint8(0); r <= x; r++ {
-		c.v[r] = c.mem[c.i+uint16(r)]
+		c.v[r] = c.read(c.i + uint16(r))
+	}
+	c.incrementLoadStore(x)
+}
+
+// OpcodeFX75 Stores V[0-X] inclusive in the RPL user flags.
+func (c *Chip8) OpcodeFX75(ins uint16) {
+	x := ArgX(ins)
+	copy(c.rpl[:x+1], c.v[:x+1])
+}
+
+// OpcodeFX85 Loads V[0-X] inclusive from the RPL user flags.
+func (c *Chip8) OpcodeFX85(ins uint16) {
+	x := ArgX(ins)
+	copy(c.v[:x+1], c.rpl[:x+1])
+}
+
+// incrementLoadStore moves I after a register load or store of V[0-X]
+// according to the LoadStore quirk.
+func (c *Chip8) incrementLoadStore(x uint8) {
+	switch c.quirks.LoadStore {
+	case LoadStoreIncrement:
+		c.i = (c.i + uint16(x) + 1) & c.addrMask()
+	case LoadStoreIncrementX:
+		c.i = (c.i + uint16(x)) & c.addrMask()
+	}
+}
+
+// skip moves past the next instruction, which is two words long if it is an
+// XO-CHIP F000 NNNN. The skipped instruction isn't run, so it is looked at
+// without going through the memory hook.
+func (c *Chip8) skip() {
+	if c.quirks.XOChip && binary.BigEndian.Uint16(c.code(c.pc+2)) == 0xF000 {
+		c.pc += 2
 	}
+	c.pc += 2
 }
 
 // TODO: Return a reference we can write to
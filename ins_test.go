@@ -0,0 +1,24 @@
+package chip8
+
+import "testing"
+
+func TestOpcode8XY7(t *testing.T) {
+	tests := []struct {
+		x, y   byte
+		want   byte
+		wantVF byte
+	}{
+		{x: 0x10, y: 0x30, want: 0x20, wantVF: 1},
+		{x: 0x30, y: 0x10, want: 0xE0, wantVF: 0},
+		{x: 0x22, y: 0x22, want: 0x00, wantVF: 1},
+	}
+	for _, tt := range tests {
+		c := NewChip8(&NullDisplay{}, &NoKeypad{}, Quirks{})
+		c.v[1], c.v[2] = tt.x, tt.y
+		c.Opcode8XY7(0x8127)
+		if c.v[1] != tt.want || c.v[VF] != tt.wantVF {
+			t.Errorf("V1=0x%02X V2=0x%02X: got V1=0x%02X VF=%d, want V1=0x%02X VF=%d",
+				tt.x, tt.y, c.v[1], c.v[VF], tt.want, tt.wantVF)
+		}
+	}
+}
@@ -14,3 +14,17 @@ func (k *NoKeypad) Pressed(key uint8) bool {
 func (k *NoKeypad) WaitPress() uint8 {
 	panic("Cannot get input from NoKeypad")
 }
+
+// FixedKeypad has no keys held down, and answers every wait for a key with
+// Key straight away, so programs run without input never block.
+type FixedKeypad struct {
+	Key uint8
+}
+
+func (k *FixedKeypad) Pressed(key uint8) bool {
+	return false
+}
+
+func (k *FixedKeypad) WaitPress() uint8 {
+	return k.Key
+}
@@ -0,0 +1,354 @@
+package chip8
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Syntax is how a listing writes instructions.
+type Syntax int
+
+const (
+	// SyntaxMnemonic writes the mnemonics Disassembler prints, for the asm
+	// package.
+	SyntaxMnemonic Syntax = iota
+	// SyntaxOcto writes Octo, for the octo package.
+	SyntaxOcto
+)
+
+// SyntaxByName returns the syntax called "mnemonic" or "octo".
+func SyntaxByName(name string) (Syntax, error) {
+	switch name {
+	case "mnemonic":
+		return SyntaxMnemonic, nil
+	case "octo":
+		return SyntaxOcto, nil
+	}
+	return 0, fmt.Errorf("unknown syntax %q (have [mnemonic octo])", name)
+}
+
+// ListingOptions are what WriteListing and WriteJSON write.
+type ListingOptions struct {
+	// Start and End are the first and last addresses listed. An End of 0 is
+	// the end of the ROM.
+	Start, End uint16
+	// Raw adds the opcodes of instructions to their comments
+	Raw    bool
+	Syntax Syntax
+}
+
+// listingLine is an instruction, a sprite row or a run of data.
+type listingLine struct {
+	addr  uint16
+	kind  ByteKind
+	bytes []byte
+	// ins is set for code
+	ins *Instruction
+}
+
+// maxDataLine is how many bytes of data go on a line.
+const maxDataLine = 8
+
+// lines splits the ROM into the lines of a listing between start and end.
+func (a *Analysis) lines(opts ListingOptions) []listingLine {
+	var lines []listingLine
+	end := int(a.Base) + len(a.ROM)
+	for addr := int(a.Base); addr < end; {
+		at := uint16(addr)
+		off := addr - int(a.Base)
+		l := listingLine{addr: at, kind: a.kinds[off]}
+		if ins, ok := a.ins[at]; ok {
+			l.ins = &ins
+			l.bytes = a.ROM[off : off+ins.Length]
+		} else if l.kind == ByteSprite {
+			l.bytes = a.ROM[off : off+1]
+		} else {
+			// A run of data stops at code, labels and other kinds of byte
+			n := 1
+			for addr+n < end && n < maxDataLine {
+				next := uint16(addr + n)
+				if _, ok := a.ins[next]; ok {
+					break
+				}
+				if _, ok := a.labels[next]; ok || a.kinds[off+n] != l.kind {
+					break
+				}
+				n++
+			}
+			l.bytes = a.ROM[off : off+n]
+		}
+		addr += len(l.bytes)
+		if at >= opts.Start && (opts.End == 0 || at <= opts.End) {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+// comment returns what the comment of a line says: its address, and its
+// opcodes, the rows of a sprite or what kind of data it is.
+func (l listingLine) comment(raw bool) string {
+	s := fmt.Sprintf("0x%04X", l.addr)
+	switch {
+	case l.ins != nil && raw:
+		s += fmt.Sprintf("  %04X", l.ins.Op)
+		if l.ins.Length == 4 {
+			s += fmt.Sprintf(" %04X", l.ins.Operands[1].Value)
+		}
+	case l.ins != nil:
+	case l.kind == ByteSprite:
+		s += "  " + spriteRow(l.bytes[0])
+	default:
+		s += "  " + l.kind.String()
+	}
+	return s
+}
+
+// text writes a line in syntax.
+func (a *Analysis) text(l listingLine, syntax Syntax) string {
+	if l.ins != nil {
+		if syntax == SyntaxOcto {
+			return a.octo(*l.ins)
+		}
+		return l.ins.format(a.Label)
+	}
+	vals := make([]string, len(l.bytes))
+	for k, b := range l.bytes {
+		vals[k] = fmt.Sprintf("0x%02X", b)
+	}
+	if syntax == SyntaxOcto {
+		return strings.Join(vals, " ")
+	}
+	return "db " + strings.Join(vals, ", ")
+}
+
+// WriteListing writes the ROM as source in the chosen syntax, which assembles
+// back to the same bytes, or compiles with Octo. Code is annotated with
+// addresses, sprites are drawn a row per line, and data is written 8 bytes to
+// a line. A listing of part of the ROM starts with an org, but only
+// assembles if what it refers to is in it.
+func (a *Analysis) WriteListing(w io.Writer, opts ListingOptions) error {
+	bw := bufio.NewWriter(w)
+	comment, org, label := ";", "org", "%s:\n"
+	if opts.Syntax == SyntaxOcto {
+		comment, org, label = "#", ":org", ": %s\n"
+	}
+	how := fmt.Sprintf("following code from 0x%03X", a.Entry)
+	if a.Linear {
+		how = "decoded in order"
+	}
+	fmt.Fprintf(bw, "%s %d bytes loaded at 0x%03X, %s\n", comment, len(a.ROM), a.Base, how)
+	fmt.Fprintf(bw, "%s %d bytes of code, %d of sprites, %d of data and %d unreached\n\n", comment,
+		a.count(ByteCode), a.count(ByteSprite), a.count(ByteData), a.count(ByteUnknown))
+	lines := a.lines(opts)
+	if len(lines) > 0 && lines[0].addr != a.Base {
+		fmt.Fprintf(bw, "        %s 0x%03X\n", org, lines[0].addr)
+	}
+	main, hasMain := a.labelAddr("main")
+	for _, l := range lines {
+		text := a.text(l, opts.Syntax)
+		// Octo starts with a jump to main, which it leaves out if main is
+		// the first label
+		if opts.Syntax == SyntaxOcto && l.addr == a.Entry {
+			switch {
+			case !hasMain:
+				fmt.Fprintf(bw, label, "main")
+			case main != a.Entry && l.ins != nil && l.ins.Flow == FlowJump && l.ins.Target == main:
+				text = "# " + text
+			}
+		}
+		if name, ok := a.labels[l.addr]; ok {
+			fmt.Fprintf(bw, label, name)
+		}
+		fmt.Fprintf(bw, "        %-24s %s %s\n", text, comment, l.comment(opts.Raw))
+	}
+	return bw.Flush()
+}
+
+// jsonListing is what WriteJSON writes.
+type jsonListing struct {
+	Base   uint16     `json:"base"`
+	Entry  uint16     `json:"entry"`
+	Size   int        `json:"size"`
+	Linear bool       `json:"linear"`
+	Lines  []jsonLine `json:"lines"`
+}
+
+type jsonLine struct {
+	Addr  uint16 `json:"addr"`
+	Label string `json:"label,omitempty"`
+	Kind  string `json:"kind"`
+	// Bytes are in hex
+	Bytes    string        `json:"bytes"`
+	Text     string        `json:"text"`
+	Mnemonic string        `json:"mnemonic,omitempty"`
+	Operands []jsonOperand `json:"operands,omitempty"`
+	Flow     string        `json:"flow,omitempty"`
+	// Targets are where execution can go next
+	Targets []uint16 `json:"targets,omitempty"`
+}
+
+type jsonOperand struct {
+	Kind  string `json:"kind"`
+	Value uint16 `json:"value"`
+}
+
+// WriteJSON writes the lines WriteListing would as a JSON object, with the
+// decoded instructions and where each can go next. The text of each line is
+// in the chosen syntax.
+func (a *Analysis) WriteJSON(w io.Writer, opts ListingOptions) error {
+	out := jsonListing{Base: a.Base, Entry: a.Entry, Size: len(a.ROM), Linear: a.Linear, Lines: []jsonLine{}}
+	for _, l := range a.lines(opts) {
+		j := jsonLine{
+			Addr:  l.addr,
+			Label: a.labels[l.addr],
+			Kind:  l.kind.String(),
+			Bytes: fmt.Sprintf("%X", l.bytes),
+			Text:  a.text(l, opts.Syntax),
+		}
+		if l.ins != nil {
+			j.Mnemonic = l.ins.Mnemonic.String()
+			j.Flow = l.ins.Flow.String()
+			j.Targets = a.successors(l.addr, *l.ins)
+			for _, o := range l.ins.Operands {
+				j.Operands = append(j.Operands, jsonOperand{o.Kind.String(), o.Value})
+			}
+		}
+		out.Lines = append(out.Lines, j)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// octoFixed are the Octo statements of instructions without operands.
+var octoFixed = map[Mnemonic]string{
+	MnemonicCLS:   "clear",
+	MnemonicRET:   "return",
+	MnemonicSCR:   "scroll-right",
+	MnemonicSCL:   "scroll-left",
+	MnemonicEXIT:  "exit",
+	MnemonicLOW:   "lores",
+	MnemonicHIGH:  "hires",
+	MnemonicAUDIO: "audio",
+}
+
+// octoALU are the Octo assignments of the 8XYN instructions, but for LD.
+var octoALU = map[Mnemonic]string{
+	MnemonicADD:  "+=",
+	MnemonicOR:   "|=",
+	MnemonicAND:  "&=",
+	MnemonicXOR:  "^=",
+	MnemonicSUB:  "-=",
+	MnemonicSHR:  ">>=",
+	MnemonicSUBN: "=-",
+	MnemonicSHL:  "<<=",
+}
+
+// octoLoads are the Octo statements of LD by the kinds of its operands, with
+// %s for the register.
+var octoLoads = map[[2]OperandKind]string{
+	{OperandRegister, OperandDT}:       "%s := delay",
+	{OperandRegister, OperandK}:        "%s := key",
+	{OperandRegister, OperandIndirect}: "load %s",
+	{OperandRegister, OperandRPL}:      "loadflags %s",
+	{OperandDT, OperandRegister}:       "delay := %s",
+	{OperandST, OperandRegister}:       "buzzer := %s",
+	{OperandF, OperandRegister}:        "i := hex %s",
+	{OperandHF, OperandRegister}:       "i := bighex %s",
+	{OperandB, OperandRegister}:        "bcd %s",
+	{OperandIndirect, OperandRegister}: "save %s",
+	{OperandRPL, OperandRegister}:      "saveflags %s",
+}
+
+// octo writes an instruction as an Octo statement, with labels for the
+// addresses it refers to. Skips are written as if ... then, with the
+// condition under which the next statement runs.
+func (a *Analysis) octo(ins Instruction) string {
+	if s, ok := octoFixed[ins.Mnemonic]; ok {
+		return s
+	}
+	args := make([]string, len(ins.Operands))
+	for n, o := range ins.Operands {
+		label, ok := a.labels[o.Value]
+		switch {
+		case o.Kind == OperandAddress && ins.Mnemonic != MnemonicSYS && ok:
+			args[n] = label
+		case o.Kind == OperandRegister:
+			args[n] = fmt.Sprintf("v%x", o.Value)
+		case o.Kind == OperandNibble:
+			args[n] = fmt.Sprintf("%d", o.Value)
+		default:
+			args[n] = o.String()
+		}
+	}
+	switch ins.Mnemonic {
+	case MnemonicSCD:
+		return "scroll-down " + args[0]
+	case MnemonicSCU:
+		return "scroll-up " + args[0]
+	case MnemonicSYS:
+		return "native " + args[0]
+	case MnemonicJP:
+		if len(args) == 2 {
+			return "jump0 " + args[1]
+		}
+		return "jump " + args[0]
+	case MnemonicCALL:
+		if _, ok := a.labels[ins.Target]; ok {
+			return args[0]
+		}
+		return ":call " + args[0]
+	case MnemonicSE:
+		return fmt.Sprintf("if %s != %s then", args[0], args[1])
+	case MnemonicSNE:
+		return fmt.Sprintf("if %s == %s then", args[0], args[1])
+	case MnemonicSKP:
+		return fmt.Sprintf("if %s -key then", args[0])
+	case MnemonicSKNP:
+		return fmt.Sprintf("if %s key then", args[0])
+	case MnemonicSAVE:
+		return fmt.Sprintf("save %s - %s", args[0], args[1])
+	case MnemonicLOAD:
+		return fmt.Sprintf("load %s - %s", args[0], args[1])
+	case MnemonicRND:
+		return fmt.Sprintf("%s := random %s", args[0], args[1])
+	case MnemonicDRW:
+		return fmt.Sprintf("sprite %s %s %s", args[0], args[1], args[2])
+	case MnemonicPLANE:
+		return "plane " + args[0]
+	case MnemonicPITCH:
+		return "pitch := " + args[0]
+	case MnemonicADD:
+		if ins.Operands[0].Kind == OperandI {
+			return "i += " + args[1]
+		}
+	case MnemonicLD:
+		kinds := [2]OperandKind{ins.Operands[0].Kind, ins.Operands[1].Kind}
+		if s, ok := octoLoads[kinds]; ok {
+			if kinds[0] == OperandRegister {
+				return fmt.Sprintf(s, args[0])
+			}
+			return fmt.Sprintf(s, args[1])
+		}
+		if kinds[0] == OperandI && ins.Length == 4 {
+			return "i := long " + args[1]
+		}
+		if kinds[0] == OperandI {
+			return "i := " + args[1]
+		}
+		return fmt.Sprintf("%s := %s", args[0], args[1])
+	}
+	if op, ok := octoALU[ins.Mnemonic]; ok {
+		return fmt.Sprintf("%s %s %s", args[0], op, args[1])
+	}
+	return ins.String()
+}
+
+// spriteRow draws a byte of a sprite.
+func spriteRow(b byte) string {
+	return strings.NewReplacer("0", ".", "1", "#").Replace(fmt.Sprintf("%08b", b))
+}
@@ -0,0 +1,165 @@
+package chip8_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Grazfather/chip8"
+	"github.com/Grazfather/chip8/asm"
+	"github.com/Grazfather/chip8/octo"
+)
+
+var listingROMs = []struct {
+	name string
+	rom  []byte
+}{
+	{"sprite after JP", []byte{
+		0xA2, 0x06, // LD I, 0x206
+		0xD0, 0x15, // DRW V0, V1, 5
+		0x12, 0x04, // JP 0x204
+		0xF0, 0x90, 0x90, 0x90, 0xF0,
+	}},
+	{"code only reached by a skip", []byte{
+		0x30, 0x00, // SE V0, 0
+		0x12, 0x02, // JP 0x202
+		0x00, 0xE0, // CLS
+		0x12, 0x06, // JP 0x206
+	}},
+	{"skip over a long LD I", []byte{
+		0x30, 0x00, // SE V0, 0
+		0xF0, 0x00, 0x02, 0x0A, // LD I, LONG 0x20A
+		0xD0, 0x11, // DRW V0, V1, 1
+		0x12, 0x08, // JP 0x208
+		0xFF,
+	}},
+	{"call, table and trailing byte", []byte{
+		0x22, 0x06, // CALL 0x206
+		0x12, 0x02, // JP 0x202
+		0x12, 0x34, // unreached
+		0xA2, 0x0C, // LD I, 0x20C
+		0xF1, 0x65, // LD V1, [I]
+		0x00, 0xEE, // RET
+		0x01, 0x02, 0x03,
+	}},
+}
+
+// reassemble lists a and builds the listing back into a ROM.
+func reassemble(t *testing.T, a *chip8.Analysis, syntax chip8.Syntax) ([]byte, string) {
+	var listing bytes.Buffer
+	if err := a.WriteListing(&listing, chip8.ListingOptions{Raw: true, Syntax: syntax}); err != nil {
+		t.Fatal(err)
+	}
+	var p *asm.Program
+	var err error
+	if syntax == chip8.SyntaxOcto {
+		p, err = octo.CompileSource("listing.8o", listing.Bytes())
+	} else {
+		filename := filepath.Join(t.TempDir(), "listing.s")
+		if err := os.WriteFile(filename, listing.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		p, err = asm.Assemble(filename)
+	}
+	if err != nil {
+		t.Fatalf("%v in\n%s", err, listing.String())
+	}
+	return p.ROM, listing.String()
+}
+
+func checkRoundTrip(t *testing.T, name string, rom []byte) {
+	var d chip8.Disassembler
+	for _, a := range []*chip8.Analysis{d.Analyze(rom), d.AnalyzeLinear(rom)} {
+		for _, syntax := range []chip8.Syntax{chip8.SyntaxMnemonic, chip8.SyntaxOcto} {
+			got, listing := reassemble(t, a, syntax)
+			if !bytes.Equal(got, rom) {
+				t.Errorf("%s, linear %v, syntax %d: reassembled to\n% X, want\n% X from\n%s",
+					name, a.Linear, syntax, got, rom, listing)
+			}
+		}
+	}
+}
+
+// TestListingReassembles checks listings build back into the ROM they list.
+func TestListingReassembles(t *testing.T) {
+	for _, tt := range listingROMs {
+		checkRoundTrip(t, tt.name, tt.rom)
+	}
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 100; n++ {
+		rom := make([]byte, 2+r.Intn(64))
+		r.Read(rom)
+		checkRoundTrip(t, "random", rom)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	var d chip8.Disassembler
+	tests := []struct {
+		rom  int
+		addr uint16
+		want chip8.ByteKind
+	}{
+		{0, 0x204, chip8.ByteCode},
+		{0, 0x206, chip8.ByteSprite},
+		{0, 0x20A, chip8.ByteSprite},
+		{1, 0x204, chip8.ByteCode},
+		{2, 0x202, chip8.ByteCode},
+		{2, 0x205, chip8.ByteCode},
+		{2, 0x206, chip8.ByteCode},
+		{2, 0x20A, chip8.ByteSprite},
+		{3, 0x204, chip8.ByteUnknown},
+		{3, 0x20C, chip8.ByteData},
+		{3, 0x20D, chip8.ByteData},
+		{3, 0x20E, chip8.ByteUnknown},
+	}
+	for _, tt := range tests {
+		a := d.Analyze(listingROMs[tt.rom].rom)
+		if got := a.Kind(tt.addr); got != tt.want {
+			t.Errorf("%s: 0x%X is %v, want %v", listingROMs[tt.rom].name, tt.addr, got, tt.want)
+		}
+	}
+	a := d.Analyze(listingROMs[3].rom)
+	for addr, want := range map[uint16]string{0x206: "sub_206", 0x202: "loc_202", 0x20C: "data_20C"} {
+		if got, _ := a.Label(addr); got != want {
+			t.Errorf("0x%X is labelled %q, want %q", addr, got, want)
+		}
+	}
+	// Linearly, the unreached word is code
+	if k := d.AnalyzeLinear(listingROMs[3].rom).Kind(0x204); k != chip8.ByteCode {
+		t.Errorf("linearly 0x204 is %v, want code", k)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var d chip8.Disassembler
+	a := d.Analyze(listingROMs[2].rom)
+	var b bytes.Buffer
+	if err := a.WriteJSON(&b, chip8.ListingOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var listing struct {
+		Size  int `json:"size"`
+		Lines []struct {
+			Addr     uint16   `json:"addr"`
+			Kind     string   `json:"kind"`
+			Bytes    string   `json:"bytes"`
+			Mnemonic string   `json:"mnemonic"`
+			Flow     string   `json:"flow"`
+			Targets  []uint16 `json:"targets"`
+		} `json:"lines"`
+	}
+	if err := json.Unmarshal(b.Bytes(), &listing); err != nil {
+		t.Fatal(err)
+	}
+	if listing.Size != len(listingROMs[2].rom) || len(listing.Lines) == 0 {
+		t.Fatalf("got %+v", listing)
+	}
+	skip := listing.Lines[0]
+	if skip.Kind != "code" || skip.Flow != "skip" || len(skip.Targets) != 2 || skip.Targets[1] != 0x206 {
+		t.Errorf("the skip is %+v, want code skipping to 0x202 or 0x206", skip)
+	}
+}
@@ -0,0 +1,92 @@
+package chip8
+
+// MemAccessKind says how an instruction touched memory.
+type MemAccessKind int
+
+const (
+	MemRead MemAccessKind = 1 << iota
+	MemWrite
+	// MemFetch is reading an instruction.
+	MemFetch
+)
+
+func (k MemAccessKind) String() string {
+	switch k {
+	case MemRead:
+		return "read"
+	case MemWrite:
+		return "write"
+	case MemFetch:
+		return "fetch"
+	default:
+		return "access"
+	}
+}
+
+// MemAccess describes a single byte read or written by the instruction at PC.
+// For reads Old and New are the same.
+type MemAccess struct {
+	Kind MemAccessKind
+	Addr uint16
+	Old  byte
+	New  byte
+	PC   uint16
+}
+
+// MemHook is called for every memory access made while running instructions.
+type MemHook func(MemAccess)
+
+// SetMemHook installs hook to observe memory accesses, or removes it if nil.
+func (c *Chip8) SetMemHook(hook MemHook) {
+	c.memHook = hook
+}
+
+// read reads a byte of data, wrapping around the memory of the current mode
+// like every access.
+func (c *Chip8) read(addr uint16) byte {
+	addr &= c.addrMask()
+	v := c.mem[addr]
+	if c.memHook != nil {
+		c.memHook(MemAccess{MemRead, addr, v, v, c.pc})
+	}
+	return v
+}
+
+func (c *Chip8) write(addr uint16, v byte) {
+	addr &= c.addrMask()
+	old := c.mem[addr]
+	c.mem[addr] = v
+	if c.memHook != nil {
+		c.memHook(MemAccess{MemWrite, addr, old, v, c.pc})
+	}
+}
+
+// fetch reads the instruction word at addr.
+func (c *Chip8) fetch(addr uint16) uint16 {
+	mask := c.addrMask()
+	addr &= mask
+	next := (addr + 1) & mask
+	hi, lo := c.mem[addr], c.mem[next]
+	if c.memHook != nil {
+		c.memHook(MemAccess{MemFetch, addr, hi, hi, c.pc})
+		c.memHook(MemAccess{MemFetch, next, lo, lo, c.pc})
+	}
+	return uint16(hi)<<8 | uint16(lo)
+}
+
+// code returns the bytes of the instruction at addr, enough for any
+// instruction, wrapping around memory like fetch. It doesn't go through the
+// memory hook, so the debugger can show code without tripping watchpoints.
+func (c *Chip8) code(addr uint16) []byte {
+	mask := c.addrMask()
+	b := make([]byte, 4)
+	for n := range b {
+		b[n] = c.mem[(addr+uint16(n))&mask]
+	}
+	return b
+}
+
+// read16 reads a big endian word of data at addr.
+func (c *Chip8) read16(addr uint16) uint16 {
+	return uint16(c.read(addr))<<8 | uint16(c.read(addr+1))
+}
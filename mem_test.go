@@ -0,0 +1,74 @@
+package chip8
+
+import (
+	"reflect"
+	"testing"
+)
+
+// accesses runs the instruction at 0x200 of rom, after setup, recording the
+// memory it touches.
+func accesses(t *testing.T, q Quirks, rom []byte, setup func(c *Chip8)) (*Chip8, []MemAccess) {
+	c := NewChip8(&NullDisplay{}, &NoKeypad{}, q)
+	c.Reset()
+	if err := c.LoadROM(rom); err != nil {
+		t.Fatal(err)
+	}
+	setup(c)
+	var seen []MemAccess
+	c.SetMemHook(func(a MemAccess) { seen = append(seen, a) })
+	if err := c.RunOne(); err != nil {
+		t.Fatal(err)
+	}
+	return c, seen
+}
+
+func addrs(seen []MemAccess, kind MemAccessKind) []uint16 {
+	var as []uint16
+	for _, a := range seen {
+		if a.Kind == kind {
+			as = append(as, a.Addr)
+		}
+	}
+	return as
+}
+
+// TestSkipLongLoad checks skipping a long LD I doesn't fetch it, so fetch
+// watchpoints only see code that runs.
+func TestSkipLongLoad(t *testing.T) {
+	rom := []byte{
+		0x30, 0x00, // SE V0, 0
+		0xF0, 0x00, 0x03, 0x00, // LD I, LONG 0x300
+		0x12, 0x06, // JP 0x206
+	}
+	c, seen := accesses(t, Quirks{XOChip: true}, rom, func(*Chip8) {})
+	if c.pc != 0x206 {
+		t.Errorf("skipped to 0x%X, want 0x206", c.pc)
+	}
+	if got := addrs(seen, MemFetch); !reflect.DeepEqual(got, []uint16{0x200, 0x201}) {
+		t.Errorf("fetched %X, want only the skip", got)
+	}
+}
+
+// TestAccessesWrap checks data accesses past the end of the 4K of memory wrap
+// to its start, as fetches do.
+func TestAccessesWrap(t *testing.T) {
+	c, seen := accesses(t, Quirks{}, []byte{0xF2, 0x55}, func(c *Chip8) {
+		c.i = 0xFFE
+		c.v[0], c.v[1], c.v[2] = 1, 2, 3
+	})
+	if got := addrs(seen, MemWrite); !reflect.DeepEqual(got, []uint16{0xFFE, 0xFFF, 0x000}) {
+		t.Errorf("FX55 wrote %X, want FFE FFF 0", got)
+	}
+	if c.mem[0] != 3 || c.mem[0x1000] != 0 {
+		t.Errorf("FX55 wrote 0x%X to 0 and 0x%X past the end", c.mem[0], c.mem[0x1000])
+	}
+
+	_, seen = accesses(t, Quirks{}, []byte{0xF1, 0x65}, func(c *Chip8) { c.i = 0xFFF })
+	if got := addrs(seen, MemRead); !reflect.DeepEqual(got, []uint16{0xFFF, 0x000}) {
+		t.Errorf("FX65 read %X, want FFF 0", got)
+	}
+	_, seen = accesses(t, Quirks{}, []byte{0xD0, 0x02}, func(c *Chip8) { c.i = 0xFFF })
+	if got := addrs(seen, MemRead); !reflect.DeepEqual(got, []uint16{0xFFF, 0x000}) {
+		t.Errorf("DXYN read %X, want FFF 0", got)
+	}
+}
@@ -0,0 +1,165 @@
+package octo
+
+import (
+	"fmt"
+	"math"
+)
+
+// calcUnary are the unary operators of calc expressions.
+var calcUnary = map[string]func(float64) float64{
+	"-":     func(x float64) float64 { return -x },
+	"~":     func(x float64) float64 { return float64(^int(x)) },
+	"!":     func(x float64) float64 { return calcBool(x == 0) },
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"tan":   math.Tan,
+	"exp":   math.Exp,
+	"log":   math.Log,
+	"abs":   math.Abs,
+	"sqrt":  math.Sqrt,
+	"ceil":  math.Ceil,
+	"floor": math.Floor,
+	"sign": func(x float64) float64 {
+		switch {
+		case x < 0:
+			return -1
+		case x > 0:
+			return 1
+		}
+		return 0
+	},
+}
+
+// calcBinary are the binary operators of calc expressions.
+var calcBinary = map[string]func(x, y float64) float64{
+	"-":   func(x, y float64) float64 { return x - y },
+	"+":   func(x, y float64) float64 { return x + y },
+	"*":   func(x, y float64) float64 { return x * y },
+	"/":   func(x, y float64) float64 { return x / y },
+	"%":   func(x, y float64) float64 { return float64(int(x) % int(y)) },
+	"&":   func(x, y float64) float64 { return float64(int(x) & int(y)) },
+	"|":   func(x, y float64) float64 { return float64(int(x) | int(y)) },
+	"^":   func(x, y float64) float64 { return float64(int(x) ^ int(y)) },
+	"<<":  func(x, y float64) float64 { return float64(int(x) << uint(int(y)&63)) },
+	">>":  func(x, y float64) float64 { return float64(int(x) >> uint(int(y)&63)) },
+	"pow": math.Pow,
+	"min": math.Min,
+	"max": math.Max,
+	"<":   func(x, y float64) float64 { return calcBool(x < y) },
+	"<=":  func(x, y float64) float64 { return calcBool(x <= y) },
+	"==":  func(x, y float64) float64 { return calcBool(x == y) },
+	"!=":  func(x, y float64) float64 { return calcBool(x != y) },
+	">=":  func(x, y float64) float64 { return calcBool(x >= y) },
+	">":   func(x, y float64) float64 { return calcBool(x > y) },
+}
+
+func calcBool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// calc evaluates a brace-delimited expression, having already read the {.
+// Like Octo, operators have no precedence and group from the right, so
+// `2 * 3 + 1` is 8.
+func (c *compiler) calc() (float64, error) {
+	v, err := c.calcExpr()
+	if err != nil {
+		return 0, err
+	}
+	if t := c.next(); t.text != "}" || t.str {
+		return 0, c.errorAt(t, "expected }, got %s", describe(t))
+	}
+	return v, nil
+}
+
+func (c *compiler) calcExpr() (float64, error) {
+	x, err := c.calcTerm()
+	if err != nil {
+		return 0, err
+	}
+	t := c.peek()
+	if t.str || t.text == "}" || t.text == ")" {
+		return x, nil
+	}
+	op, ok := calcBinary[t.text]
+	if !ok {
+		return 0, c.errorAt(t, "expected an operator, got %s", describe(t))
+	}
+	c.next()
+	y, err := c.calcExpr()
+	if err != nil {
+		return 0, err
+	}
+	if (t.text == "/" && y == 0) || (t.text == "%" && int(y) == 0) {
+		return 0, c.errorAt(t, "division by zero")
+	}
+	return op(x, y), nil
+}
+
+func (c *compiler) calcTerm() (float64, error) {
+	t := c.next()
+	if t.str {
+		return 0, c.errorAt(t, "unexpected string")
+	}
+	switch t.text {
+	case "":
+		return 0, c.errorAt(t, "unexpected end of file in expression")
+	case "(":
+		x, err := c.calcExpr()
+		if err != nil {
+			return 0, err
+		}
+		if t := c.next(); t.text != ")" {
+			return 0, c.errorAt(t, "expected ), got %s", describe(t))
+		}
+		return x, nil
+	case "@":
+		x, err := c.calcTerm()
+		if err != nil {
+			return 0, err
+		}
+		addr := int(x)
+		if addr < 0 || addr >= len(c.mem) {
+			return 0, c.errorAt(t, "@ address 0x%X is out of range", addr)
+		}
+		return float64(c.mem[addr]), nil
+	case "strlen":
+		s := c.next()
+		if !s.str {
+			return 0, c.errorAt(s, "strlen needs a string")
+		}
+		return float64(len(s.text)), nil
+	case "HERE":
+		return float64(c.here), nil
+	case "PI":
+		return math.Pi, nil
+	case "E":
+		return math.E, nil
+	}
+	if f, ok := calcUnary[t.text]; ok {
+		x, err := c.calcTerm()
+		if err != nil {
+			return 0, err
+		}
+		return f(x), nil
+	}
+	if n, ok := parseNumber(t.text); ok {
+		return float64(n), nil
+	}
+	var f float64
+	if _, err := fmt.Sscanf(t.text, "%g", &f); err == nil {
+		return f, nil
+	}
+	if v, ok := c.consts[t.text]; ok {
+		return v, nil
+	}
+	if addr, ok := c.labels[t.text]; ok {
+		return float64(addr), nil
+	}
+	if r, ok := c.registerIndex(t.text); ok {
+		return float64(r), nil
+	}
+	return 0, c.errorAt(t, "undefined name %s in expression", t.text)
+}
@@ -0,0 +1,88 @@
+package octo
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/Grazfather/chip8/asm"
+)
+
+// token is a word of Octo source. Words are separated by whitespace, except
+// for quoted strings, and # starts a comment.
+type token struct {
+	text string
+	pos  asm.Pos
+	// str is set for quoted strings, whose text is unquoted
+	str bool
+}
+
+// lex splits src into tokens.
+func lex(filename, src string) ([]token, error) {
+	var toks []token
+	for n, line := range strings.Split(src, "\n") {
+		i := 0
+		for {
+			for i < len(line) && isSpace(line[i]) {
+				i++
+			}
+			if i >= len(line) || line[i] == '#' {
+				break
+			}
+			pos := asm.Pos{File: filename, Line: n + 1, Col: i + 1}
+			start := i
+			if line[i] == '"' {
+				i++
+				for i < len(line) && line[i] != '"' {
+					if line[i] == '\\' {
+						i++
+					}
+					i++
+				}
+				if i >= len(line) {
+					return nil, &asm.Error{Pos: pos, Msg: "unterminated string"}
+				}
+				i++
+				s, err := strconv.Unquote(line[start:i])
+				if err != nil {
+					return nil, &asm.Error{Pos: pos, Msg: "bad string " + line[start:i]}
+				}
+				toks = append(toks, token{text: s, pos: pos, str: true})
+				continue
+			}
+			for i < len(line) && !isSpace(line[i]) {
+				i++
+			}
+			toks = append(toks, token{text: line[start:i], pos: pos})
+		}
+	}
+	return toks, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r'
+}
+
+// parseNumber parses a decimal, 0x hex or 0b binary number, which may be
+// negative.
+func parseNumber(s string) (int, bool) {
+	neg := strings.HasPrefix(s, "-")
+	if neg {
+		s = s[1:]
+	}
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return 0, false
+	}
+	// Leading zeros don't make octal
+	base := 10
+	if len(s) > 1 && strings.ContainsRune("xXbB", rune(s[1])) {
+		base = 0
+	}
+	n, err := strconv.ParseInt(s, base, 32)
+	if err != nil {
+		return 0, false
+	}
+	if neg {
+		n = -n
+	}
+	return int(n), true
+}
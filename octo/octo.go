@@ -0,0 +1,743 @@
+// Package octo compiles CHIP-8 programs written in Octo, the language of
+// John Earnest's Octo IDE, including its SCHIP and XO-CHIP instructions.
+//
+// Programs are a stream of whitespace separated words, with # comments.
+// `: name` defines a label, and a label on its own calls that subroutine,
+// even before it is defined. Execution starts at `: main`. Assignments are
+// written like `v0 := 5`, `v1 += v2` and `i := sprite-data`, and control flow
+// with `if v0 == 3 then ...`, `if ... begin ... else ... end` and
+// `loop ... while v0 != 0 ... again`. The directives are:
+//
+//	:const NAME value         define a constant
+//	:alias NAME register      name a register, or { expr } for its index
+//	:calc NAME { expr }       define a constant from an expression
+//	:byte value               a byte of data, or { expr }
+//	:pointer value            a big endian word of data
+//	:org value                compile what follows at an address
+//	:next NAME                label the second byte of the next instruction
+//	:unpack [long] n label    load v0 and v1 with n and a label's address
+//	:call value               call an address
+//	:macro NAME args { ... }  define a macro; CALLS counts its uses
+//	:stringmode NAME "alphabet" { ... }
+//	                          define a macro over each character of a string,
+//	                          with CHAR, INDEX and VALUE
+//	:assert ["message"] { expr }
+//
+// :breakpoint, :monitor and :proto are accepted and ignored.
+//
+// Expressions in braces are evaluated right to left without precedence, as in
+// Octo, so `{ 2 * 3 + 1 }` is 8. Programs compile to an asm.Program so they
+// can be loaded and debugged like assembled ones.
+package octo
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Grazfather/chip8"
+	"github.com/Grazfather/chip8/asm"
+)
+
+// maxExpansion limits how many tokens macros can expand to, to stop runaway
+// recursion.
+const maxExpansion = 1 << 20
+
+// keywords can't be used as names.
+var keywords = map[string]bool{
+	":": true, ":=": true, "+=": true, "-=": true, "=-": true, "|=": true,
+	"&=": true, "^=": true, ">>=": true, "<<=": true, "==": true, "!=": true,
+	"<": true, ">": true, "<=": true, ">=": true, ";": true, "{": true, "}": true,
+	"-": true, "return": true, "clear": true, "bcd": true, "save": true,
+	"load": true, "saveflags": true, "loadflags": true, "sprite": true,
+	"jump": true, "jump0": true, "native": true, "hires": true, "lores": true,
+	"scroll-down": true, "scroll-up": true, "scroll-left": true,
+	"scroll-right": true, "exit": true, "plane": true, "audio": true,
+	"if": true, "then": true, "begin": true, "else": true, "end": true,
+	"loop": true, "while": true, "again": true, "key": true, "-key": true,
+	"random": true, "hex": true, "bighex": true, "long": true, "delay": true,
+	"buzzer": true, "pitch": true, "i": true,
+}
+
+// inverse negates a comparison.
+var inverse = map[string]string{
+	"==": "!=", "!=": "==", "key": "-key", "-key": "key",
+	">": "<=", "<": ">=", ">=": "<", "<=": ">",
+}
+
+// fixKind is how a forward reference is patched in.
+type fixKind int
+
+const (
+	// fix12 is the NNN field of the instruction at the address
+	fix12 fixKind = iota
+	// fix16 is a big endian word at the address
+	fix16
+	// fixUnpack is the two loads of :unpack, with the nybble in the first
+	fixUnpack
+	// fixUnpackLong is the two loads of :unpack long
+	fixUnpackLong
+)
+
+// fixup is a use of a label before its definition.
+type fixup struct {
+	kind   fixKind
+	at     int
+	nybble int
+	t      token
+}
+
+// macro is a :macro, or a character of a :stringmode.
+type macro struct {
+	args  []string
+	body  []token
+	calls int
+	// value is the index of a stringmode character in its alphabet
+	value int
+}
+
+// branch is an if ... begin or else waiting for its end, or a loop waiting
+// for its again.
+type branch struct {
+	addr int
+	t    token
+	// breaks are the jumps out of a loop made by while
+	breaks []int
+}
+
+type compiler struct {
+	toks []token
+	i    int
+	eof  token
+
+	mem     [0x10000]byte
+	written [0x10000]bool
+	here    int
+	end     int
+
+	labels      map[string]int
+	order       []string
+	consts      map[string]float64
+	aliases     map[string]int
+	protos      map[string][]fixup
+	macros      map[string]*macro
+	stringModes map[string]map[byte]*macro
+	expanded    int
+
+	branches []branch
+	loops    []branch
+
+	sources *chip8.SourceMap
+	// stmt is where the current statement started, and mapped is set once
+	// its first byte is in the source map
+	stmt   asm.Pos
+	mapped bool
+}
+
+// Compile compiles an Octo source file.
+func Compile(filename string) (*asm.Program, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return CompileSource(filename, src)
+}
+
+// CompileSource compiles src, naming it filename in errors and the source map.
+func CompileSource(filename string, src []byte) (*asm.Program, error) {
+	toks, err := lex(filename, string(src))
+	if err != nil {
+		return nil, err
+	}
+	c := &compiler{
+		toks:        toks,
+		eof:         token{pos: asm.Pos{File: filename, Line: strings.Count(string(src), "\n") + 1, Col: 1}},
+		here:        asm.LoadAddress,
+		end:         asm.LoadAddress,
+		labels:      make(map[string]int),
+		consts:      make(map[string]float64),
+		aliases:     map[string]int{"unpack-hi": 0, "unpack-lo": 1, "compare-temp": 0xF},
+		protos:      make(map[string][]fixup),
+		macros:      make(map[string]*macro),
+		stringModes: make(map[string]map[byte]*macro),
+		sources:     chip8.NewSourceMap(),
+	}
+	return c.compile()
+}
+
+func (c *compiler) compile() (*asm.Program, error) {
+	// Programs start with a jump to main, which is dropped if main comes
+	// first
+	c.mapped = true
+	c.protos["main"] = []fixup{{kind: fix12, at: c.here, t: token{text: "main", pos: c.eof.pos}}}
+	if err := c.op(0x1000); err != nil {
+		return nil, err
+	}
+	for c.i < len(c.toks) {
+		c.stmt = c.toks[c.i].pos
+		c.mapped = false
+		if err := c.statement(); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := c.labels["main"]; !ok {
+		return nil, c.errorAt(c.eof, "program has no main label")
+	}
+	if len(c.branches) > 0 {
+		return nil, c.errorAt(c.branches[len(c.branches)-1].t, "begin without end")
+	}
+	if len(c.loops) > 0 {
+		return nil, c.errorAt(c.loops[len(c.loops)-1].t, "loop without again")
+	}
+	var undefined []fixup
+	for _, fs := range c.protos {
+		undefined = append(undefined, fs[0])
+	}
+	if len(undefined) > 0 {
+		sort.Slice(undefined, func(i, j int) bool {
+			pi, pj := undefined[i].t.pos, undefined[j].t.pos
+			if pi.Line != pj.Line {
+				return pi.Line < pj.Line
+			}
+			return pi.Col < pj.Col
+		})
+		return nil, c.errorAt(undefined[0].t, "undefined name %s", undefined[0].t.text)
+	}
+
+	p := &asm.Program{
+		ROM:     append([]byte(nil), c.mem[asm.LoadAddress:c.end]...),
+		Symbols: chip8.NewSymbols(),
+		Sources: c.sources,
+	}
+	for _, name := range c.order {
+		p.Symbols.Add(name, uint16(c.labels[name]))
+	}
+	return p, nil
+}
+
+func (c *compiler) errorAt(t token, format string, args ...interface{}) error {
+	return &asm.Error{Pos: t.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// describe names a token for error messages.
+func describe(t token) string {
+	switch {
+	case t.str:
+		return fmt.Sprintf("string %q", t.text)
+	case t.text == "":
+		return "end of file"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func (c *compiler) peekAt(n int) token {
+	if c.i+n < len(c.toks) {
+		return c.toks[c.i+n]
+	}
+	return c.eof
+}
+
+func (c *compiler) peek() token {
+	return c.peekAt(0)
+}
+
+func (c *compiler) next() token {
+	t := c.peek()
+	if c.i < len(c.toks) {
+		c.i++
+	}
+	return t
+}
+
+// expect reads a token that must be word.
+func (c *compiler) expect(word string) error {
+	if t := c.next(); t.str || t.text != word {
+		return c.errorAt(t, "expected %s, got %s", word, describe(t))
+	}
+	return nil
+}
+
+// emit writes bytes at here and moves past them.
+func (c *compiler) emit(b ...byte) error {
+	for _, v := range b {
+		if c.here < asm.LoadAddress || c.here >= len(c.mem) {
+			return &asm.Error{Pos: c.stmt, Msg: fmt.Sprintf("address 0x%X is outside the program", c.here)}
+		}
+		if c.written[c.here] {
+			return &asm.Error{Pos: c.stmt, Msg: fmt.Sprintf("overwrites 0x%04X", c.here)}
+		}
+		if !c.mapped {
+			c.sources.Add(uint16(c.here), chip8.SourceLocation{File: c.stmt.File, Line: c.stmt.Line})
+			c.mapped = true
+		}
+		c.mem[c.here] = v
+		c.written[c.here] = true
+		c.here++
+		if c.here > c.end {
+			c.end = c.here
+		}
+	}
+	return nil
+}
+
+func (c *compiler) op(op uint16) error {
+	return c.emit(byte(op>>8), byte(op))
+}
+
+// jumpTo patches the jump at addr to go to here.
+func (c *compiler) jumpTo(addr int) {
+	c.patch(fixup{kind: fix12, at: addr}, c.here)
+}
+
+func (c *compiler) patch(f fixup, v int) {
+	switch f.kind {
+	case fix12:
+		c.mem[f.at] = c.mem[f.at]&0xF0 | byte(v>>8)
+		c.mem[f.at+1] = byte(v)
+	case fix16:
+		c.mem[f.at] = byte(v >> 8)
+		c.mem[f.at+1] = byte(v)
+	case fixUnpack:
+		c.mem[f.at+1] = byte(f.nybble<<4 | v>>8)
+		c.mem[f.at+3] = byte(v)
+	case fixUnpackLong:
+		c.mem[f.at+1] = byte(v >> 8)
+		c.mem[f.at+3] = byte(v)
+	}
+}
+
+// checkAddr checks that v fits where kind puts it.
+func (c *compiler) checkAddr(t token, kind fixKind, v int) error {
+	bits := 12
+	if kind == fix16 || kind == fixUnpackLong {
+		bits = 16
+	}
+	if v < 0 || v >= 1<<bits {
+		return c.errorAt(t, "address 0x%X doesn't fit in %d bits", v, bits)
+	}
+	return nil
+}
+
+// reg returns the register t names, directly or by an alias.
+func (c *compiler) reg(t token) (int, bool) {
+	if t.str {
+		return 0, false
+	}
+	return c.registerIndex(t.text)
+}
+
+func (c *compiler) registerIndex(name string) (int, bool) {
+	if r, ok := c.aliases[name]; ok {
+		return r, true
+	}
+	if len(name) == 2 && (name[0] == 'v' || name[0] == 'V') {
+		if r, err := strconv.ParseUint(name[1:], 16, 8); err == nil {
+			return int(r), true
+		}
+	}
+	return 0, false
+}
+
+func (c *compiler) register() (int, error) {
+	t := c.next()
+	r, ok := c.reg(t)
+	if !ok {
+		return 0, c.errorAt(t, "expected a register, got %s", describe(t))
+	}
+	return r, nil
+}
+
+// lookup evaluates a number, constant, defined label or { expr }. It reports
+// false if t is none of those.
+func (c *compiler) lookup(t token) (int, bool, error) {
+	if t.str {
+		return 0, false, nil
+	}
+	if t.text == "{" {
+		v, err := c.calc()
+		return int(v), true, err
+	}
+	if n, ok := parseNumber(t.text); ok {
+		return n, true, nil
+	}
+	if v, ok := c.consts[t.text]; ok {
+		return int(v), true, nil
+	}
+	if addr, ok := c.labels[t.text]; ok {
+		return addr, true, nil
+	}
+	return 0, false, nil
+}
+
+// valueOf evaluates t as a value of bits bits. Negative values are allowed
+// down to -(1<<bits)/2 and wrap.
+func (c *compiler) valueOf(t token, bits uint) (int, error) {
+	v, ok, err := c.lookup(t)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		if _, isReg := c.reg(t); isReg || t.str || t.text == "" {
+			return 0, c.errorAt(t, "expected a value, got %s", describe(t))
+		}
+		return 0, c.errorAt(t, "undefined name %s", t.text)
+	}
+	return c.fits(t, v, bits)
+}
+
+// fits checks that v, the value of t, fits in bits bits, and wraps it if it
+// is negative.
+func (c *compiler) fits(t token, v int, bits uint) (int, error) {
+	limit := 1<<bits - 1
+	if v > limit || v < -(limit+1)/2 {
+		return 0, c.errorAt(t, "value %d (0x%X) doesn't fit in 0x%X", v, v, limit)
+	}
+	return v & limit, nil
+}
+
+func (c *compiler) value(bits uint) (int, error) {
+	return c.valueOf(c.next(), bits)
+}
+
+// addr reads an address, which may be a label defined later. Those are
+// patched in by kind at at once they are.
+func (c *compiler) addr(kind fixKind, at, nybble int) (int, error) {
+	t := c.next()
+	v, ok, err := c.lookup(t)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		if err := c.checkName(t); err != nil {
+			return 0, err
+		}
+		c.protos[t.text] = append(c.protos[t.text], fixup{kind, at, nybble, t})
+		return 0, nil
+	}
+	return v, c.checkAddr(t, kind, v)
+}
+
+// checkName checks that t can name a label, constant or macro.
+func (c *compiler) checkName(t token) error {
+	if t.str || t.text == "" || keywords[t.text] || strings.HasPrefix(t.text, ":") {
+		return c.errorAt(t, "expected a name, got %s", describe(t))
+	}
+	if _, ok := c.reg(t); ok {
+		return c.errorAt(t, "register %s can't be used as a name", t.text)
+	}
+	if _, ok := parseNumber(t.text); ok {
+		return c.errorAt(t, "number %s can't be used as a name", t.text)
+	}
+	return nil
+}
+
+// define checks that t is a new name.
+func (c *compiler) define(t token) error {
+	if err := c.checkName(t); err != nil {
+		return err
+	}
+	_, label := c.labels[t.text]
+	_, cnst := c.consts[t.text]
+	_, mac := c.macros[t.text]
+	if label || cnst || mac {
+		return c.errorAt(t, "%s is already defined", t.text)
+	}
+	return nil
+}
+
+// label defines a label at addr, patching in earlier uses.
+func (c *compiler) label(t token, addr int) error {
+	if err := c.define(t); err != nil {
+		return err
+	}
+	if t.text == "main" && addr == asm.LoadAddress+2 && c.end == addr {
+		// Nothing comes before main, so drop the jump to it
+		c.here, c.end, addr = asm.LoadAddress, asm.LoadAddress, asm.LoadAddress
+		c.mem[c.here], c.mem[c.here+1] = 0, 0
+		c.written[c.here], c.written[c.here+1] = false, false
+		delete(c.protos, "main")
+	}
+	c.labels[t.text] = addr
+	c.order = append(c.order, t.text)
+	for _, f := range c.protos[t.text] {
+		if err := c.checkAddr(f.t, f.kind, addr); err != nil {
+			return err
+		}
+		c.patch(f, addr)
+	}
+	delete(c.protos, t.text)
+	return nil
+}
+
+// block reads tokens up to the } matching an already read {.
+func (c *compiler) block(open token) ([]token, error) {
+	depth := 1
+	start := c.i
+	for c.i < len(c.toks) {
+		t := c.next()
+		if t.str {
+			continue
+		}
+		switch t.text {
+		case "{":
+			depth++
+		case "}":
+			depth--
+			if depth == 0 {
+				return c.toks[start : c.i-1], nil
+			}
+		}
+	}
+	return nil, c.errorAt(open, "{ without }")
+}
+
+// expand replaces the next tokens with a macro body, substituting args.
+func (c *compiler) expand(at token, body []token, args map[string]token) error {
+	c.expanded += len(body)
+	if c.expanded > maxExpansion {
+		return c.errorAt(at, "macros expand too far, %s may be recursive", at.text)
+	}
+	out := make([]token, 0, len(body)+len(c.toks)-c.i)
+	for _, t := range body {
+		if a, ok := args[t.text]; ok && !t.str {
+			t = a
+		}
+		out = append(out, t)
+	}
+	c.toks = append(out, c.toks[c.i:]...)
+	c.i = 0
+	return nil
+}
+
+// numberToken is a token for a value substituted into a macro.
+func numberToken(v int, pos asm.Pos) token {
+	return token{text: strconv.Itoa(v), pos: pos}
+}
+
+func (c *compiler) callMacro(t token, m *macro) error {
+	args := map[string]token{"CALLS": numberToken(m.calls, t.pos)}
+	for _, name := range m.args {
+		a := c.next()
+		if a.text == "" && !a.str {
+			return c.errorAt(a, "%s needs %d arguments", t.text, len(m.args))
+		}
+		args[name] = a
+	}
+	m.calls++
+	return c.expand(t, m.body, args)
+}
+
+func (c *compiler) callStringMode(t token, mode map[byte]*macro) error {
+	s := c.next()
+	if !s.str {
+		return c.errorAt(s, "%s needs a string, got %s", t.text, describe(s))
+	}
+	var body []token
+	for n := 0; n < len(s.text); n++ {
+		ch := s.text[n]
+		m, ok := mode[ch]
+		if !ok {
+			return c.errorAt(s, "stringmode %s has no %q", t.text, ch)
+		}
+		for _, bt := range m.body {
+			switch {
+			case bt.str:
+			case bt.text == "CHAR":
+				bt = numberToken(int(ch), bt.pos)
+			case bt.text == "INDEX":
+				bt = numberToken(n, bt.pos)
+			case bt.text == "VALUE":
+				bt = numberToken(m.value, bt.pos)
+			}
+			body = append(body, bt)
+		}
+	}
+	return c.expand(t, body, nil)
+}
+
+// statement compiles the next statement.
+func (c *compiler) statement() error {
+	t := c.next()
+	if t.str {
+		return c.errorAt(t, "unexpected string %q", t.text)
+	}
+	if t.text[0] == ':' && t.text != ":=" {
+		return c.directive(t)
+	}
+	if m, ok := c.macros[t.text]; ok {
+		return c.callMacro(t, m)
+	}
+	if mode, ok := c.stringModes[t.text]; ok {
+		return c.callStringMode(t, mode)
+	}
+	if r, ok := c.reg(t); ok {
+		return c.assign(r)
+	}
+	switch t.text {
+	case ";", "return":
+		return c.op(0x00EE)
+	case "clear":
+		return c.op(0x00E0)
+	case "exit":
+		return c.op(0x00FD)
+	case "lores":
+		return c.op(0x00FE)
+	case "hires":
+		return c.op(0x00FF)
+	case "scroll-right":
+		return c.op(0x00FB)
+	case "scroll-left":
+		return c.op(0x00FC)
+	case "audio":
+		return c.op(0xF002)
+	case "scroll-down", "scroll-up":
+		n, err := c.value(4)
+		if err != nil {
+			return err
+		}
+		base := uint16(0x00C0)
+		if t.text == "scroll-up" {
+			base = 0x00D0
+		}
+		return c.op(base | uint16(n))
+	case "plane":
+		n, err := c.value(4)
+		if err != nil {
+			return err
+		}
+		return c.op(0xF001 | uint16(n)<<8)
+	case "bcd", "saveflags", "loadflags":
+		r, err := c.register()
+		if err != nil {
+			return err
+		}
+		op := map[string]uint16{"bcd": 0xF033, "saveflags": 0xF075, "loadflags": 0xF085}[t.text]
+		return c.op(op | uint16(r)<<8)
+	case "save", "load":
+		x, err := c.register()
+		if err != nil {
+			return err
+		}
+		if p := c.peek(); p.text == "-" && !p.str {
+			c.next()
+			y, err := c.register()
+			if err != nil {
+				return err
+			}
+			op := uint16(0x5002)
+			if t.text == "load" {
+				op = 0x5003
+			}
+			return c.op(op | uint16(x)<<8 | uint16(y)<<4)
+		}
+		op := uint16(0xF055)
+		if t.text == "load" {
+			op = 0xF065
+		}
+		return c.op(op | uint16(x)<<8)
+	case "sprite":
+		x, err := c.register()
+		if err != nil {
+			return err
+		}
+		y, err := c.register()
+		if err != nil {
+			return err
+		}
+		n, err := c.value(4)
+		if err != nil {
+			return err
+		}
+		return c.op(0xD000 | uint16(x)<<8 | uint16(y)<<4 | uint16(n))
+	case "jump", "jump0", "native":
+		op := map[string]uint16{"jump": 0x1000, "jump0": 0xB000, "native": 0x0000}[t.text]
+		addr, err := c.addr(fix12, c.here, 0)
+		if err != nil {
+			return err
+		}
+		return c.op(op | uint16(addr))
+	case "i":
+		return c.assignI()
+	case "delay", "buzzer", "pitch":
+		if err := c.expect(":="); err != nil {
+			return err
+		}
+		r, err := c.register()
+		if err != nil {
+			return err
+		}
+		op := map[string]uint16{"delay": 0xF015, "buzzer": 0xF018, "pitch": 0xF03A}[t.text]
+		return c.op(op | uint16(r)<<8)
+	case "if":
+		return c.ifStatement(t)
+	case "else":
+		if len(c.branches) == 0 {
+			return c.errorAt(t, "else without begin")
+		}
+		b := &c.branches[len(c.branches)-1]
+		at := c.here
+		if err := c.op(0x1000); err != nil {
+			return err
+		}
+		c.jumpTo(b.addr)
+		b.addr, b.t = at, t
+		return nil
+	case "end":
+		if len(c.branches) == 0 {
+			return c.errorAt(t, "end without begin")
+		}
+		c.jumpTo(c.branches[len(c.branches)-1].addr)
+		c.branches = c.branches[:len(c.branches)-1]
+		return nil
+	case "loop":
+		c.loops = append(c.loops, branch{addr: c.here, t: t})
+		return nil
+	case "while":
+		if len(c.loops) == 0 {
+			return c.errorAt(t, "while without loop")
+		}
+		if err := c.conditional(true); err != nil {
+			return err
+		}
+		l := &c.loops[len(c.loops)-1]
+		l.breaks = append(l.breaks, c.here)
+		return c.op(0x1000)
+	case "again":
+		if len(c.loops) == 0 {
+			return c.errorAt(t, "again without loop")
+		}
+		l := c.loops[len(c.loops)-1]
+		c.loops = c.loops[:len(c.loops)-1]
+		if err := c.op(0x1000 | uint16(l.addr)); err != nil {
+			return err
+		}
+		for _, at := range l.breaks {
+			c.jumpTo(at)
+		}
+		return nil
+	}
+	if addr, ok := c.labels[t.text]; ok {
+		return c.op(0x2000 | uint16(addr))
+	}
+	// A number or constant is a byte of data
+	v, ok, err := c.lookup(t)
+	if err != nil {
+		return err
+	}
+	if ok {
+		b, err := c.fits(t, v, 8)
+		if err != nil {
+			return err
+		}
+		return c.emit(byte(b))
+	}
+	// Anything else calls a subroutine defined later
+	c.i--
+	addr, err := c.addr(fix12, c.here, 0)
+	if err != nil {
+		return err
+	}
+	return c.op(0x2000 | uint16(addr))
+}
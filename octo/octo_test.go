@@ -0,0 +1,97 @@
+package octo
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func compile(src string) ([]byte, error) {
+	p, err := CompileSource("test.8o", []byte(src))
+	if err != nil {
+		return nil, err
+	}
+	return p.ROM, nil
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{": main clear return", "00e0 00ee"},
+		{": main v0 := 5 v1 += 2 v2 -= 1 v3 := v4", "6005 7102 72ff 8340"},
+		{": main v1 |= v2 v1 &= v2 v1 ^= v2 v1 += v2 v1 -= v2", "8121 8122 8123 8124 8125"},
+		{": main v1 >>= v2 v1 =- v2 v1 <<= v2 v1 := random 0x0F", "8126 8127 812e c10f"},
+		{": main i := main i += v3 i := hex v3 i := bighex v3", "a200 f31e f329 f330"},
+		{": main v2 := delay v2 := key delay := v2 buzzer := v2 pitch := v2", "f207 f20a f215 f218 f23a"},
+		{": main bcd v4 save v4 load v4 saveflags v4 loadflags v4", "f433 f455 f465 f475 f485"},
+		{": main save v1 - v3 load v3 - v1 plane 3 audio", "5132 5313 f301 f002"},
+		{": main sprite v0 v1 5 scroll-down 2 scroll-up 3 scroll-left scroll-right", "d015 00c2 00d3 00fc 00fb"},
+		{": main lores hires exit jump0 main native 0x123", "00fe 00ff 00fd b200 0123"},
+		{": main i := long data : data", "f000 0204"},
+		{": main if v1 == 2 then v0 := 1 if v1 != v2 then v0 := 1", "4102 6001 5120 6001"},
+		{": main if v1 key then v0 := 1 if v1 -key then v0 := 1", "e1a1 6001 e19e 6001"},
+		{": main if v1 < 5 then v0 := 1", "6f05 8f17 3f01 6001"},
+		{": main if v1 > v2 then v0 := 1", "8f20 8f15 3f01 6001"},
+		{": main if v1 == 2 begin v0 := 1 else v0 := 2 end", "3102 1208 6001 120a 6002"},
+		{": main loop v0 += 1 while v0 != 10 again", "7001 400a 1208 1200"},
+		// A program that doesn't start with main jumps to it
+		{": data 1 2 : main jump data", "1204 0102 1202"},
+		{": main sub ; : sub return", "2204 00ee 00ee"},
+		{": main :call sub : sub", "2202"},
+		{":const N 3 : main v0 := N", "6003"},
+		{":calc N { 1 + 2 * 3 } : main v0 := N", "6007"},
+		{":calc N { ( 1 + 2 ) * 3 } : main v0 := N", "6009"},
+		{":calc N { 7 % 4 } : main v0 := N", "6003"},
+		{":alias x v5 : main x := 1", "6501"},
+		{":macro twice op { op op } : main twice clear", "00e0 00e0"},
+		{": main :byte 1 :byte { 2 + 2 } :pointer main", "0104 0200"},
+		{": main :org 0x210 0xAB", "0000 0000 0000 0000 0000 0000 0000 0000 ab"},
+		{": main :unpack 0xA main", "60a2 6100"},
+		{": main 0b101 0x1F 7", "051f 07"},
+	}
+	for _, tt := range tests {
+		rom, err := compile(tt.src)
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		if got, want := fmt.Sprintf("%x", rom), strings.Replace(tt.want, " ", "", -1); got != want {
+			t.Errorf("%q compiled to %s, want %s", tt.src, got, want)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"clear", "program has no main label"},
+		{": main jump nowhere", "nowhere"},
+		{": main : main", "main is already defined"},
+		{": main v0 := 256", "test.8o:1:14: value 256 (0x100) doesn't fit in 0xFF"},
+		{": main :org 0x1000 : far jump far", "address 0x1000 doesn't fit in 12 bits"},
+		{":calc X { 1 / 0 } : main", "test.8o:1:13: division by zero"},
+		{":calc X { 5 % 0 } : main", "test.8o:1:13: division by zero"},
+		{":calc X { 1 / ( 2 - 2 ) } : main", "division by zero"},
+		{":calc X { 1 + } : main", "test.8o:1"},
+		{":calc X { 1 2 } : main", "expected an operator"},
+		{":calc X { Y } : main", "undefined name Y"},
+		{": main if v0 == 1 begin", "begin without end"},
+		{": main end", "end without begin"},
+		{": main v0 ^= 1", "needs a register"},
+		{":assert \"nope\" { 1 == 2 } : main", "assertion failed: nope"},
+	}
+	for _, tt := range tests {
+		_, err := compile(tt.src)
+		if err == nil {
+			t.Errorf("%q compiled", tt.src)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: got error %q, want it to contain %q", tt.src, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package octo
+
+// directive compiles a statement starting with a :word.
+func (c *compiler) directive(t token) error {
+	switch t.text {
+	case ":":
+		return c.label(c.next(), c.here)
+	case ":next":
+		return c.label(c.next(), c.here+1)
+	case ":const":
+		name := c.next()
+		if err := c.define(name); err != nil {
+			return err
+		}
+		v := c.next()
+		n, ok, err := c.lookup(v)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return c.errorAt(v, "expected a value, got %s", describe(v))
+		}
+		c.consts[name.text] = float64(n)
+		return nil
+	case ":calc":
+		name := c.next()
+		if err := c.define(name); err != nil {
+			return err
+		}
+		if err := c.expect("{"); err != nil {
+			return err
+		}
+		v, err := c.calc()
+		if err != nil {
+			return err
+		}
+		c.consts[name.text] = v
+		return nil
+	case ":alias":
+		name := c.next()
+		if err := c.checkName(name); err != nil && name.text != "compare-temp" {
+			return err
+		}
+		r := c.next()
+		if r.text == "{" && !r.str {
+			v, err := c.calc()
+			if err != nil {
+				return err
+			}
+			if v < 0 || v > 0xF {
+				return c.errorAt(r, "register index %v is out of range", v)
+			}
+			c.aliases[name.text] = int(v)
+			return nil
+		}
+		n, ok := c.reg(r)
+		if !ok {
+			return c.errorAt(r, "expected a register, got %s", describe(r))
+		}
+		c.aliases[name.text] = n
+		return nil
+	case ":byte":
+		b, err := c.value(8)
+		if err != nil {
+			return err
+		}
+		return c.emit(byte(b))
+	case ":pointer":
+		addr, err := c.addr(fix16, c.here, 0)
+		if err != nil {
+			return err
+		}
+		return c.op(uint16(addr))
+	case ":call":
+		addr, err := c.addr(fix12, c.here, 0)
+		if err != nil {
+			return err
+		}
+		return c.op(0x2000 | uint16(addr))
+	case ":org":
+		v, err := c.value(16)
+		if err != nil {
+			return err
+		}
+		c.here = v
+		return nil
+	case ":unpack":
+		kind, nybble := fixUnpack, 0
+		if p := c.peek(); p.text == "long" && !p.str {
+			c.next()
+			kind = fixUnpackLong
+		} else {
+			n, err := c.value(4)
+			if err != nil {
+				return err
+			}
+			nybble = n
+		}
+		addr, err := c.addr(kind, c.here, nybble)
+		if err != nil {
+			return err
+		}
+		hi, lo := addr>>8|nybble<<4, addr&0xFF
+		if err := c.op(0x6000 | uint16(c.aliases["unpack-hi"])<<8 | uint16(hi)); err != nil {
+			return err
+		}
+		return c.op(0x6000 | uint16(c.aliases["unpack-lo"])<<8 | uint16(lo))
+	case ":macro":
+		name := c.next()
+		if err := c.define(name); err != nil {
+			return err
+		}
+		m := &macro{}
+		for {
+			a := c.next()
+			if a.text == "{" && !a.str {
+				body, err := c.block(a)
+				if err != nil {
+					return err
+				}
+				m.body = body
+				break
+			}
+			if err := c.checkName(a); err != nil {
+				return err
+			}
+			m.args = append(m.args, a.text)
+		}
+		c.macros[name.text] = m
+		return nil
+	case ":stringmode":
+		name := c.next()
+		if err := c.checkName(name); err != nil {
+			return err
+		}
+		alphabet := c.next()
+		if !alphabet.str {
+			return c.errorAt(alphabet, "expected an alphabet string, got %s", describe(alphabet))
+		}
+		open := c.next()
+		if open.text != "{" || open.str {
+			return c.errorAt(open, "expected {, got %s", describe(open))
+		}
+		body, err := c.block(open)
+		if err != nil {
+			return err
+		}
+		mode := c.stringModes[name.text]
+		if mode == nil {
+			mode = make(map[byte]*macro)
+			c.stringModes[name.text] = mode
+		}
+		for n := 0; n < len(alphabet.text); n++ {
+			mode[alphabet.text[n]] = &macro{body: body, value: n}
+		}
+		return nil
+	case ":assert":
+		msg := "assertion failed"
+		if p := c.peek(); p.str {
+			msg = "assertion failed: " + c.next().text
+		}
+		if err := c.expect("{"); err != nil {
+			return err
+		}
+		v, err := c.calc()
+		if err != nil {
+			return err
+		}
+		if v == 0 {
+			return c.errorAt(t, "%s", msg)
+		}
+		return nil
+	case ":breakpoint", ":proto":
+		c.next()
+		return nil
+	case ":monitor":
+		c.next()
+		c.next()
+		return nil
+	}
+	return c.errorAt(t, "unknown directive %s", t.text)
+}
+
+// assign compiles an assignment to register x.
+func (c *compiler) assign(x int) error {
+	t := c.next()
+	src := c.peek()
+	y, isReg := c.reg(src)
+	if isReg {
+		c.next()
+	}
+	op := uint16(x) << 8
+	xy := op | uint16(y)<<4
+	switch t.text {
+	case ":=":
+		switch {
+		case isReg:
+			return c.op(0x8000 | xy)
+		case src.text == "key":
+			c.next()
+			return c.op(0xF00A | op)
+		case src.text == "delay":
+			c.next()
+			return c.op(0xF007 | op)
+		case src.text == "random":
+			c.next()
+			n, err := c.value(8)
+			if err != nil {
+				return err
+			}
+			return c.op(0xC000 | op | uint16(n))
+		}
+		n, err := c.value(8)
+		if err != nil {
+			return err
+		}
+		return c.op(0x6000 | op | uint16(n))
+	case "+=", "-=":
+		if isReg {
+			if t.text == "+=" {
+				return c.op(0x8004 | xy)
+			}
+			return c.op(0x8005 | xy)
+		}
+		n, err := c.value(8)
+		if err != nil {
+			return err
+		}
+		if t.text == "-=" {
+			n = -n & 0xFF
+		}
+		return c.op(0x7000 | op | uint16(n))
+	}
+	ops := map[string]uint16{"|=": 1, "&=": 2, "^=": 3, ">>=": 6, "=-": 7, "<<=": 0xE}
+	n, ok := ops[t.text]
+	if !ok || t.str {
+		return c.errorAt(t, "expected an assignment, got %s", describe(t))
+	}
+	if !isReg {
+		return c.errorAt(src, "%s needs a register, got %s", t.text, describe(src))
+	}
+	return c.op(0x8000 | xy | n)
+}
+
+// assignI compiles an assignment to i.
+func (c *compiler) assignI() error {
+	t := c.next()
+	switch t.text {
+	case "+=":
+		r, err := c.register()
+		if err != nil {
+			return err
+		}
+		return c.op(0xF01E | uint16(r)<<8)
+	case ":=":
+	default:
+		return c.errorAt(t, "expected := or += after i, got %s", describe(t))
+	}
+	switch p := c.peek(); p.text {
+	case "hex", "bighex":
+		c.next()
+		r, err := c.register()
+		if err != nil {
+			return err
+		}
+		if p.text == "hex" {
+			return c.op(0xF029 | uint16(r)<<8)
+		}
+		return c.op(0xF030 | uint16(r)<<8)
+	case "long":
+		c.next()
+		if err := c.op(0xF000); err != nil {
+			return err
+		}
+		addr, err := c.addr(fix16, c.here, 0)
+		if err != nil {
+			return err
+		}
+		return c.op(uint16(addr))
+	}
+	addr, err := c.addr(fix12, c.here, 0)
+	if err != nil {
+		return err
+	}
+	return c.op(0xA000 | uint16(addr))
+}
+
+// ifStatement compiles if ... then, which skips the next statement unless the
+// condition holds, and if ... begin, which starts a block.
+func (c *compiler) ifStatement(t token) error {
+	n := 3
+	if k := c.peekAt(1); (k.text == "key" || k.text == "-key") && !k.str {
+		n = 2
+	}
+	switch w := c.peekAt(n); w.text {
+	case "then":
+		if err := c.conditional(false); err != nil {
+			return err
+		}
+	case "begin":
+		if err := c.conditional(true); err != nil {
+			return err
+		}
+		c.branches = append(c.branches, branch{addr: c.here, t: t})
+		if err := c.op(0x1000); err != nil {
+			return err
+		}
+	default:
+		return c.errorAt(w, "expected then or begin, got %s", describe(w))
+	}
+	c.next()
+	return nil
+}
+
+// conditional compiles a comparison into instructions that skip the next one
+// when it is false, or when it is true if negated.
+func (c *compiler) conditional(negated bool) error {
+	x, err := c.register()
+	if err != nil {
+		return err
+	}
+	t := c.next()
+	cmp, ok := inverse[t.text]
+	if !ok || t.str {
+		return c.errorAt(t, "expected a comparison, got %s", describe(t))
+	}
+	if !negated {
+		cmp = t.text
+	}
+	switch cmp {
+	case "key":
+		return c.op(0xE0A1 | uint16(x)<<8)
+	case "-key":
+		return c.op(0xE09E | uint16(x)<<8)
+	}
+	src := c.next()
+	y, isReg := c.reg(src)
+	var n int
+	if !isReg {
+		if n, err = c.valueOf(src, 8); err != nil {
+			return err
+		}
+	}
+	switch cmp {
+	case "==":
+		if isReg {
+			return c.op(0x9000 | uint16(x)<<8 | uint16(y)<<4)
+		}
+		return c.op(0x4000 | uint16(x)<<8 | uint16(n))
+	case "!=":
+		if isReg {
+			return c.op(0x5000 | uint16(x)<<8 | uint16(y)<<4)
+		}
+		return c.op(0x3000 | uint16(x)<<8 | uint16(n))
+	}
+	// Subtract using a temporary register, and skip on the borrow flag
+	tmp := uint16(c.aliases["compare-temp"]) << 8
+	if isReg {
+		err = c.op(0x8000 | tmp | uint16(y)<<4)
+	} else {
+		err = c.op(0x6000 | tmp | uint16(n))
+	}
+	if err != nil {
+		return err
+	}
+	sub := uint16(0x8005)
+	if cmp == "<" || cmp == ">=" {
+		sub = 0x8007
+	}
+	if err := c.op(sub | tmp | uint16(x)<<4); err != nil {
+		return err
+	}
+	if cmp == ">" || cmp == "<" {
+		return c.op(0x3F01)
+	}
+	return c.op(0x4F01)
+}
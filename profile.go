@@ -0,0 +1,384 @@
+package chip8
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// mainRoutine stands for the code that isn't in any subroutine.
+const mainRoutine = -1
+
+// routineName names the subroutine at entry by its label, or by its address.
+func routineName(syms *Symbols, entry int) string {
+	if entry == mainRoutine {
+		return "main"
+	}
+	if label, ok := syms.Label(uint16(entry)); ok {
+		return label
+	}
+	return fmt.Sprintf("sub_%03X", entry)
+}
+
+// profChain is a call stack seen by a Profiler.
+type profChain struct {
+	// sites are the calls in progress, innermost first
+	sites []uint16
+	// routines are the subroutines the stack is in, innermost first, ending
+	// with mainRoutine
+	routines []int
+}
+
+// profSample is an address run with a particular call stack.
+type profSample struct {
+	chain int
+	pc    uint16
+}
+
+// Profiler counts how often each address runs and in which subroutines. Each
+// instruction counts as one cycle. Install it with SetProfiler.
+type Profiler struct {
+	mu     sync.Mutex
+	counts [XO_MAX_MEM_ADDRESS]uint64
+	total  uint64
+	// chain is the call stack as of sp, which only needs looking up again
+	// when sp changes
+	sp       int
+	chain    int
+	chainIDs map[string]int
+	chains   []profChain
+	samples  map[profSample]uint64
+	symbols  *Symbols
+	dis      Disassembler
+}
+
+func NewProfiler() *Profiler {
+	return &Profiler{
+		sp:       -1,
+		chainIDs: make(map[string]int),
+		samples:  make(map[profSample]uint64),
+	}
+}
+
+// SetSymbols makes reports name addresses and subroutines with labels.
+func (p *Profiler) SetSymbols(s *Symbols) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.symbols = s
+	p.dis.SetSymbols(s)
+}
+
+// Reset forgets everything counted so far.
+func (p *Profiler) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.counts = [XO_MAX_MEM_ADDRESS]uint64{}
+	p.total = 0
+	p.sp = -1
+	p.chainIDs = make(map[string]int)
+	p.chains = nil
+	p.samples = make(map[profSample]uint64)
+}
+
+// lookupChain returns the ID of the call stack in c.stack from sp.
+func (p *Profiler) lookupChain(c *Chip8, sp int) int {
+	top := sp / 2
+	if top < 0 {
+		top = 0
+	}
+	if top > len(c.stack) {
+		top = len(c.stack)
+	}
+	sites := c.stack[top:]
+	key := make([]byte, 2*len(sites))
+	for n, site := range sites {
+		binary.BigEndian.PutUint16(key[2*n:], site)
+	}
+	if id, ok := p.chainIDs[string(key)]; ok {
+		return id
+	}
+	chain := profChain{sites: append([]uint16(nil), sites...)}
+	for _, site := range sites {
+		entry := 0
+		if int(site)+1 < len(c.mem) {
+			entry = int(ArgNNN(binary.BigEndian.Uint16(c.mem[site:])))
+		}
+		chain.routines = append(chain.routines, entry)
+	}
+	chain.routines = append(chain.routines, mainRoutine)
+	id := len(p.chains)
+	p.chains = append(p.chains, chain)
+	p.chainIDs[string(key)] = id
+	return id
+}
+
+// count records the instruction at pc, which ran with the stack pointer at sp.
+func (p *Profiler) count(c *Chip8, pc uint16, sp int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if sp != p.sp {
+		p.chain = p.lookupChain(c, sp)
+		p.sp = sp
+	}
+	p.counts[pc]++
+	p.total++
+	p.samples[profSample{p.chain, pc}]++
+}
+
+// Total returns how many instructions have been counted.
+func (p *Profiler) Total() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.total
+}
+
+// Hotspot is how many times an address ran.
+type Hotspot struct {
+	Addr  uint16
+	Count uint64
+}
+
+// Hotspots returns the n addresses that ran the most, most first.
+func (p *Profiler) Hotspots(n int) []Hotspot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var spots []Hotspot
+	for addr, count := range p.counts {
+		if count > 0 {
+			spots = append(spots, Hotspot{uint16(addr), count})
+		}
+	}
+	sort.SliceStable(spots, func(i, j int) bool {
+		return spots[i].Count > spots[j].Count
+	})
+	if n < len(spots) {
+		spots = spots[:n]
+	}
+	return spots
+}
+
+// RoutineProfile is the cycles spent in a subroutine. Exclusive counts only
+// its own instructions, and Inclusive adds those of the subroutines it calls.
+type RoutineProfile struct {
+	Name string
+	// Entry is the address of the subroutine, or -1 for code outside any
+	Entry     int
+	Inclusive uint64
+	Exclusive uint64
+}
+
+// Routines returns the profile of every subroutine that ran, by most
+// inclusive cycles first.
+func (p *Profiler) Routines() []RoutineProfile {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	byEntry := make(map[int]*RoutineProfile)
+	get := func(entry int) *RoutineProfile {
+		r, ok := byEntry[entry]
+		if !ok {
+			r = &RoutineProfile{Name: routineName(p.symbols, entry), Entry: entry}
+			byEntry[entry] = r
+		}
+		return r
+	}
+	for s, count := range p.samples {
+		routines := p.chains[s.chain].routines
+		get(routines[0]).Exclusive += count
+		// Count recursive calls once
+		seen := make(map[int]bool, len(routines))
+		for _, entry := range routines {
+			if !seen[entry] {
+				seen[entry] = true
+				get(entry).Inclusive += count
+			}
+		}
+	}
+	routines := make([]RoutineProfile, 0, len(byEntry))
+	for _, r := range byEntry {
+		routines = append(routines, *r)
+	}
+	sort.Slice(routines, func(i, j int) bool {
+		if routines[i].Inclusive != routines[j].Inclusive {
+			return routines[i].Inclusive > routines[j].Inclusive
+		}
+		return routines[i].Entry < routines[j].Entry
+	})
+	return routines
+}
+
+// WriteReport writes the n hottest addresses of c, with their disassembly,
+// and the cycles spent in each subroutine.
+func (p *Profiler) WriteReport(w io.Writer, c *Chip8, n int) error {
+	total := p.Total()
+	if total == 0 {
+		_, err := fmt.Fprintln(w, "No instructions profiled")
+		return err
+	}
+	percent := func(count uint64) float64 {
+		return 100 * float64(count) / float64(total)
+	}
+	fmt.Fprintf(w, "%d instructions\n\n", total)
+	fmt.Fprintf(w, "%10s %6s  %s\n", "count", "%", "address")
+	for _, s := range p.Hotspots(n) {
+		label := ""
+		if l, ok := p.symbols.Label(s.Addr); ok {
+			label = " <" + l + ">"
+		}
+		var ins instruction
+		if int(s.Addr)+1 < c.MemSize() {
+			ins = p.dis.dis(c.mem[s.Addr:])
+		}
+		fmt.Fprintf(w, "%10d %5.1f%%  0x%04X%s %s\n", s.Count, percent(s.Count), s.Addr, label, ins)
+	}
+	fmt.Fprintf(w, "\n%10s %6s %10s %6s  %s\n", "inclusive", "%", "exclusive", "%", "subroutine")
+	for _, r := range p.Routines() {
+		_, err := fmt.Fprintf(w, "%10d %5.1f%% %10d %5.1f%%  %s\n",
+			r.Inclusive, percent(r.Inclusive), r.Exclusive, percent(r.Exclusive), r.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// protoBuf encodes protocol buffers, which is all pprof needs.
+type protoBuf struct {
+	b []byte
+}
+
+func (pb *protoBuf) varint(v uint64) {
+	for v >= 0x80 {
+		pb.b = append(pb.b, byte(v)|0x80)
+		v >>= 7
+	}
+	pb.b = append(pb.b, byte(v))
+}
+
+func (pb *protoBuf) uint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	pb.varint(uint64(field) << 3)
+	pb.varint(v)
+}
+
+func (pb *protoBuf) bytes(field int, b []byte) {
+	pb.varint(uint64(field)<<3 | 2)
+	pb.varint(uint64(len(b)))
+	pb.b = append(pb.b, b...)
+}
+
+func (pb *protoBuf) packed(field int, vs []uint64) {
+	var packed protoBuf
+	for _, v := range vs {
+		packed.varint(v)
+	}
+	pb.bytes(field, packed.b)
+}
+
+// WritePprof writes a profile that go tool pprof can read, with a sample for
+// each call stack and address that ran.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var prof protoBuf
+	strs := map[string]uint64{"": 0}
+	table := []string{""}
+	str := func(s string) uint64 {
+		id, ok := strs[s]
+		if !ok {
+			id = uint64(len(table))
+			strs[s] = id
+			table = append(table, s)
+		}
+		return id
+	}
+	// Profile.sample_type and Profile.period_type
+	var valueType protoBuf
+	valueType.uint(1, str("instructions"))
+	valueType.uint(2, str("count"))
+	prof.bytes(1, valueType.b)
+	prof.bytes(11, valueType.b)
+	prof.uint(12, 1)
+
+	funcs := make(map[int]uint64)
+	funcID := func(entry int) uint64 {
+		id, ok := funcs[entry]
+		if !ok {
+			id = uint64(len(funcs) + 1)
+			funcs[entry] = id
+			// Profile.function
+			var f protoBuf
+			f.uint(1, id)
+			f.uint(2, str(routineName(p.symbols, entry)))
+			if entry != mainRoutine {
+				f.uint(5, uint64(entry))
+			}
+			prof.bytes(5, f.b)
+		}
+		return id
+	}
+	type locKey struct {
+		addr    uint16
+		routine int
+	}
+	locs := make(map[locKey]uint64)
+	locID := func(addr uint16, routine int) uint64 {
+		key := locKey{addr, routine}
+		id, ok := locs[key]
+		if !ok {
+			id = uint64(len(locs) + 1)
+			locs[key] = id
+			// Profile.location with a Line naming its function
+			var line protoBuf
+			line.uint(1, funcID(routine))
+			var l protoBuf
+			l.uint(1, id)
+			l.uint(3, uint64(addr))
+			l.bytes(4, line.b)
+			prof.bytes(4, l.b)
+		}
+		return id
+	}
+
+	samples := make([]profSample, 0, len(p.samples))
+	for s := range p.samples {
+		samples = append(samples, s)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		if samples[i].chain != samples[j].chain {
+			return samples[i].chain < samples[j].chain
+		}
+		return samples[i].pc < samples[j].pc
+	})
+	for _, s := range samples {
+		chain := p.chains[s.chain]
+		// Each call site is in the routine outside the one it called
+		ids := []uint64{locID(s.pc, chain.routines[0])}
+		for n, site := range chain.sites {
+			ids = append(ids, locID(site, chain.routines[n+1]))
+		}
+		var sample protoBuf
+		sample.packed(1, ids)
+		sample.packed(2, []uint64{p.samples[s]})
+		prof.bytes(2, sample.b)
+	}
+	for _, s := range table {
+		prof.bytes(6, []byte(s))
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(prof.b); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// SetProfiler makes c count every instruction it runs in p, or stops
+// profiling if p is nil.
+func (c *Chip8) SetProfiler(p *Profiler) {
+	c.profiler = p
+}
@@ -0,0 +1,233 @@
+package chip8
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// profileROM has main call outer, which calls inner.
+var profileROM = []byte{
+	0x22, 0x06, // 0x200 CALL outer
+	0x00, 0xFD, // 0x202 EXIT
+	0x00, 0x00,
+	0x60, 0x01, // 0x206 outer: LD V0, 1
+	0x22, 0x0E, // 0x208 CALL inner
+	0x00, 0xEE, // 0x20A RET
+	0x00, 0x00,
+	0x70, 0x01, // 0x20E inner: ADD V0, 1
+	0x70, 0x01, // 0x210 ADD V0, 1
+	0x00, 0xEE, // 0x212 RET
+}
+
+// runProfiled runs profileROM to its exit with a profiler.
+func runProfiled(t *testing.T) (*Chip8, *Profiler) {
+	t.Helper()
+	c := NewChip8(&NullDisplay{}, &NoKeypad{}, Quirks{}, WithSeed(1))
+	c.Reset()
+	if err := c.LoadROM(profileROM); err != nil {
+		t.Fatal(err)
+	}
+	syms := NewSymbols()
+	syms.Add("outer", 0x206)
+	syms.Add("inner", 0x20E)
+	p := NewProfiler()
+	p.SetSymbols(syms)
+	c.SetProfiler(p)
+	for {
+		err := c.RunOne()
+		if err == ErrExited {
+			return c, p
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProfilerRoutines(t *testing.T) {
+	_, p := runProfiled(t)
+	if p.Total() != 8 {
+		t.Errorf("Total = %d, want 8", p.Total())
+	}
+	want := []RoutineProfile{
+		{"main", mainRoutine, 8, 2},
+		{"outer", 0x206, 6, 3},
+		{"inner", 0x20E, 3, 3},
+	}
+	if got := p.Routines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Routines = %+v, want %+v", got, want)
+	}
+}
+
+func TestProfilerReport(t *testing.T) {
+	c, p := runProfiled(t)
+	var buf bytes.Buffer
+	if err := p.WriteReport(&buf, c, 3); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"8 instructions",
+		"0x0206 <outer> LD V0, 0x01",
+		"6  75.0%          3  37.5%  outer",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("report doesn't have %q:\n%s", line, buf.String())
+		}
+	}
+}
+
+// protoFields splits a protocol buffer message into its fields, with varints
+// as uint64 and everything else as []byte.
+func protoFields(t *testing.T, b []byte) (fields []int, values []interface{}) {
+	t.Helper()
+	varint := func() uint64 {
+		var v uint64
+		for shift := uint(0); ; shift += 7 {
+			if len(b) == 0 {
+				t.Fatal("truncated varint")
+			}
+			c := b[0]
+			b = b[1:]
+			v |= uint64(c&0x7F) << shift
+			if c < 0x80 {
+				return v
+			}
+		}
+	}
+	for len(b) > 0 {
+		key := varint()
+		switch key & 7 {
+		case 0:
+			fields = append(fields, int(key>>3))
+			values = append(values, varint())
+		case 2:
+			n := varint()
+			fields = append(fields, int(key>>3))
+			values = append(values, b[:n])
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return fields, values
+}
+
+// packedVarints decodes a packed repeated field.
+func packedVarints(t *testing.T, b []byte) []uint64 {
+	t.Helper()
+	// A packed field is the same varints a message of field 0 would have,
+	// less the keys, so give each a key
+	var msg []byte
+	for len(b) > 0 {
+		msg = append(msg, 0)
+		for b[0] >= 0x80 {
+			msg, b = append(msg, b[0]), b[1:]
+		}
+		msg, b = append(msg, b[0]), b[1:]
+	}
+	_, values := protoFields(t, msg)
+	vs := make([]uint64, len(values))
+	for n, v := range values {
+		vs[n] = v.(uint64)
+	}
+	return vs
+}
+
+// TestWritePprof decodes the samples of a pprof profile back into stacks of
+// function names, and checks they add up to the subroutines' cycles.
+func TestWritePprof(t *testing.T) {
+	_, p := runProfiled(t)
+	var buf bytes.Buffer
+	if err := p.WritePprof(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var strs []string
+	funcNames := make(map[uint64]uint64)
+	locFuncs := make(map[uint64]uint64)
+	type sample struct {
+		locs  []uint64
+		value uint64
+	}
+	var samples []sample
+	fields, values := protoFields(t, b)
+	for n, field := range fields {
+		switch field {
+		case 2:
+			var s sample
+			fs, vs := protoFields(t, values[n].([]byte))
+			for i, f := range fs {
+				switch f {
+				case 1:
+					s.locs = packedVarints(t, vs[i].([]byte))
+				case 2:
+					s.value = packedVarints(t, vs[i].([]byte))[0]
+				}
+			}
+			samples = append(samples, s)
+		case 4:
+			var id, fn uint64
+			fs, vs := protoFields(t, values[n].([]byte))
+			for i, f := range fs {
+				switch f {
+				case 1:
+					id = vs[i].(uint64)
+				case 4:
+					lfs, lvs := protoFields(t, vs[i].([]byte))
+					if lfs[0] == 1 {
+						fn = lvs[0].(uint64)
+					}
+				}
+			}
+			locFuncs[id] = fn
+		case 5:
+			var id, name uint64
+			fs, vs := protoFields(t, values[n].([]byte))
+			for i, f := range fs {
+				switch f {
+				case 1:
+					id = vs[i].(uint64)
+				case 2:
+					name = vs[i].(uint64)
+				}
+			}
+			funcNames[id] = name
+		case 6:
+			strs = append(strs, string(values[n].([]byte)))
+		}
+	}
+
+	inclusive := make(map[string]uint64)
+	exclusive := make(map[string]uint64)
+	for _, s := range samples {
+		var stack []string
+		for _, loc := range s.locs {
+			stack = append(stack, strs[funcNames[locFuncs[loc]]])
+		}
+		exclusive[stack[0]] += s.value
+		for n, name := range stack {
+			// Each routine once, as main appears for every call site
+			if n == 0 || name != stack[n-1] {
+				inclusive[name] += s.value
+			}
+		}
+	}
+	for _, r := range p.Routines() {
+		if inclusive[r.Name] != r.Inclusive || exclusive[r.Name] != r.Exclusive {
+			t.Errorf("pprof %s: inclusive %d exclusive %d, want %d %d",
+				r.Name, inclusive[r.Name], exclusive[r.Name], r.Inclusive, r.Exclusive)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package chip8
+
+import (
+	"fmt"
+	"sort"
+)
+
+// LoadStoreQuirk selects what happens to I after FX55 and FX65.
+type LoadStoreQuirk int
+
+const (
+	// LoadStoreIncrement leaves I pointing past the last register (I += X+1).
+	LoadStoreIncrement LoadStoreQuirk = iota
+	// LoadStoreIncrementX leaves I pointing at the last register (I += X).
+	LoadStoreIncrementX
+	// LoadStoreUnchanged leaves I untouched.
+	LoadStoreUnchanged
+)
+
+// Quirks describes the behaviours that differ between CHIP-8 interpreters.
+type Quirks struct {
+	// ShiftVx makes 8XY6 and 8XYE shift Vx in place, ignoring Vy.
+	ShiftVx bool
+	// LoadStore selects how FX55 and FX65 modify I.
+	LoadStore LoadStoreQuirk
+	// JumpVx makes BNNN jump to XNN plus Vx instead of NNN plus V0.
+	JumpVx bool
+	// Clip makes sprites clip at the edges of the screen instead of wrapping.
+	Clip bool
+	// VFReset makes 8XY1, 8XY2 and 8XY3 reset VF to zero.
+	VFReset bool
+	// XOChip enables the XO-CHIP instructions, 64K of memory and the second
+	// bitplane.
+	XOChip bool
+}
+
+// QuirkProfiles holds the quirks of well known interpreters.
+var QuirkProfiles = map[string]Quirks{
+	// original is how this emulator behaved before quirks could be chosen.
+	"original": {
+		ShiftVx:   true,
+		LoadStore: LoadStoreUnchanged,
+		JumpVx:    false,
+		Clip:      false,
+		VFReset:   false,
+	},
+	"vip": {
+		ShiftVx:   false,
+		LoadStore: LoadStoreIncrement,
+		JumpVx:    false,
+		Clip:      true,
+		VFReset:   true,
+	},
+	"chip48": {
+		ShiftVx:   true,
+		LoadStore: LoadStoreIncrementX,
+		JumpVx:    true,
+		Clip:      true,
+		VFReset:   false,
+	},
+	"schip": {
+		ShiftVx:   true,
+		LoadStore: LoadStoreUnchanged,
+		JumpVx:    true,
+		Clip:      true,
+		VFReset:   false,
+	},
+	"xochip": {
+		ShiftVx:   false,
+		LoadStore: LoadStoreIncrement,
+		JumpVx:    false,
+		Clip:      false,
+		VFReset:   false,
+		XOChip:    true,
+	},
+}
+
+// DefaultQuirks is the profile used when none is specified.
+const DefaultQuirks = "original"
+
+// QuirksByName looks up one of the QuirkProfiles.
+func QuirksByName(name string) (Quirks, error) {
+	q, ok := QuirkProfiles[name]
+	if !ok {
+		return Quirks{}, fmt.Errorf("unknown quirks profile %q (have %v)", name, QuirkNames())
+	}
+	return q, nil
+}
+
+// QuirkNames returns the sorted names of the QuirkProfiles.
+func QuirkNames() []string {
+	names := make([]string, 0, len(QuirkProfiles))
+	for name := range QuirkProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
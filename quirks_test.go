@@ -0,0 +1,77 @@
+package chip8
+
+import "testing"
+
+// runQuirk runs op after setup on a machine with quirks q.
+func runQuirk(t *testing.T, q Quirks, setup func(c *Chip8), op uint16) *Chip8 {
+	c := NewChip8(&NullDisplay{}, &NoKeypad{}, q)
+	c.Reset()
+	if err := c.LoadROM([]byte{byte(op >> 8), byte(op)}); err != nil {
+		t.Fatal(err)
+	}
+	setup(c)
+	if err := c.RunOne(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestQuirkProfiles(t *testing.T) {
+	tests := []struct {
+		profile string
+		// shr and shl are V1 and VF after 8126 and 812E
+		shr, shrVF, shl, shlVF byte
+		// i is I after F255 from 0x300
+		i uint16
+		// pc is where B210 jumps with V0 1 and V2 5
+		pc uint16
+		// wraps is whether a sprite drawn at the right edge wraps
+		wraps bool
+		// vf is VF after 8121 from 1
+		vf byte
+	}{
+		{"original", 0x02, 1, 0x02, 1, 0x300, 0x211, true, 1},
+		{"vip", 0x06, 0, 0x80, 0, 0x303, 0x211, false, 0},
+		{"chip48", 0x02, 1, 0x02, 1, 0x302, 0x215, false, 1},
+		{"schip", 0x02, 1, 0x02, 1, 0x300, 0x215, false, 1},
+		{"xochip", 0x06, 0, 0x80, 0, 0x303, 0x211, true, 1},
+	}
+	for _, tt := range tests {
+		q, err := QuirksByName(tt.profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := runQuirk(t, q, func(c *Chip8) { c.v[1], c.v[2] = 0x05, 0x0C }, 0x8126)
+		if c.v[1] != tt.shr || c.v[VF] != tt.shrVF {
+			t.Errorf("%s: 8XY6 gave V1 0x%02X VF %d, want 0x%02X %d", tt.profile, c.v[1], c.v[VF], tt.shr, tt.shrVF)
+		}
+		c = runQuirk(t, q, func(c *Chip8) { c.v[1], c.v[2] = 0x81, 0x40 }, 0x812E)
+		if c.v[1] != tt.shl || c.v[VF] != tt.shlVF {
+			t.Errorf("%s: 8XYE gave V1 0x%02X VF %d, want 0x%02X %d", tt.profile, c.v[1], c.v[VF], tt.shl, tt.shlVF)
+		}
+		for _, op := range []uint16{0xF255, 0xF265} {
+			c = runQuirk(t, q, func(c *Chip8) { c.i = 0x300 }, op)
+			if c.i != tt.i {
+				t.Errorf("%s: %04X left I at 0x%X, want 0x%X", tt.profile, op, c.i, tt.i)
+			}
+		}
+		c = runQuirk(t, q, func(c *Chip8) { c.v[0], c.v[2] = 1, 5 }, 0xB210)
+		if c.pc != tt.pc {
+			t.Errorf("%s: BNNN jumped to 0x%X, want 0x%X", tt.profile, c.pc, tt.pc)
+		}
+		c = runQuirk(t, q, func(c *Chip8) {
+			c.mem[0x300], c.i = 0xFF, 0x300
+			c.v[3] = SCREEN_WIDTH - 2
+		}, 0xD341)
+		if wraps := c.Screen().At(0, 0) != 0; wraps != tt.wraps {
+			t.Errorf("%s: DXYN wrapped %v, want %v", tt.profile, wraps, tt.wraps)
+		}
+		c = runQuirk(t, q, func(c *Chip8) { c.v[VF] = 1 }, 0x8121)
+		if c.v[VF] != tt.vf {
+			t.Errorf("%s: 8XY1 left VF %d, want %d", tt.profile, c.v[VF], tt.vf)
+		}
+	}
+	if len(tests) != len(QuirkProfiles) {
+		t.Errorf("tested %d profiles, there are %d", len(tests), len(QuirkProfiles))
+	}
+}
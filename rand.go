@@ -0,0 +1,27 @@
+package chip8
+
+// splitMix is a small rand.Source whose whole state is one word, so it can be
+// saved and restored along with the rest of the machine.
+type splitMix struct {
+	state uint64
+}
+
+func newSplitMix(seed int64) *splitMix {
+	return &splitMix{uint64(seed)}
+}
+
+func (s *splitMix) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+func (s *splitMix) Uint64() uint64 {
+	s.state += 0x9E3779B97F4A7C15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	return z ^ (z >> 31)
+}
+
+func (s *splitMix) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
@@ -0,0 +1,132 @@
+package chip8
+
+// run is a span of bytes at an offset into a buffer.
+type run struct {
+	off  int
+	data []byte
+}
+
+// diffRuns returns the spans of prev that differ from cur, which is all it
+// takes to turn cur back into prev.
+func diffRuns(cur, prev []byte) []run {
+	var runs []run
+	for i := 0; i < len(cur); i++ {
+		if cur[i] == prev[i] {
+			continue
+		}
+		start := i
+		for i < len(cur) && cur[i] != prev[i] {
+			i++
+		}
+		data := make([]byte, i-start)
+		copy(data, prev[start:i])
+		runs = append(runs, run{start, data})
+	}
+	return runs
+}
+
+func applyRuns(buf []byte, runs []run) {
+	for _, r := range runs {
+		copy(buf[r.off:], r.data)
+	}
+}
+
+// rewindFrame turns a captured state into the one captured before it.
+type rewindFrame struct {
+	mem    []run
+	screen []run
+	regs   registerState
+}
+
+// Rewinder keeps a ring of recent machine states so play can be wound back.
+// Only the newest state is kept whole, each older one is stored as the bytes
+// that differ from the state after it.
+type Rewinder struct {
+	frames  []rewindFrame
+	head    int
+	count   int
+	last    *machineState
+	scratch *machineState
+	have    bool
+}
+
+// NewRewinder returns a Rewinder that can go back up to frames captures.
+func NewRewinder(frames int) *Rewinder {
+	return &Rewinder{
+		frames:  make([]rewindFrame, frames),
+		last:    &machineState{},
+		scratch: &machineState{},
+	}
+}
+
+// Len returns how many captures back the Rewinder can go.
+func (r *Rewinder) Len() int {
+	return r.count
+}
+
+// Reset forgets all captured states.
+func (r *Rewinder) Reset() {
+	for i := range r.frames {
+		r.frames[i] = rewindFrame{}
+	}
+	r.head = 0
+	r.count = 0
+	r.have = false
+}
+
+// Capture records the current state of c, usually once per frame.
+func (r *Rewinder) Capture(c *Chip8) {
+	if len(r.frames) == 0 {
+		return
+	}
+	c.snapshot(r.scratch)
+	if r.have {
+		r.frames[r.head] = rewindFrame{
+			mem:    diffRuns(r.scratch.Mem[:], r.last.Mem[:]),
+			screen: diffRuns(r.scratch.Screen[:], r.last.Screen[:]),
+			regs:   r.last.Regs,
+		}
+		r.head = (r.head + 1) % len(r.frames)
+		if r.count < len(r.frames) {
+			r.count++
+		}
+	}
+	r.last, r.scratch = r.scratch, r.last
+	r.have = true
+}
+
+// Rewind puts c back to the state captured n captures before the latest one,
+// or as far as the buffer goes, and returns how many it went back. Captures
+// newer than the restored one are dropped.
+func (r *Rewinder) Rewind(c *Chip8, n int) int {
+	if !r.have {
+		return 0
+	}
+	done := 0
+	for ; done < n && r.count > 0; done++ {
+		r.back()
+	}
+	c.restore(r.last)
+	return done
+}
+
+// back makes the capture before the latest one the latest.
+func (r *Rewinder) back() {
+	r.head = (r.head - 1 + len(r.frames)) % len(r.frames)
+	f := r.frames[r.head]
+	r.frames[r.head] = rewindFrame{}
+	r.count--
+	applyRuns(r.last.Mem[:], f.mem)
+	applyRuns(r.last.Screen[:], f.screen)
+	r.last.Regs = f.regs
+}
+
+// Drop forgets the latest capture without touching the machine, for when the
+// machine has been wound back past it some other way.
+func (r *Rewinder) Drop() {
+	if r.count > 0 {
+		r.back()
+		return
+	}
+	r.have = false
+}
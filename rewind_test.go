@@ -0,0 +1,58 @@
+package chip8
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDiffRuns(t *testing.T) {
+	prev := []byte{1, 2, 3, 4, 5, 6}
+	cur := []byte{1, 9, 9, 4, 5, 9}
+	runs := diffRuns(cur, prev)
+	if len(runs) != 2 {
+		t.Fatalf("got %d runs, want 2", len(runs))
+	}
+	applyRuns(cur, runs)
+	if !bytes.Equal(cur, prev) {
+		t.Errorf("applying the runs gave %v, want %v", cur, prev)
+	}
+}
+
+func TestRewind(t *testing.T) {
+	c := newTestChip8(t, Quirks{})
+	r := NewRewinder(8)
+	var states []machineState
+	for i := 0; i < 20; i++ {
+		var s machineState
+		c.snapshot(&s)
+		states = append(states, s)
+		r.Capture(c)
+		if err := c.RunOne(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.Len() != 8 {
+		t.Fatalf("Len is %d, want 8", r.Len())
+	}
+
+	var got machineState
+	if n := r.Rewind(c, 3); n != 3 {
+		t.Errorf("rewound %d, want 3", n)
+	}
+	c.snapshot(&got)
+	if got != states[len(states)-4] {
+		t.Error("rewinding 3 didn't restore the state captured 3 before the latest")
+	}
+
+	// Only what's left in the ring can be undone
+	if n := r.Rewind(c, 100); n != 5 {
+		t.Errorf("rewound %d, want 5", n)
+	}
+	c.snapshot(&got)
+	if got != states[len(states)-9] {
+		t.Error("rewinding past the buffer didn't stop at the oldest capture")
+	}
+	if r.Len() != 0 {
+		t.Errorf("Len is %d after rewinding everything, want 0", r.Len())
+	}
+}
@@ -0,0 +1,683 @@
+package chip8
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// StopReason says why execution stopped.
+type StopReason int
+
+const (
+	// StopStep is a step finishing
+	StopStep StopReason = iota
+	StopBreakpoint
+	StopTempBreakpoint
+	StopWatchpoint
+	// StopHalt is Break interrupting execution
+	StopHalt
+	// StopError is an instruction failing, such as an illegal opcode
+	StopError
+	StopExited
+	// StopHistory is reverse execution running out of recorded history
+	StopHistory
+)
+
+// Stop describes where and why execution stopped.
+type Stop struct {
+	Reason StopReason
+	PC     uint16
+	// Watchpoint and Access are the watchpoint hit and the access that hit it
+	Watchpoint int
+	Access     MemAccess
+	Err        error
+	// Cycles is how many instructions ran before stopping
+	Cycles int
+}
+
+func (s Stop) String() string {
+	switch s.Reason {
+	case StopStep:
+		return fmt.Sprintf("Stopped at 0x%04X", s.PC)
+	case StopBreakpoint:
+		return fmt.Sprintf("Hit breakpoint at 0x%04X", s.PC)
+	case StopTempBreakpoint:
+		return fmt.Sprintf("Hit temp breakpoint at 0x%04X", s.PC)
+	case StopWatchpoint:
+		a := s.Access
+		if a.Kind == MemWrite {
+			return fmt.Sprintf("Hit watchpoint %d (%s) at 0x%04X: 0x%02X -> 0x%02X by PC 0x%04X",
+				s.Watchpoint, a.Kind, a.Addr, a.Old, a.New, a.PC)
+		}
+		return fmt.Sprintf("Hit watchpoint %d (%s) at 0x%04X: 0x%02X by PC 0x%04X",
+			s.Watchpoint, a.Kind, a.Addr, a.Old, a.PC)
+	case StopHalt:
+		return "Received halt"
+	case StopHistory:
+		return "Reached the start of recorded history"
+	}
+	return s.Err.Error()
+}
+
+// EventKind is the kind of an Event.
+type EventKind int
+
+const (
+	// EventContinued is sent when execution starts running freely
+	EventContinued EventKind = iota
+	// EventStopped is sent when execution stops, with Stop saying why
+	EventStopped
+	// EventChanged is sent when the machine changes other than by running,
+	// such as by reset or loading a state
+	EventChanged
+)
+
+// Event tells front ends that the session changed state.
+type Event struct {
+	Kind EventKind
+	Stop Stop
+}
+
+// Registers is a copy of the machine's registers.
+type Registers struct {
+	V     [16]byte
+	I     uint16
+	PC    uint16
+	SP    int
+	Delay byte
+	Sound byte
+	Stack [24]uint16
+}
+
+type Breakpoint struct {
+	Addr     uint16
+	Enabled  bool
+	TimesHit int
+	// Cond must evaluate to non-zero for the breakpoint to be hit
+	Cond *Expr
+	// Ignore is how many more hits to run through without stopping
+	Ignore int
+}
+
+// matches checks whether the breakpoint is enabled and its condition holds,
+// without counting a hit.
+func (b Breakpoint) matches(c *Chip8) bool {
+	return b.Enabled && (b.Cond == nil || b.Cond.Eval(c, b.TimesHit) != 0)
+}
+
+// Watchpoint stops execution when memory in [Start, End) is accessed in one
+// of the ways in Kind.
+type Watchpoint struct {
+	ID       int
+	Start    int
+	End      int
+	Kind     MemAccessKind
+	Enabled  bool
+	TimesHit int
+}
+
+// Session is the debugger engine. It runs a machine under breakpoints and
+// watchpoints, returning what happened as structured results and sending
+// events to listeners, and leaves presentation to front ends.
+type Session struct {
+	c      *Chip8
+	rom    string
+	quirks Quirks
+	opts   []Option
+	bps    map[uint16]Breakpoint
+	tbps   map[uint16]Breakpoint
+	wps    map[int]Watchpoint
+	nextWP int
+	// watchHit is the first watchpoint hit by the running instruction
+	watchHit  *Stop
+	dis       Disassembler
+	symbols   *Symbols
+	sources   *SourceMap
+	rewind    *Rewinder
+	undo      *UndoLog
+	cycles    int
+	running   int32
+	halt      int32
+	listeners []func(Event)
+	// romData is loaded instead of the ROM file if set
+	romData []byte
+}
+
+const (
+	// rewindFrames is how many frames the debugger can rewind.
+	rewindFrames = 60 * 60
+	// rewindFrameCycles is how many instructions make a frame for rewinding.
+	// The debugger runs at ~500Hz so this approximates 60Hz.
+	rewindFrameCycles = 8
+	// undoInstructions is how many instructions can be reverse stepped.
+	undoInstructions = 100000
+)
+
+// NewSession returns a session for rom. Attach creates the machine.
+func NewSession(rom string, q Quirks, opts ...Option) *Session {
+	return &Session{
+		rom:    rom,
+		quirks: q,
+		opts:   opts,
+		bps:    make(map[uint16]Breakpoint),
+		tbps:   make(map[uint16]Breakpoint),
+		wps:    make(map[int]Watchpoint),
+		nextWP: 1,
+		dis:    Disassembler{},
+		rewind: NewRewinder(rewindFrames),
+		undo:   NewUndoLog(undoInstructions),
+	}
+}
+
+// Attach creates the machine, drawing and reading keys with r and k, and
+// loads the ROM into it.
+func (s *Session) Attach(r Renderer, k Keypad) error {
+	s.c = NewChip8(r, k, s.quirks, s.opts...)
+	if err := s.load(); err != nil {
+		return err
+	}
+	go s.c.KeepTime()
+	s.c.Render()
+	return nil
+}
+
+// SetROM sets the program to run, such as one just compiled, instead of
+// reading the ROM file.
+func (s *Session) SetROM(data []byte) {
+	s.romData = data
+}
+
+// loadROM loads the program into the machine.
+func (s *Session) loadROM() error {
+	if s.romData != nil {
+		return s.c.LoadROM(s.romData)
+	}
+	return s.c.LoadBinary(s.rom)
+}
+
+// load resets the machine and loads the ROM into it.
+func (s *Session) load() error {
+	s.c.Reset()
+	if err := s.loadROM(); err != nil {
+		return err
+	}
+	s.rewind.Capture(s.c)
+	s.c.SetMemHook(s.checkWatchpoints)
+	return nil
+}
+
+// Machine returns the machine being debugged.
+func (s *Session) Machine() *Chip8 {
+	return s.c
+}
+
+// SetSourceMap sets the map used to show and break on source lines.
+func (s *Session) SetSourceMap(m *SourceMap) {
+	s.sources = m
+}
+
+// SetSymbols sets the labels that addresses can be given and shown as.
+func (s *Session) SetSymbols(syms *Symbols) {
+	s.symbols = syms
+	s.dis.SetSymbols(syms)
+}
+
+// Symbols returns the session's labels, which may be nil.
+func (s *Session) Symbols() *Symbols {
+	return s.symbols
+}
+
+// Listen adds f to the functions called with every event. Events are sent
+// from whichever goroutine is running the session.
+func (s *Session) Listen(f func(Event)) {
+	s.listeners = append(s.listeners, f)
+}
+
+func (s *Session) emit(e Event) {
+	for _, f := range s.listeners {
+		f(e)
+	}
+}
+
+func (s *Session) stopped(stop Stop) Stop {
+	s.emit(Event{Kind: EventStopped, Stop: stop})
+	return stop
+}
+
+func (s *Session) changed() {
+	s.emit(Event{Kind: EventChanged})
+}
+
+// parseAddr parses an address, checking it against the memory available in
+// the current mode. It can be a number, a label, or a label plus an offset
+// like sprites+8.
+func (s *Session) parseAddr(str string) (uint16, error) {
+	addr, err := strconv.ParseUint(str, 0, 16)
+	if err != nil {
+		label, off := str, uint64(0)
+		if i := strings.LastIndex(str, "+"); i > 0 {
+			if off, err = strconv.ParseUint(str[i+1:], 0, 16); err != nil {
+				return 0, fmt.Errorf("couldn't parse address from %s", str)
+			}
+			label = str[:i]
+		}
+		a, ok := s.symbols.Addr(label)
+		if !ok {
+			return 0, fmt.Errorf("couldn't parse address from %s", str)
+		}
+		addr = uint64(a) + off
+	}
+	if int(addr) >= s.c.MemSize() {
+		return 0, fmt.Errorf("addr out of range")
+	}
+	return uint16(addr), nil
+}
+
+// Registers returns a copy of the machine's registers.
+func (s *Session) Registers() Registers {
+	c := s.c
+	return Registers{c.v, c.i, c.pc, c.sp, c.delay, c.sound, c.stack}
+}
+
+// Examine returns a copy of n bytes of memory at addr, fewer if that runs
+// past the end of memory.
+func (s *Session) Examine(addr uint16, n int) []byte {
+	start, end := int(addr), int(addr)+n
+	if end > s.c.MemSize() {
+		end = s.c.MemSize()
+	}
+	if start > end {
+		start = end
+	}
+	return append([]byte(nil), s.c.mem[start:end]...)
+}
+
+// SetMem writes data to memory at addr.
+func (s *Session) SetMem(addr uint16, data []byte) error {
+	if int(addr)+len(data) > s.c.MemSize() {
+		return fmt.Errorf("addr out of range")
+	}
+	copy(s.c.mem[addr:], data)
+	return nil
+}
+
+func (s *Session) breaks(temp bool) map[uint16]Breakpoint {
+	if temp {
+		return s.tbps
+	}
+	return s.bps
+}
+
+// AddBreakpoint sets a breakpoint at addr that stops if cond, when given, is
+// true. Temporary breakpoints are removed once hit.
+func (s *Session) AddBreakpoint(addr uint16, cond *Expr, temp bool) {
+	s.breaks(temp)[addr] = Breakpoint{Addr: addr, Enabled: true, Cond: cond}
+}
+
+// Breakpoint returns the breakpoint at addr, if there is one.
+func (s *Session) Breakpoint(addr uint16, temp bool) (Breakpoint, bool) {
+	b, ok := s.breaks(temp)[addr]
+	return b, ok
+}
+
+// RemoveBreakpoint removes the breakpoint at addr, returning false if there
+// wasn't one.
+func (s *Session) RemoveBreakpoint(addr uint16, temp bool) bool {
+	breaks := s.breaks(temp)
+	_, ok := breaks[addr]
+	delete(breaks, addr)
+	return ok
+}
+
+// updateBreakpoint changes the breakpoint at addr with f, returning false if
+// there isn't one.
+func (s *Session) updateBreakpoint(addr uint16, temp bool, f func(*Breakpoint)) bool {
+	breaks := s.breaks(temp)
+	b, ok := breaks[addr]
+	if ok {
+		f(&b)
+		breaks[addr] = b
+	}
+	return ok
+}
+
+// EnableBreakpoint enables or disables the breakpoint at addr.
+func (s *Session) EnableBreakpoint(addr uint16, temp, enabled bool) bool {
+	return s.updateBreakpoint(addr, temp, func(b *Breakpoint) { b.Enabled = enabled })
+}
+
+// SetBreakpointCondition sets the condition of the breakpoint at addr, or
+// makes it unconditional if cond is nil.
+func (s *Session) SetBreakpointCondition(addr uint16, cond *Expr) bool {
+	return s.updateBreakpoint(addr, false, func(b *Breakpoint) { b.Cond = cond })
+}
+
+// SetBreakpointIgnore makes the breakpoint at addr run through its next n
+// hits.
+func (s *Session) SetBreakpointIgnore(addr uint16, n int) bool {
+	return s.updateBreakpoint(addr, false, func(b *Breakpoint) { b.Ignore = n })
+}
+
+// Breakpoints returns the breakpoints sorted by address.
+func (s *Session) Breakpoints(temp bool) []Breakpoint {
+	var bps []Breakpoint
+	for _, b := range s.breaks(temp) {
+		bps = append(bps, b)
+	}
+	sort.Slice(bps, func(i, j int) bool { return bps[i].Addr < bps[j].Addr })
+	return bps
+}
+
+// hitBreakpoint checks whether the breakpoint at addr, if any, should stop
+// execution, updating its hit and ignore counts.
+func (s *Session) hitBreakpoint(breaks map[uint16]Breakpoint, addr uint16) bool {
+	v, ok := breaks[addr]
+	if !ok || !v.matches(s.c) {
+		return false
+	}
+	v.TimesHit++
+	defer func() { breaks[addr] = v }()
+	if v.Ignore > 0 {
+		v.Ignore--
+		return false
+	}
+	return true
+}
+
+// AddWatchpoint watches [start, end) for the accesses in kind, returning the
+// new watchpoint's number.
+func (s *Session) AddWatchpoint(start, end int, kind MemAccessKind) int {
+	id := s.nextWP
+	s.nextWP++
+	s.wps[id] = Watchpoint{id, start, end, kind, true, 0}
+	return id
+}
+
+// RemoveWatchpoint removes watchpoint id, returning false if there isn't one.
+func (s *Session) RemoveWatchpoint(id int) bool {
+	_, ok := s.wps[id]
+	delete(s.wps, id)
+	return ok
+}
+
+// EnableWatchpoint enables or disables watchpoint id.
+func (s *Session) EnableWatchpoint(id int, enabled bool) bool {
+	w, ok := s.wps[id]
+	if ok {
+		w.Enabled = enabled
+		s.wps[id] = w
+	}
+	return ok
+}
+
+// Watchpoints returns the watchpoints sorted by number.
+func (s *Session) Watchpoints() []Watchpoint {
+	var wps []Watchpoint
+	for _, w := range s.wps {
+		wps = append(wps, w)
+	}
+	sort.Slice(wps, func(i, j int) bool { return wps[i].ID < wps[j].ID })
+	return wps
+}
+
+// checkWatchpoints is the memory hook that notes the first watchpoint hit by
+// an instruction.
+func (s *Session) checkWatchpoints(a MemAccess) {
+	for id, w := range s.wps {
+		if !w.Enabled || a.Kind&w.Kind == 0 || int(a.Addr) < w.Start || int(a.Addr) >= w.End {
+			continue
+		}
+		w.TimesHit++
+		s.wps[id] = w
+		if s.watchHit == nil || id < s.watchHit.Watchpoint {
+			s.watchHit = &Stop{Reason: StopWatchpoint, PC: a.PC, Watchpoint: id, Access: a}
+		}
+	}
+}
+
+// step executes one instruction, recording it for reverse stepping and
+// capturing a rewind frame every rewindFrameCycles instructions. It returns
+// true if execution should stop because of a watchpoint or error.
+func (s *Session) step() (Stop, bool) {
+	s.watchHit = nil
+	err := s.undo.Step(s.c)
+	s.cycles++
+	if s.cycles%rewindFrameCycles == 0 {
+		s.rewind.Capture(s.c)
+	}
+	if s.c.RenderFlag {
+		s.c.Render()
+	}
+	switch {
+	case err == ErrExited:
+		return Stop{Reason: StopExited, PC: s.c.pc, Err: err}, true
+	case err != nil:
+		return Stop{Reason: StopError, PC: s.c.pc, Err: err}, true
+	case s.watchHit != nil:
+		return *s.watchHit, true
+	}
+	return Stop{Reason: StopStep, PC: s.c.pc}, false
+}
+
+// advance runs one instruction while running freely, first stopping at any
+// breakpoint unless leaving it.
+func (s *Session) advance(leaving bool) (Stop, bool) {
+	pc := s.c.pc
+	if !leaving {
+		if s.hitBreakpoint(s.bps, pc) {
+			return Stop{Reason: StopBreakpoint, PC: pc}, true
+		}
+		if s.hitBreakpoint(s.tbps, pc) {
+			delete(s.tbps, pc)
+			return Stop{Reason: StopTempBreakpoint, PC: pc}, true
+		}
+	}
+	return s.step()
+}
+
+// run runs at the debugger's pace, about 500Hz, until something stops it or
+// done returns true.
+func (s *Session) run(done func() bool) Stop {
+	atomic.StoreInt32(&s.halt, 0)
+	atomic.StoreInt32(&s.running, 1)
+	s.emit(Event{Kind: EventContinued})
+	tick := time.NewTicker(2 * time.Millisecond)
+	defer tick.Stop()
+	leaving := true // So we can continue through a breakpoint
+	start := s.cycles
+	var stop Stop
+	for range tick.C {
+		if atomic.LoadInt32(&s.halt) != 0 {
+			stop = Stop{Reason: StopHalt, PC: s.c.pc}
+			break
+		}
+		var ok bool
+		if stop, ok = s.advance(leaving); ok {
+			break
+		}
+		leaving = false
+		if done != nil && done() {
+			break
+		}
+	}
+	atomic.StoreInt32(&s.running, 0)
+	stop.Cycles = s.cycles - start
+	return s.stopped(stop)
+}
+
+// Running reports whether Continue or another command that runs freely is in
+// progress.
+func (s *Session) Running() bool {
+	return atomic.LoadInt32(&s.running) != 0
+}
+
+// Break stops a running session. It is safe to call from any goroutine.
+func (s *Session) Break() {
+	atomic.StoreInt32(&s.halt, 1)
+}
+
+// Step runs one instruction.
+func (s *Session) Step() Stop {
+	stop, _ := s.step()
+	stop.Cycles = 1
+	return s.stopped(stop)
+}
+
+// Depth returns how many subroutine calls are in progress.
+func (s *Session) Depth() int {
+	return len(s.c.stack) - s.c.sp/2
+}
+
+// Frame is a subroutine call in progress.
+type Frame struct {
+	// CallSite is the address of the call, which returns to CallSite+2
+	CallSite uint16
+	Target   uint16
+	// Call is false if there is no call to Target at CallSite, because the
+	// stack or the code has been overwritten since
+	Call bool
+}
+
+// Backtrace returns the calls in progress, innermost first.
+func (s *Session) Backtrace() []Frame {
+	c := s.c
+	var frames []Frame
+	for n := c.sp / 2; n >= 0 && n < len(c.stack); n++ {
+		f := Frame{CallSite: c.stack[n]}
+		if int(f.CallSite)+1 < c.MemSize() {
+			ins := uint16(c.mem[f.CallSite])<<8 | uint16(c.mem[f.CallSite+1])
+			f.Target = ArgNNN(ins)
+			f.Call = ins&0xF000 == 0x2000
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+// Next is like Step, except that calls are run until they return. It watches
+// the stack rather than the address after the call, so it works when the
+// subroutine returns somewhere else or calls itself.
+func (s *Session) Next() Stop {
+	if ins := s.dis.dis(s.c.code(s.c.pc)); ins.IsCall() {
+		sp := s.c.sp
+		return s.run(func() bool { return s.c.sp >= sp })
+	}
+	return s.Step()
+}
+
+// Finish runs until the current subroutine returns.
+func (s *Session) Finish() Stop {
+	sp := s.c.sp
+	return s.run(func() bool { return s.c.sp > sp })
+}
+
+// Until runs until PC reaches addr or the current subroutine returns.
+func (s *Session) Until(addr uint16) Stop {
+	sp := s.c.sp
+	return s.run(func() bool { return s.c.pc == addr || s.c.sp > sp })
+}
+
+// Advance runs n instructions.
+func (s *Session) Advance(n int) Stop {
+	start := s.cycles
+	return s.run(func() bool { return s.cycles-start >= n })
+}
+
+// Continue runs until a breakpoint, watchpoint, error or Break.
+func (s *Session) Continue() Stop {
+	return s.run(nil)
+}
+
+// undoOne undoes the last instruction. The rewind frame captured after it and
+// its cycle are dropped too, so running on from here captures frames as
+// though the instruction never ran.
+func (s *Session) undoOne() bool {
+	if !s.undo.Undo(s.c) {
+		return false
+	}
+	if s.cycles%rewindFrameCycles == 0 {
+		s.rewind.Drop()
+	}
+	s.cycles--
+	return true
+}
+
+// ReverseStep undoes up to n instructions.
+func (s *Session) ReverseStep(n int) Stop {
+	stop := Stop{Reason: StopStep}
+	for i := 0; i < n; i++ {
+		if !s.undoOne() {
+			stop.Reason = StopHistory
+			break
+		}
+	}
+	s.c.Render()
+	stop.PC = s.c.pc
+	return s.stopped(stop)
+}
+
+// ReverseContinue undoes instructions until it reaches a breakpoint or the
+// start of recorded history. Like Continue, it always moves at least once so
+// it can leave a breakpoint.
+func (s *Session) ReverseContinue() Stop {
+	stop := Stop{Reason: StopHistory}
+	for s.undoOne() {
+		if s.bps[s.c.pc].matches(s.c) {
+			stop.Reason = StopBreakpoint
+			break
+		}
+	}
+	s.c.Render()
+	stop.PC = s.c.pc
+	return s.stopped(stop)
+}
+
+// Reset resets the machine and reloads the ROM.
+func (s *Session) Reset() error {
+	s.c.Reset()
+	if err := s.loadROM(); err != nil {
+		return err
+	}
+	s.rewind.Reset()
+	s.rewind.Capture(s.c)
+	s.undo.Reset()
+	s.c.Render()
+	s.changed()
+	return nil
+}
+
+// Rewind goes back n frames, returning how many it could.
+func (s *Session) Rewind(n int) int {
+	done := s.rewind.Rewind(s.c, n)
+	// The undo log no longer lines up with the machine
+	s.undo.Reset()
+	s.c.Render()
+	s.changed()
+	return done
+}
+
+// RewindLen returns how many frames can be rewound.
+func (s *Session) RewindLen() int {
+	return s.rewind.Len()
+}
+
+// SaveState writes the machine state to w.
+func (s *Session) SaveState(w io.Writer) error {
+	return s.c.SaveState(w)
+}
+
+// LoadState replaces the machine state with one read from r.
+func (s *Session) LoadState(r io.Reader) error {
+	if err := s.c.LoadState(r); err != nil {
+		return err
+	}
+	s.undo.Reset()
+	s.c.Render()
+	s.changed()
+	return nil
+}
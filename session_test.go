@@ -0,0 +1,191 @@
+package chip8
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestEndOfMemory checks the debugger shows and steps code at the last
+// addresses, where instructions wrap around to the font at 0.
+func TestEndOfMemory(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		q    Quirks
+		end  uint16
+	}{
+		{"chip8", Quirks{}, 0xFFF},
+		{"xochip", Quirks{XOChip: true}, 0xFFFF},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			out := &syncBuffer{}
+			d := NewDebugger("test.ch8", tc.q)
+			d.SetOutput(out)
+			d.SetROM(testROM)
+			if err := d.Attach(&NullDisplay{}, &NoKeypad{}); err != nil {
+				t.Fatal(err)
+			}
+			// LD V0 instructions, the last wrapping to the font at 0
+			d.c.mem[tc.end-2], d.c.mem[tc.end-1], d.c.mem[tc.end] = 0x60, 0x60, 0x60
+			for _, want := range []struct {
+				addr uint16
+				text string
+			}{
+				{tc.end - 1, "LD V0, 0x60"},
+				{tc.end, "LD V0, 0xF0"},
+			} {
+				if got := d.dis.dis(d.c.code(want.addr)).String(); got != want.text {
+					t.Errorf("dis at 0x%04X = %q, want %q", want.addr, got, want.text)
+				}
+			}
+			if got := d.c.fetch(tc.end); got != 0x60F0 {
+				t.Errorf("fetch(0x%04X) = 0x%04X, want 0x60F0", tc.end, got)
+			}
+
+			d.c.pc = tc.end - 1
+			d.printState()
+			d.printListing(tc.end - 1)
+			if line := "LD V0, 0x60"; !strings.Contains(out.String(), line) {
+				t.Errorf("state and listing do not show %q:\n%s", line, out.String())
+			}
+			d.Next()
+			d.c.pc = tc.end
+			d.Next()
+			if d.c.v[0] != 0xF0 {
+				t.Errorf("V0 = 0x%02X after wrapped LD, want 0xF0", d.c.v[0])
+			}
+		})
+	}
+}
+
+// TestBacktrace checks the frames of nested calls, innermost first.
+func TestBacktrace(t *testing.T) {
+	rom := []byte{
+		0x22, 0x04, // 0x200 CALL outer
+		0x12, 0x02, // 0x202 JP 0x202
+		0x22, 0x08, // 0x204 outer: CALL inner
+		0x00, 0xEE, // 0x206 RET
+		0x12, 0x08, // 0x208 inner: JP 0x208
+	}
+	var out syncBuffer
+	d := newTestDebugger(t, rom, &out)
+	syms := NewSymbols()
+	syms.Add("outer", 0x204)
+	syms.Add("inner", 0x208)
+	d.SetSymbols(syms)
+	d.Step()
+	d.Step()
+	if d.c.pc != 0x208 {
+		t.Fatalf("PC = 0x%04X, want 0x0208", d.c.pc)
+	}
+	if d.Depth() != 2 {
+		t.Errorf("Depth = %d, want 2", d.Depth())
+	}
+	want := []Frame{{0x204, 0x208, true}, {0x200, 0x204, true}}
+	if got := d.Backtrace(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Backtrace = %+v, want %+v", got, want)
+	}
+
+	handle(t, d, "bt", "frame 1")
+	for _, line := range []string{"#0 0x0204 → 0x0208 (inner)", "→ #1 0x0200 → 0x0204 (outer)"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("output doesn't have %q:\n%s", line, out.String())
+		}
+	}
+	if d.frame != 1 {
+		t.Errorf("frame = %d, want 1", d.frame)
+	}
+
+	// The outer call site overwritten since
+	d.c.mem[0x200] = 0x12
+	if f := d.Backtrace()[1]; f.Call {
+		t.Errorf("frame 1 = %+v, want not a call", f)
+	}
+}
+
+func TestReturnWithoutCall(t *testing.T) {
+	var out syncBuffer
+	d := newTestDebugger(t, []byte{0x00, 0xEE}, &out)
+	stop := d.Step()
+	if stop.Reason != StopError || stop.Err != ErrStackUnderflow {
+		t.Errorf("Step = %+v, want %v", stop, ErrStackUnderflow)
+	}
+	if d.c.pc != 0x200 {
+		t.Errorf("PC = 0x%04X after the failed RET, want 0x0200", d.c.pc)
+	}
+	handle(t, d, "bt")
+	if !strings.Contains(out.String(), "Not in a subroutine") {
+		t.Errorf("bt with an empty stack:\n%s", out.String())
+	}
+}
+
+// TestStackOverflow fills all 24 stack entries, then stops on the 25th call.
+func TestStackOverflow(t *testing.T) {
+	var out syncBuffer
+	d := newTestDebugger(t, []byte{0x22, 0x00}, &out) // 0x200 CALL 0x200
+	stop := d.Continue()
+	if stop.Reason != StopError || stop.Err != ErrStackOverflow {
+		t.Fatalf("Continue = %+v, want %v", stop, ErrStackOverflow)
+	}
+	if d.Depth() != 24 {
+		t.Errorf("Depth = %d after overflowing, want 24", d.Depth())
+	}
+	frames := d.Backtrace()
+	if len(frames) != 24 {
+		t.Fatalf("%d frames, want 24", len(frames))
+	}
+	for n, f := range frames {
+		if f != (Frame{0x200, 0x200, true}) {
+			t.Errorf("frame %d = %+v", n, f)
+		}
+	}
+	handle(t, d, "bt")
+	if !strings.Contains(out.String(), "The stack is full") {
+		t.Errorf("bt doesn't warn of the full stack:\n%s", out.String())
+	}
+}
+
+// TestReverseTrimsRewind checks reverse stepping drops the rewind frames and
+// cycles of the instructions it undoes, so they stay in step with the undo log.
+func TestReverseTrimsRewind(t *testing.T) {
+	var out syncBuffer
+	d := newTestDebugger(t, testROM, &out)
+	var states []machineState
+	for n := 0; n < 2*rewindFrameCycles+4; n++ {
+		var s machineState
+		d.c.snapshot(&s)
+		states = append(states, s)
+		d.Step()
+	}
+	if d.RewindLen() != 2 {
+		t.Fatalf("RewindLen = %d, want 2", d.RewindLen())
+	}
+
+	d.ReverseStep(5)
+	if d.cycles != 2*rewindFrameCycles-1 {
+		t.Errorf("cycles = %d after reverse stepping, want %d", d.cycles, 2*rewindFrameCycles-1)
+	}
+	if d.RewindLen() != 1 {
+		t.Errorf("RewindLen = %d after reverse stepping past a frame, want 1", d.RewindLen())
+	}
+	d.Step()
+	if d.RewindLen() != 2 {
+		t.Errorf("RewindLen = %d after stepping back onto the frame, want 2", d.RewindLen())
+	}
+
+	d.ReverseContinue()
+	if d.cycles != 0 {
+		t.Errorf("cycles = %d after reverse continuing to the start, want 0", d.cycles)
+	}
+	if d.RewindLen() != 0 {
+		t.Errorf("RewindLen = %d at the start, want 0", d.RewindLen())
+	}
+	// The capture at the start is all that's left to rewind to
+	d.c.v[0] = 0xFF
+	d.Rewind(1)
+	var got machineState
+	d.c.snapshot(&got)
+	if got != states[0] {
+		t.Error("rewinding didn't restore the starting state")
+	}
+}
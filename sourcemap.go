@@ -0,0 +1,136 @@
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SourceLocation is a line in a source file.
+type SourceLocation struct {
+	File string
+	Line int
+}
+
+func (l SourceLocation) String() string {
+	return fmt.Sprintf("%s:%d", l.File, l.Line)
+}
+
+// SourceMap maps instruction addresses to the source lines they came from. The
+// text format has one entry per line, `ADDR FILE:LINE`, with # comments.
+type SourceMap struct {
+	lines map[uint16]SourceLocation
+	addrs map[SourceLocation][]uint16
+}
+
+func NewSourceMap() *SourceMap {
+	return &SourceMap{
+		lines: make(map[uint16]SourceLocation),
+		addrs: make(map[SourceLocation][]uint16),
+	}
+}
+
+// Add records that the instruction at addr came from loc.
+func (m *SourceMap) Add(addr uint16, loc SourceLocation) {
+	if old, ok := m.lines[addr]; ok {
+		m.remove(addr, old)
+	}
+	m.lines[addr] = loc
+	m.addrs[loc] = append(m.addrs[loc], addr)
+}
+
+func (m *SourceMap) remove(addr uint16, loc SourceLocation) {
+	addrs := m.addrs[loc]
+	for i, a := range addrs {
+		if a == addr {
+			m.addrs[loc] = append(addrs[:i], addrs[i+1:]...)
+			break
+		}
+	}
+}
+
+// Line returns the source line of the instruction at addr.
+func (m *SourceMap) Line(addr uint16) (SourceLocation, bool) {
+	loc, ok := m.lines[addr]
+	return loc, ok
+}
+
+// Addrs returns the addresses of the instructions generated for a line, lowest
+// first. Files match on their base name if the full path doesn't.
+func (m *SourceMap) Addrs(loc SourceLocation) []uint16 {
+	addrs := m.addrs[loc]
+	if len(addrs) == 0 {
+		for l, a := range m.addrs {
+			if l.Line == loc.Line && filepath.Base(l.File) == filepath.Base(loc.File) {
+				addrs = a
+				break
+			}
+		}
+	}
+	out := append([]uint16(nil), addrs...)
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// Write writes the map in the text format read by ReadSourceMap.
+func (m *SourceMap) Write(w io.Writer) error {
+	addrs := make([]int, 0, len(m.lines))
+	for a := range m.lines {
+		addrs = append(addrs, int(a))
+	}
+	sort.Ints(addrs)
+	for _, a := range addrs {
+		if _, err := fmt.Fprintf(w, "0x%04X %s\n", a, m.lines[uint16(a)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadSourceMap parses the text format written by Write.
+func ReadSourceMap(r io.Reader) (*SourceMap, error) {
+	m := NewSourceMap()
+	s := bufio.NewScanner(r)
+	n := 0
+	for s.Scan() {
+		n++
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("source map line %d: expected ADDR FILE:LINE", n)
+		}
+		addr, err := strconv.ParseUint(fields[0], 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("source map line %d: bad address %q", n, fields[0])
+		}
+		loc := strings.TrimSpace(fields[1])
+		colon := strings.LastIndex(loc, ":")
+		if colon < 0 {
+			return nil, fmt.Errorf("source map line %d: expected FILE:LINE", n)
+		}
+		l, err := strconv.Atoi(loc[colon+1:])
+		if err != nil {
+			return nil, fmt.Errorf("source map line %d: bad line %q", n, loc[colon+1:])
+		}
+		m.Add(uint16(addr), SourceLocation{loc[:colon], l})
+	}
+	return m, s.Err()
+}
+
+// LoadSourceMap reads a source map file.
+func LoadSourceMap(filename string) (*SourceMap, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadSourceMap(f)
+}
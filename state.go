@@ -0,0 +1,177 @@
+package chip8
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// stateMagic starts every save state, followed by stateVersion.
+var stateMagic = [4]byte{'C', '8', 'S', 'T'}
+
+const stateVersion = 1
+
+var ErrBadState = errors.New("not a chip8 save state")
+
+// machineState is the on-disk layout of a save state. Every field is fixed
+// size so it can go through encoding/binary as is. Bump stateVersion whenever
+// it changes.
+type machineState struct {
+	Mem    [XO_MAX_MEM_ADDRESS]byte
+	Screen [HIRES_SCREEN_WIDTH * HIRES_SCREEN_HEIGHT]byte
+	Regs   registerState
+}
+
+// registerState is everything but the two big buffers.
+type registerState struct {
+	V       [16]byte
+	Stack   [24]uint16
+	SP      int32
+	I       uint16
+	PC      uint16
+	Delay   uint8
+	Sound   uint8
+	Hires   bool
+	RPL     [16]byte
+	Exited  bool
+	Planes  byte
+	Pattern [16]byte
+	Pitch   byte
+
+	ShiftVx   bool
+	LoadStore int32
+	JumpVx    bool
+	Clip      bool
+	VFReset   bool
+	XOChip    bool
+
+	// HasRNG is false when a custom source was used, whose state we can't
+	// capture.
+	HasRNG bool
+	RNG    uint64
+}
+
+func (c *Chip8) snapshot(s *machineState) {
+	s.Mem = c.mem
+	s.Screen = c.screen.buffer
+	c.snapshotRegs(&s.Regs)
+}
+
+func (c *Chip8) snapshotRegs(s *registerState) {
+	s.V = c.v
+	s.Stack = c.stack
+	s.SP = int32(c.sp)
+	s.I = c.i
+	s.PC = c.pc
+	s.Delay = c.delay
+	s.Sound = c.sound
+	s.Hires = c.screen.hires
+	s.RPL = c.rpl
+	s.Exited = c.exited
+	s.Planes = c.planes
+	s.Pattern = c.pattern
+	s.Pitch = c.pitch
+
+	s.ShiftVx = c.quirks.ShiftVx
+	s.LoadStore = int32(c.quirks.LoadStore)
+	s.JumpVx = c.quirks.JumpVx
+	s.Clip = c.quirks.Clip
+	s.VFReset = c.quirks.VFReset
+	s.XOChip = c.quirks.XOChip
+
+	s.HasRNG = c.src != nil
+	s.RNG = 0
+	if c.src != nil {
+		s.RNG = c.src.state
+	}
+}
+
+func (c *Chip8) restore(s *machineState) {
+	c.mem = s.Mem
+	c.screen = &myScreen{buffer: s.Screen}
+	c.restoreRegs(&s.Regs)
+}
+
+func (c *Chip8) restoreRegs(s *registerState) {
+	c.v = s.V
+	c.stack = s.Stack
+	c.sp = int(s.SP)
+	c.i = s.I
+	c.pc = s.PC
+	c.delay = s.Delay
+	c.sound = s.Sound
+	c.screen.hires = s.Hires
+	c.rpl = s.RPL
+	c.exited = s.Exited
+	c.planes = s.Planes
+	c.pattern = s.Pattern
+	c.pitch = s.Pitch
+
+	c.quirks = Quirks{
+		ShiftVx:   s.ShiftVx,
+		LoadStore: LoadStoreQuirk(s.LoadStore),
+		JumpVx:    s.JumpVx,
+		Clip:      s.Clip,
+		VFReset:   s.VFReset,
+		XOChip:    s.XOChip,
+	}
+
+	if s.HasRNG && c.src != nil {
+		c.src.state = s.RNG
+	}
+	c.RenderFlag = true
+}
+
+// SaveState writes the complete machine state to w.
+func (c *Chip8) SaveState(w io.Writer) error {
+	s := &machineState{}
+	c.snapshot(s)
+	if _, err := w.Write(stateMagic[:]); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint16(stateVersion)); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, s)
+}
+
+// LoadState replaces the machine state with one written by SaveState. The
+// machine is left untouched if the state can't be read.
+func (c *Chip8) LoadState(r io.Reader) error {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return err
+	}
+	if magic != stateMagic {
+		return ErrBadState
+	}
+	var version uint16
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return err
+	}
+	if version != stateVersion {
+		return fmt.Errorf("unsupported save state version %d", version)
+	}
+	s := &machineState{}
+	if err := binary.Read(r, binary.BigEndian, s); err != nil {
+		return err
+	}
+	if err := s.Regs.check(); err != nil {
+		return err
+	}
+	c.restore(s)
+	return nil
+}
+
+// check reports registers that would crash the machine, from a corrupt or
+// crafted state.
+func (s *registerState) check() error {
+	if s.SP < 0 || int(s.SP) > 2*len(s.Stack) || s.SP%2 != 0 {
+		return fmt.Errorf("bad save state: SP %d is outside the stack", s.SP)
+	}
+	if !s.XOChip && s.PC >= MAX_MEM_ADDRESS {
+		return fmt.Errorf("bad save state: PC 0x%04X is outside memory", s.PC)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package chip8
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// testROM draws digits and counts in V0 forever, calling a subroutine so the
+// stack is in use.
+var testROM = []byte{
+	0x60, 0x00, // LD V0, 0
+	0x22, 0x06, // CALL 0x206
+	0x12, 0x02, // JP 0x202
+	0xF0, 0x29, // LD F, V0
+	0xD0, 0x05, // DRW V0, V0, 5
+	0x70, 0x01, // ADD V0, 1
+	0x00, 0xEE, // RET
+}
+
+func newTestChip8(t *testing.T, q Quirks) *Chip8 {
+	c := NewChip8(&NullDisplay{}, &NoKeypad{}, q, WithSeed(1))
+	c.Reset()
+	if err := c.LoadROM(testROM); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	c := newTestChip8(t, Quirks{XOChip: true})
+	for i := 0; i < 50; i++ {
+		if err := c.RunOne(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := c.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.Bytes()
+
+	d := newTestChip8(t, Quirks{})
+	if err := d.LoadState(bytes.NewReader(saved)); err != nil {
+		t.Fatal(err)
+	}
+	var want, got machineState
+	c.snapshot(&want)
+	d.snapshot(&got)
+	if got != want {
+		t.Fatal("loaded state differs from the saved one")
+	}
+	// Both machines carry on the same way
+	for i := 0; i < 50; i++ {
+		c.RunOne()
+		d.RunOne()
+	}
+	if c.String() != d.String() {
+		t.Errorf("after loading, got %s, want %s", d, c)
+	}
+}
+
+func TestLoadBadState(t *testing.T) {
+	c := newTestChip8(t, Quirks{})
+	var buf bytes.Buffer
+	if err := c.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	good := buf.Bytes()
+	// regs is where the registers start
+	regs := 4 + 2 + XO_MAX_MEM_ADDRESS + HIRES_SCREEN_WIDTH*HIRES_SCREEN_HEIGHT
+	sp := regs + 16 + 24*2
+	pc := sp + 4 + 2
+
+	tests := []struct {
+		name   string
+		modify func(b []byte) []byte
+	}{
+		{"magic", func(b []byte) []byte { b[0] = 'X'; return b }},
+		{"version", func(b []byte) []byte { b[5]++; return b }},
+		{"truncated", func(b []byte) []byte { return b[:len(b)-1] }},
+		{"negative SP", func(b []byte) []byte {
+			binary.BigEndian.PutUint32(b[sp:], uint32(0xFFFFFFFE))
+			return b
+		}},
+		{"SP past the stack", func(b []byte) []byte { binary.BigEndian.PutUint32(b[sp:], 50); return b }},
+		{"odd SP", func(b []byte) []byte { binary.BigEndian.PutUint32(b[sp:], 47); return b }},
+		{"PC outside memory", func(b []byte) []byte { binary.BigEndian.PutUint16(b[pc:], 0x1000); return b }},
+	}
+	for _, tt := range tests {
+		b := tt.modify(append([]byte(nil), good...))
+		d := newTestChip8(t, Quirks{})
+		before := d.String()
+		if err := d.LoadState(bytes.NewReader(b)); err == nil {
+			t.Errorf("%s: loaded", tt.name)
+		}
+		if d.String() != before {
+			t.Errorf("%s: machine changed by a failed load", tt.name)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package chip8
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Symbols maps labels to addresses and back.
+type Symbols struct {
+	addrs  map[string]uint16
+	labels map[uint16]string
+}
+
+func NewSymbols() *Symbols {
+	return &Symbols{
+		addrs:  make(map[string]uint16),
+		labels: make(map[uint16]string),
+	}
+}
+
+// Add defines label at addr. An address with several labels is shown with the
+// first one added.
+func (s *Symbols) Add(label string, addr uint16) {
+	s.addrs[label] = addr
+	if _, ok := s.labels[addr]; !ok {
+		s.labels[addr] = label
+	}
+}
+
+// Addr returns the address of a label.
+func (s *Symbols) Addr(label string) (uint16, bool) {
+	if s == nil {
+		return 0, false
+	}
+	addr, ok := s.addrs[label]
+	return addr, ok
+}
+
+// Label returns the label shown for an address.
+func (s *Symbols) Label(addr uint16) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	label, ok := s.labels[addr]
+	return label, ok
+}
+
+// Len returns how many labels there are.
+func (s *Symbols) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.addrs)
+}
+
+// Symbol is a label and its address.
+type Symbol struct {
+	Label string
+	Addr  uint16
+}
+
+// All returns every label, sorted by address and then name.
+func (s *Symbols) All() []Symbol {
+	if s == nil {
+		return nil
+	}
+	syms := make([]Symbol, 0, len(s.addrs))
+	for label, addr := range s.addrs {
+		syms = append(syms, Symbol{label, addr})
+	}
+	sort.Slice(syms, func(i, j int) bool {
+		if syms[i].Addr != syms[j].Addr {
+			return syms[i].Addr < syms[j].Addr
+		}
+		return syms[i].Label < syms[j].Label
+	})
+	return syms
+}
+
+// Write writes the symbols in the text format read by ReadSymbols.
+func (s *Symbols) Write(w io.Writer) error {
+	for _, sym := range s.All() {
+		if _, err := fmt.Fprintf(w, "0x%04X %s\n", sym.Addr, sym.Label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadSymbols parses a symbol map. It is either a JSON object of labels to
+// addresses, or text with a label and an address on each line, in either
+// order and optionally separated by `=`, with # comments.
+func ReadSymbols(r io.Reader) (*Symbols, error) {
+	br := bufio.NewReader(r)
+	s := NewSymbols()
+	if b, err := br.Peek(1); err == nil && b[0] == '{' {
+		var labels map[string]uint16
+		if err := json.NewDecoder(br).Decode(&labels); err != nil {
+			return nil, fmt.Errorf("symbol map: %v", err)
+		}
+		for label, addr := range labels {
+			s.Add(label, addr)
+		}
+		return s, nil
+	}
+	sc := bufio.NewScanner(br)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := sc.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(strings.Replace(line, "=", " ", 1))
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("symbol map line %d: expected a label and an address", n)
+		}
+		label, value := fields[0], fields[1]
+		addr, err := strconv.ParseUint(value, 0, 16)
+		if err != nil {
+			label, value = value, label
+			if addr, err = strconv.ParseUint(value, 0, 16); err != nil {
+				return nil, fmt.Errorf("symbol map line %d: no address in %q", n, line)
+			}
+		}
+		s.Add(label, uint16(addr))
+	}
+	return s, sc.Err()
+}
+
+// LoadSymbols reads a symbol map file.
+func LoadSymbols(filename string) (*Symbols, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadSymbols(f)
+}
@@ -0,0 +1,101 @@
+package chip8
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadSymbols(t *testing.T) {
+	for _, tt := range []struct {
+		name, in string
+	}{
+		{"text", "# player\ndraw_player 0x206\n0x20A = move\n\nloop=0x202 # main loop\n"},
+		{"json", `{"draw_player": 518, "move": 522, "loop": 514}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ReadSymbols(strings.NewReader(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Len() != 3 {
+				t.Errorf("got %d symbols, want 3", s.Len())
+			}
+			for label, want := range map[string]uint16{"draw_player": 0x206, "move": 0x20A, "loop": 0x202} {
+				if addr, ok := s.Addr(label); !ok || addr != want {
+					t.Errorf("Addr(%q) = 0x%04X, %v, want 0x%04X", label, addr, ok, want)
+				}
+				if got, ok := s.Label(want); !ok || got != label {
+					t.Errorf("Label(0x%04X) = %q, %v, want %q", want, got, ok, label)
+				}
+			}
+		})
+	}
+}
+
+func TestReadSymbolsErrors(t *testing.T) {
+	for _, in := range []string{
+		"draw_player",
+		"draw_player 0x206 extra",
+		"draw_player player",
+		"draw_player 0x10000",
+		`{"draw_player": "0x206"}`,
+	} {
+		if _, err := ReadSymbols(strings.NewReader(in)); err == nil {
+			t.Errorf("ReadSymbols(%q) succeeded", in)
+		}
+	}
+}
+
+// newSymbolsDebugger returns a debugger for testROM with its subroutine at
+// 0x206 labelled draw_player, loaded from a file with the sym command.
+func newSymbolsDebugger(t *testing.T, out *syncBuffer) *Debugger {
+	t.Helper()
+	d := newTestDebugger(t, testROM, out)
+	filename := filepath.Join(t.TempDir(), "test.sym")
+	writeScript(t, filename, "draw_player 0x206", "loop 0x202")
+	handle(t, d, "sym "+filename)
+	if !strings.Contains(out.String(), "Loaded 2 symbols") {
+		t.Fatalf("sym didn't load symbols:\n%s", out.String())
+	}
+	return d
+}
+
+func TestParseAddrLabels(t *testing.T) {
+	var out syncBuffer
+	d := newSymbolsDebugger(t, &out)
+	for _, tt := range []struct {
+		in   string
+		want uint16
+	}{
+		{"0x300", 0x300},
+		{"768", 0x300},
+		{"draw_player", 0x206},
+		{"draw_player+4", 0x20A},
+		{"loop+0x2", 0x204},
+	} {
+		if got, err := d.parseAddr(tt.in); err != nil || got != tt.want {
+			t.Errorf("parseAddr(%q) = 0x%04X, %v, want 0x%04X", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"nowhere", "draw_player+x", "+4", "0x1000", "draw_player+0xFFF"} {
+		if _, err := d.parseAddr(in); err == nil {
+			t.Errorf("parseAddr(%q) succeeded", in)
+		}
+	}
+}
+
+func TestDisLabels(t *testing.T) {
+	var out syncBuffer
+	d := newSymbolsDebugger(t, &out)
+	if got, want := d.dis.dis(d.c.code(0x202)).String(), "CALL draw_player"; got != want {
+		t.Errorf("dis at 0x202 = %q, want %q", got, want)
+	}
+	if got, want := d.dis.dis(d.c.code(0x204)).String(), "JP loop"; got != want {
+		t.Errorf("dis at 0x204 = %q, want %q", got, want)
+	}
+	handle(t, d, "b draw_player")
+	if _, ok := d.Breakpoint(0x206, false); !ok {
+		t.Error("b draw_player didn't set a bp at 0x206")
+	}
+}
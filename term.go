@@ -15,14 +15,24 @@ func NewTerminal() (*Terminal, error) {
 }
 
 func (d *Terminal) Render(i IterableImage) {
-	i.OnEachPixel(func(x, y int, i WriteableImage) {
-		c := i.At(x, y)
-		if c != 0 {
-			termbox.SetCell(x, y, '\u2588', d.fg, d.bg)
-		} else {
-			termbox.SetCell(x, y, ' ', d.fg, d.bg)
+	// Colors for each of the four XO-CHIP pixel values
+	palette := [4]termbox.Attribute{d.bg, d.fg, termbox.ColorYellow, termbox.ColorRed}
+	// Clear first so switching resolution leaves nothing behind
+	termbox.Clear(d.fg, d.bg)
+	w, h := CellSize(i)
+	hires := h != i.Height()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var r rune
+			var fg, bg byte
+			if hires {
+				r, fg, bg = halfBlock(i.At(x, 2*y), i.At(x, 2*y+1))
+			} else {
+				r, fg, bg = halfBlock(i.At(x, y), i.At(x, y))
+			}
+			termbox.SetCell(x, y, r, palette[fg], palette[bg])
 		}
-	})
+	}
 
 	termbox.Flush()
 }
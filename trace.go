@@ -0,0 +1,230 @@
+package chip8
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TraceFormat is how a Tracer writes instructions.
+type TraceFormat int
+
+const (
+	// TraceText writes a line per instruction, for reading and diffing.
+	TraceText TraceFormat = iota
+	// TraceBinary writes a TraceRecord per instruction after a header.
+	TraceBinary
+)
+
+// TraceFormatByName returns the format called "text" or "binary".
+func TraceFormatByName(name string) (TraceFormat, error) {
+	switch name {
+	case "text":
+		return TraceText, nil
+	case "binary":
+		return TraceBinary, nil
+	}
+	return 0, fmt.Errorf("unknown trace format %q (have [binary text])", name)
+}
+
+// traceMagic starts every binary trace, followed by traceVersion.
+var traceMagic = [4]byte{'C', '8', 'T', 'R'}
+
+const traceVersion = 1
+
+var ErrBadTrace = errors.New("not a chip8 trace")
+
+// TraceRecord is an executed instruction and the machine state after it. It
+// is the on-disk layout of a binary trace, so every field is fixed size. Bump
+// traceVersion whenever it changes.
+type TraceRecord struct {
+	PC     uint16
+	Opcode uint16
+	V      [16]byte
+	I      uint16
+	SP     uint8
+	Delay  uint8
+	Sound  uint8
+}
+
+// traceRange is an inclusive range of addresses to trace.
+type traceRange struct {
+	start, end uint16
+}
+
+// Tracer writes every instruction a Chip8 runs. Install it with SetTracer.
+type Tracer struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+	format TraceFormat
+	ranges []traceRange
+	dis    Disassembler
+	// err is the first write error, after which nothing more is written
+	err error
+}
+
+// NewTracer returns a Tracer writing to w in the given format.
+func NewTracer(w io.Writer, format TraceFormat) *Tracer {
+	t := &Tracer{w: bufio.NewWriter(w), format: format}
+	if format == TraceBinary {
+		t.w.Write(traceMagic[:])
+		t.w.WriteByte(traceVersion)
+	}
+	return t
+}
+
+// CreateTrace returns a Tracer writing to a new file, which Close closes.
+func CreateTrace(filename string, format TraceFormat) (*Tracer, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	t := NewTracer(f, format)
+	t.closer = f
+	return t, nil
+}
+
+// Filter limits tracing to instructions between start and end inclusive.
+// Filters add up, and with none every instruction is traced.
+func (t *Tracer) Filter(start, end uint16) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ranges = append(t.ranges, traceRange{start, end})
+}
+
+func (t *Tracer) traced(pc uint16) bool {
+	if len(t.ranges) == 0 {
+		return true
+	}
+	for _, r := range t.ranges {
+		if pc >= r.start && pc <= r.end {
+			return true
+		}
+	}
+	return false
+}
+
+// trace writes the instruction in code, which ran at pc, and the state of c
+// after it.
+func (t *Tracer) trace(c *Chip8, pc uint16, code []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil || !t.traced(pc) {
+		return
+	}
+	rec := TraceRecord{
+		PC:     pc,
+		Opcode: binary.BigEndian.Uint16(code),
+		V:      c.v,
+		I:      c.i,
+		SP:     uint8(c.sp),
+		Delay:  c.delay,
+		Sound:  c.sound,
+	}
+	if t.format == TraceBinary {
+		t.err = binary.Write(t.w, binary.BigEndian, &rec)
+		return
+	}
+	_, t.err = fmt.Fprintf(t.w, "%04X %04X %-20s V:% X I:%04X SP:%02X DT:%02X ST:%02X\n",
+		rec.PC, rec.Opcode, t.dis.dis(code), rec.V, rec.I, rec.SP, rec.Delay, rec.Sound)
+}
+
+// Close flushes the trace, closing its file if it was made by CreateTrace.
+// Nothing more is traced after it.
+func (t *Tracer) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err := t.err
+	if err == nil {
+		err = t.w.Flush()
+	}
+	t.err = errors.New("trace closed")
+	if t.closer != nil {
+		if cerr := t.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// ReadTrace reads the records of a trace in either format.
+func ReadTrace(r io.Reader) ([]TraceRecord, error) {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(len(traceMagic)); err == nil && bytes.Equal(b, traceMagic[:]) {
+		return readBinaryTrace(br)
+	}
+	return readTextTrace(br)
+}
+
+func readBinaryTrace(br *bufio.Reader) ([]TraceRecord, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, ErrBadTrace
+	}
+	if header[4] != traceVersion {
+		return nil, fmt.Errorf("unsupported trace version %d", header[4])
+	}
+	var recs []TraceRecord
+	for {
+		var rec TraceRecord
+		err := binary.Read(br, binary.BigEndian, &rec)
+		if err == io.EOF {
+			return recs, nil
+		}
+		if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
+
+// readTextTrace parses the lines trace writes. The disassembly between the
+// opcode and the registers is skipped, since the opcode says the same.
+func readTextTrace(r io.Reader) ([]TraceRecord, error) {
+	var recs []TraceRecord
+	s := bufio.NewScanner(r)
+	n := 0
+	for s.Scan() {
+		n++
+		line := s.Text()
+		regs := strings.Index(line, " V:")
+		if regs < 0 {
+			return recs, ErrBadTrace
+		}
+		var rec TraceRecord
+		if _, err := fmt.Sscanf(line[:regs], "%04X %04X", &rec.PC, &rec.Opcode); err != nil {
+			return recs, fmt.Errorf("trace line %d: bad address or opcode", n)
+		}
+		fields := strings.Fields(strings.TrimPrefix(line[regs+1:], "V:"))
+		if len(fields) != len(rec.V)+4 {
+			return recs, fmt.Errorf("trace line %d: expected %d registers", n, len(rec.V))
+		}
+		for i := range rec.V {
+			v, err := strconv.ParseUint(fields[i], 16, 8)
+			if err != nil {
+				return recs, fmt.Errorf("trace line %d: bad V%X %q", n, i, fields[i])
+			}
+			rec.V[i] = byte(v)
+		}
+		tail := strings.Join(fields[len(rec.V):], " ")
+		if _, err := fmt.Sscanf(tail, "I:%04X SP:%02X DT:%02X ST:%02X", &rec.I, &rec.SP, &rec.Delay, &rec.Sound); err != nil {
+			return recs, fmt.Errorf("trace line %d: bad registers %q", n, tail)
+		}
+		recs = append(recs, rec)
+	}
+	return recs, s.Err()
+}
+
+// SetTracer makes c write every instruction it runs to t, or stops tracing if
+// t is nil.
+func (c *Chip8) SetTracer(t *Tracer) {
+	c.tracer = t
+}
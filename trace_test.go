@@ -0,0 +1,86 @@
+package chip8
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// runTraced runs n instructions of testROM, tracing them with t.
+func runTraced(t *testing.T, tr *Tracer, n int) {
+	t.Helper()
+	c := newTestChip8(t, Quirks{})
+	c.SetTracer(tr)
+	for i := 0; i < n; i++ {
+		if err := c.RunOne(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTraceRoundTrip(t *testing.T) {
+	var recs [2][]TraceRecord
+	for n, format := range []TraceFormat{TraceText, TraceBinary} {
+		var buf bytes.Buffer
+		runTraced(t, NewTracer(&buf, format), 12)
+		var err error
+		if recs[n], err = ReadTrace(&buf); err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+	}
+	text, bin := recs[0], recs[1]
+	if len(bin) != 12 {
+		t.Fatalf("read %d binary records, want 12", len(bin))
+	}
+	if !reflect.DeepEqual(text, bin) {
+		t.Errorf("text and binary traces differ:\ntext %+v\nbinary %+v", text, bin)
+	}
+	// CALL 0x206 from 0x202, then LD F, V0 with I set to the font
+	call, ld := bin[1], bin[2]
+	if call.PC != 0x202 || call.Opcode != 0x2206 || call.SP != 46 {
+		t.Errorf("call = %+v", call)
+	}
+	if ld.PC != 0x206 || ld.Opcode != 0xF029 || ld.I != 0 {
+		t.Errorf("ld = %+v", ld)
+	}
+	// ADD V0, 1 the first time round
+	if add := bin[4]; add.PC != 0x20A || add.V[0] != 1 {
+		t.Errorf("add = %+v", add)
+	}
+}
+
+func TestTraceFilter(t *testing.T) {
+	var buf bytes.Buffer
+	tr := NewTracer(&buf, TraceBinary)
+	tr.Filter(0x200, 0x201)
+	tr.Filter(0x208, 0x20A)
+	runTraced(t, tr, 12)
+	recs, err := ReadTrace(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pcs []uint16
+	for _, r := range recs {
+		pcs = append(pcs, r.PC)
+	}
+	want := []uint16{0x200, 0x208, 0x20A, 0x208, 0x20A}
+	if !reflect.DeepEqual(pcs, want) {
+		t.Errorf("traced % X, want % X", pcs, want)
+	}
+}
+
+func TestReadTraceErrors(t *testing.T) {
+	for _, in := range []string{
+		"C8TR\x02",
+		"C8T",
+		"not a trace\n",
+		"0200 6000 LD V0, 0x00 V:00 I:0000 SP:30 DT:00 ST:00\n",
+	} {
+		if _, err := ReadTrace(bytes.NewBufferString(in)); err == nil {
+			t.Errorf("ReadTrace(%q) succeeded", in)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package chip8
+
+// undoWindow is how many bytes at I an instruction can write: FX55 and 5XY2
+// store at most 16 registers.
+const undoWindow = 16
+
+// undoEntry holds what one instruction changed.
+type undoEntry struct {
+	regs    registerState
+	memAddr uint16
+	mem     []run
+	screen  []run
+}
+
+// UndoLog records every instruction it runs so execution can be stepped
+// backwards one instruction at a time.
+type UndoLog struct {
+	entries []undoEntry
+	head    int
+	count   int
+	// Scratch copies of what an instruction might change
+	mem    [undoWindow]byte
+	after  [undoWindow]byte
+	screen [HIRES_SCREEN_WIDTH * HIRES_SCREEN_HEIGHT]byte
+}
+
+// NewUndoLog returns an UndoLog that remembers the last n instructions.
+func NewUndoLog(n int) *UndoLog {
+	return &UndoLog{entries: make([]undoEntry, n)}
+}
+
+// Len returns how many instructions can be undone.
+func (u *UndoLog) Len() int {
+	return u.count
+}
+
+// Reset forgets all recorded instructions, for when the machine state is
+// replaced wholesale.
+func (u *UndoLog) Reset() {
+	for i := range u.entries {
+		u.entries[i] = undoEntry{}
+	}
+	u.head = 0
+	u.count = 0
+}
+
+// Step runs one instruction on c, recording how to undo it.
+func (u *UndoLog) Step(c *Chip8) error {
+	var e undoEntry
+	c.snapshotRegs(&e.regs)
+	e.memAddr = c.i
+	for n := range u.mem {
+		u.mem[n] = c.mem[e.memAddr+uint16(n)]
+	}
+	u.screen = c.screen.buffer
+
+	err := c.RunOne()
+	// Failed instructions don't change anything, except for exiting
+	if len(u.entries) == 0 || (err != nil && c.exited == e.regs.Exited) {
+		return err
+	}
+
+	for n := range u.after {
+		u.after[n] = c.mem[e.memAddr+uint16(n)]
+	}
+	e.mem = diffRuns(u.after[:], u.mem[:])
+	e.screen = diffRuns(c.screen.buffer[:], u.screen[:])
+	u.entries[u.head] = e
+	u.head = (u.head + 1) % len(u.entries)
+	if u.count < len(u.entries) {
+		u.count++
+	}
+	return err
+}
+
+// Undo reverts the last instruction run by Step, returning false if there is
+// nothing left to undo.
+func (u *UndoLog) Undo(c *Chip8) bool {
+	if u.count == 0 {
+		return false
+	}
+	u.head = (u.head - 1 + len(u.entries)) % len(u.entries)
+	e := u.entries[u.head]
+	u.entries[u.head] = undoEntry{}
+	u.count--
+
+	for _, r := range e.mem {
+		for n, b := range r.data {
+			c.mem[e.memAddr+uint16(r.off+n)] = b
+		}
+	}
+	applyRuns(c.screen.buffer[:], e.screen)
+	c.restoreRegs(&e.regs)
+	return true
+}